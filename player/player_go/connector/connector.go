@@ -1,176 +1,229 @@
 package connector
 
+import "io"
+import "os"
 import "fmt"
 import "net"
 import "sync"
-import "strconv"
+import "time"
+import "errors"
 import "strings"
 
 import "voda/game"
+import "voda/protocol"
+
+// 切断後、再接続を試みる期間と間隔
+const (
+  RECONNECT_TIMEOUT time.Duration = 30 * time.Second
+  RECONNECT_INTERVAL time.Duration = 500 * time.Millisecond
+)
 
 /*
 #ConnectToGame
 ゲームに接続
+・ゲームから受け取ったセッショントークンがあれば、切断後に再接続を試みる
+・再接続できない場合はquitとして扱う
 
 *引数
 msg_channel chan string         : 受信したメッセージ送信用のチャネル
 ret_channel chan game.PlayerRet : 送信するメッセージ受信用のチャネル
 port string                     : 通信に用いるポート番号
+options Options                 : 接続の設定(セッショントークン、ロビーで希望する対局)
 wg *sync.WaitGroup              : 並行処理管理のためのWaitGroup
 */
-func ConnectToGame(msg_channel chan game.PlayerParam, ret_channel chan game.PlayerRet, port uint, wg *sync.WaitGroup) {
-  // 処理終了時にWaitGroupのカウンタを1減ず
+func ConnectToGame(msg_channel chan game.PlayerParam, ret_channel chan game.PlayerRet, port uint, options Options, wg *sync.WaitGroup) {
   defer wg.Done();
+  var session string = options.Session;
 
   // 指定ポート番号での接続
   conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
-  if err != nil {
-  }
-
-  for {
-    // ゲームから送信されたメッセージの受信
-    buf := make([]byte, 1024)
-    count, err := conn.Read(buf)
-    if err != nil {
-      wg.Done()
-    }
-
-    // 受信したメッセージをプレイヤーのプロセスへ送信
-    msg_channel <- decodeMessage(string(buf[:count]));
-    // quitの場合、処理を終了する
-    if string(buf[:count]) == "quit" {
-      break
-    }
-
-    // メッセージを構成し送信
-    count, err = conn.Write([]byte(buildRetMsg(<-ret_channel)))
-    if err != nil {
-      break;
-    }
+  for err == nil {
+    var quit bool = communicate(msg_channel, ret_channel, conn, conn, &session, options.Game);
+    conn.Close();
+    if (quit) { return; }
+
+    // session機能に対応しないゲームには再接続しない
+    if (session == "") { break; }
+    fmt.Fprintln(os.Stderr, "connection lost, reconnecting");
+    conn, err = redial(port);
   }
+  // 接続できない場合はquitとして扱う
+  if (err != nil) { fmt.Fprintln(os.Stderr, err); }
+  msg_channel <- game.PlayerParam{ Command: "quit" };
 }
 
 /*
-#DecodeMessage
-受け取ったメッセージからPlayerParamを構成
+#redial
+再接続を試みる
 
 *引数
-msg string: ゲームから受け取ったメッセージ
+port uint: 通信に用いるポート番号
 
 *返り値
-PlayerParam: メッセージから生成したPlayerParam構造体
+net.Conn: 接続
+error   : RECONNECT_TIMEOUTの間に接続できない場合のエラー
 */
-func decodeMessage(msg string) game.PlayerParam {
-  // メッセージを分割
-  var msg_words []string = strings.Split(msg, " ");
-
-  // メッセージの最初の単語をコマンド、残りを引数
-  var command string;
-  command = msg_words[0];
-  var param_words []string = msg_words[1:];
-
-  var param game.PlayerParam;
-  param.Command = command;
-
-  switch command {
-  case "name": // nameコマンドは引数なし
-    break;
-  case "start":
-    // 先後を受け取る
-    var side string = param_words[0];
-    if (side == "black") {
-      param.Turn = true;
-    } else {
-      param.Turn = false;
-    }
-  case "go":
-    return buildGoPlayerParam(param_words);
-  case "end":
-    // 終了コードを設定
-    var result string = param_words[0];
-    switch result {
-    case "win": param.Result = 0;
-    case "lose": param.Result = 1;
-    case "draw": param.Result = 2;
-    default: param.Result = 255;
-    }
+func redial(port uint) (net.Conn, error) {
+  var deadline time.Time = time.Now().Add(RECONNECT_TIMEOUT);
+  for {
+    conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port));
+    if (err == nil || time.Now().After(deadline)) { return conn, err; }
+    time.Sleep(RECONNECT_INTERVAL);
   }
-
-  return param;
 }
 
 /*
-#buildRetMsg
-PlayerRetからゲームに送信するメッセージを生成
+#ConnectToStdio
+標準入出力を介してゲームとやり取りする
+・再接続は行わない(ゲームが子プロセスを起動し直す)
 
 *引数
-ret PlayerRet: メッセージ生成元
-
-*返り値
-string: 生成したメッセージ
+msg_channel chan string         : 受信したメッセージ送信用のチャネル
+ret_channel chan game.PlayerRet : 送信するメッセージ受信用のチャネル
+reader io.Reader                : ゲームからの入力
+writer io.Writer                : ゲームへの出力
+wg *sync.WaitGroup              : 並行処理管理のためのWaitGroup
 */
-func buildRetMsg(ret game.PlayerRet) string {
-  var command string = ret.Command;
-
-  switch command {
-  case "setname":
-    return fmt.Sprintf("setname %s", ret.Name);
-  case "ready":
-    return "ready";
-  case "move":
-    return fmt.Sprintf("move %d", ret.Move);
-  case "bye":
-    return fmt.Sprintf("bye");
-  default:
+func ConnectToStdio(
+  msg_channel chan game.PlayerParam, ret_channel chan game.PlayerRet,
+  reader io.Reader, writer io.Writer, wg *sync.WaitGroup,
+) {
+  defer wg.Done();
+
+  var session string;
+  if (!communicate(msg_channel, ret_channel, reader, writer, &session, "")) {
+    // 入力が閉じられた場合はquitとして扱う
+    msg_channel <- game.PlayerParam{ Command: "quit" };
   }
-  return ""
 }
 
 /*
-#buildGoPlayerParam
-goコマンドに対するPlayerParamを構成
+#communicate
+ゲームとメッセージをやり取りする
+・メッセージは改行で区切る(voda/protocol)
+・解釈できない行は読み捨てる
+・nameへの応答でプロトコルのバージョンと対応する機能を通知する
+・nameでセッショントークンを受け取った場合は保持し、以前のトークンがあればsetnameで提示する
+・双方がjson機能に対応する場合、setnameの後はJSON形式でやり取りする
+  プレイヤー関数がsetnameに機能を付けた場合はそれを用いる(json機能を付けなければテキスト形式)
+・双方がinfo機能に対応する場合、goのparam.Infoに渡されたinfoをmoveの前に送る
 
 *引数
-param_words []string: goコマンドの引数
+msg_channel chan string         : 受信したメッセージ送信用のチャネル
+ret_channel chan game.PlayerRet : 送信するメッセージ受信用のチャネル
+reader io.Reader                : ゲームからの入力
+writer io.Writer                : ゲームへの出力
+session *string                 : セッショントークン
+game string                     : ロビーで希望する対局(空の場合は伝えない)
 
 *返り値
-PlayerParam: goコマンドに対するPlayerParam構造体
+bool: quitを受け取ったか(falseは切断された)
 */
-func buildGoPlayerParam(param_words []string) game.PlayerParam {
-  var param game.PlayerParam;
-  param.Command = "go";
-
-  var stones uint64;
-  var opp_stones uint64;
-  var valid_moves []uint8;
-  var moves []uint8;
-
-  // 石の配置を取得
-  stones_int, err := strconv.Atoi(param_words[0]);
-  if (err==nil) { stones = uint64(stones_int); }
-  opp_stones_int, err := strconv.Atoi(param_words[1]);
-  if (err==nil) { opp_stones = uint64(opp_stones_int); }
-
-  // 着手可能な手の一覧を取得
-  for _, m:=range strings.Split(param_words[2], "") {
-    move_int, err := strconv.Atoi(m);
-    if (err==nil){
-      valid_moves = append(valid_moves, uint8(move_int));
+func communicate(
+  msg_channel chan game.PlayerParam, ret_channel chan game.PlayerRet,
+  reader io.Reader, writer io.Writer, session *string, game_name string,
+) bool {
+  var msg_reader *protocol.Reader = protocol.NewReader(reader);
+  var msg_writer *protocol.Writer = protocol.NewWriter(writer);
+  // nameへの応答まではテキスト形式
+  var codec protocol.Codec = protocol.Codec{};
+  // 双方が対応する機能
+  var caps []string = []string{};
+
+  for {
+    // ゲームから送信されたメッセージの受信
+    msg, err := msg_reader.ReadMessage();
+    if (err == protocol.ErrLineTooLong || errors.As(err, new(*protocol.ParseError))) {
+      fmt.Fprintln(os.Stderr, err);
+      continue;
+    }
+    if (err != nil) {
+      return false;
+    }
+    if (strings.TrimSpace(msg) == "") { continue; }
+
+    // 受信したメッセージをPlayerParamに変換
+    param, err := codec.DecodeParam(msg);
+    if (err != nil) {
+      // 解釈できないメッセージは読み捨てる
+      fmt.Fprintln(os.Stderr, err);
+      continue;
+    }
+
+    // プレイヤー関数がgo中に渡したinfoは、応答と同じチャネルで受け取る
+    if (param.Command == protocol.CMD_GO && protocol.HasCapability(caps, protocol.CAP_INFO)) {
+      param.Info = func(info game.PlayerRet) {
+        info.Command = protocol.CMD_INFO;
+        ret_channel <- info;
+      };
+    }
+
+    // プレイヤーのプロセスへ送信
+    msg_channel <- param;
+    // quitの場合、処理を終了する
+    if (param.Command == protocol.CMD_QUIT) {
+      return true;
+    }
+
+    // 以前のセッショントークンを保持し、新たなトークンを受け取る
+    var resume string = *session;
+    if (param.Command == protocol.CMD_NAME && param.Session != "") {
+      *session = param.Session;
+      fmt.Fprintln(os.Stderr, "session:", param.Session);
+    }
+
+    // infoはmoveの前に送る(送信に失敗してもmoveまで受け取り、次の接続に残さない)
+    var ret game.PlayerRet = <-ret_channel;
+    var info_err error;
+    for ret.Command == protocol.CMD_INFO {
+      if (info_err == nil) { info_err = writeRet(codec, msg_writer, ret); }
+      ret = <-ret_channel;
+    }
+    if (info_err != nil) {
+      fmt.Fprintln(os.Stderr, info_err);
+      return false;
+    }
+    // nameへの応答にはこのライブラリのバージョンと機能を付ける
+    if (ret.Command == protocol.CMD_SETNAME && ret.Version == 0) {
+      ret.Version = protocol.VERSION;
+      ret.Capabilities = protocol.CAPABILITIES;
+    }
+    // 再接続の場合は以前のトークンを提示する
+    if (ret.Command == protocol.CMD_SETNAME && param.Session == "") {
+      ret.Session = resume;
+    }
+    if (ret.Command == protocol.CMD_SETNAME && ret.Game == "") {
+      ret.Game = game_name;
+    }
+
+    // メッセージを構成し送信
+    if err := writeRet(codec, msg_writer, ret); err != nil {
+      fmt.Fprintln(os.Stderr, err);
+      return false;
     }
-  }
 
-  // 移動履歴を取得
-  for _, m:=range strings.Split(param_words[3], "") {
-    move_int, err := strconv.Atoi(m);
-    if (err == nil) {
-      moves = append(moves, uint8(move_int));
+    // setnameの送信後、双方が対応する機能に応じてメッセージの形式を切り替える
+    if (param.Command == protocol.CMD_NAME) {
+      caps = protocol.Negotiate(param.Capabilities, ret.Capabilities);
+      codec = protocol.NewCodec(caps);
     }
   }
+}
+
+/*
+#writeRet
+応答をメッセージに変換して送信する
 
-  param.Stones = stones;
-  param.OppStones = opp_stones;
-  param.ValidMoves = valid_moves;
-  param.Moves = moves;
+*引数
+codec protocol.Codec     : メッセージの形式
+writer *protocol.Writer  : ゲームへの書き込み
+ret game.PlayerRet       : 送信する応答
 
-  return param;
+*返り値
+error: 変換、送信のエラー
+*/
+func writeRet(codec protocol.Codec, writer *protocol.Writer, ret game.PlayerRet) error {
+  msg, err := codec.EncodeRet(ret);
+  if (err != nil) { return err; }
+  return writer.WriteMessage(msg);
 }
@@ -1,9 +1,19 @@
 package connector
 
+import "os"
 import "sync"
 
 import "voda/game"
 
+/*
+#Options
+ゲームへの接続の設定
+*/
+type Options struct {
+  Session string // 再開するセッションのトークン(空の場合は新規)
+  Game string    // ロビーで希望する対局(空の場合はいずれでもよい)
+}
+
 /*
 #Play
 通信プロセスとプレイヤー関数とのやり取りを担う
@@ -12,8 +22,9 @@ import "voda/game"
 *引数
 player func(PlayerParam) PlayerRet: プレイヤー関数
 port string                       : 通信に用いるポート番号
+options Options                   : 接続の設定
 */
-func Play(player func(game.PlayerParam) game.PlayerRet, port uint) {
+func Play(player func(game.PlayerParam) game.PlayerRet, port uint, options Options) {
   // 通信は別プロセスで起動
   var wg *sync.WaitGroup = new(sync.WaitGroup)
   wg.Add(1)
@@ -23,8 +34,55 @@ func Play(player func(game.PlayerParam) game.PlayerRet, port uint) {
   var ret_channel chan game.PlayerRet = make(chan game.PlayerRet);
 
   // ゲームとの通信のためのプロセスを起動
-  go ConnectToGame(msg_channel, ret_channel, port, wg);
+  go ConnectToGame(msg_channel, ret_channel, port, options, wg);
+
+  runPlayer(player, msg_channel, ret_channel);
+
+  wg.Wait();
+}
+
+/*
+#PlayStdio
+標準入出力を介してゲームとやり取りする
+・ゲームが子プロセスとして起動した場合に用いる
+・プレイヤー関数の標準出力への出力は標準エラー出力へ回す
+
+*引数
+player func(PlayerParam) PlayerRet: プレイヤー関数
+*/
+func PlayStdio(player func(game.PlayerParam) game.PlayerRet) {
+  // 標準出力はゲームとの通信に用いるため、プレイヤー関数の出力は標準エラー出力へ
+  var stdout *os.File = os.Stdout;
+  os.Stdout = os.Stderr;
+
+  var wg *sync.WaitGroup = new(sync.WaitGroup)
+  wg.Add(1)
+
+  var msg_channel chan game.PlayerParam = make(chan game.PlayerParam);
+  var ret_channel chan game.PlayerRet = make(chan game.PlayerRet);
+
+  go ConnectToStdio(msg_channel, ret_channel, os.Stdin, stdout, wg);
+
+  runPlayer(player, msg_channel, ret_channel);
+
+  wg.Wait();
+}
+
+/*
+#runPlayer
+通信プロセスから受け取ったパラメータをプレイヤー関数に渡し、応答を返す
+・quitを受け取るまで繰り返す
 
+*引数
+player func(PlayerParam) PlayerRet: プレイヤー関数
+msg_channel chan game.PlayerParam : 受信したメッセージを受け取るチャネル
+ret_channel chan game.PlayerRet   : 応答を送信するチャネル
+*/
+func runPlayer(
+  player func(game.PlayerParam) game.PlayerRet,
+  msg_channel chan game.PlayerParam,
+  ret_channel chan game.PlayerRet,
+) {
   var param game.PlayerParam;
   for {
     // 通信プロセスからメッセージを受け取り
@@ -39,7 +97,5 @@ func Play(player func(game.PlayerParam) game.PlayerRet, port uint) {
     var ret game.PlayerRet = player(param);
     ret_channel <- ret;
   }
-
-  wg.Wait();
 }
 
@@ -23,7 +23,7 @@ func g0F(param game.PlayerParam) game.PlayerRet {
   switch (command) {
   case "name":
     return retPlayerName("g0F-Go");
-  case "start":
+  case "start", "undo":
     return game.PlayerRet{ Command: "ready" };
   case "go":
     return g0FChoiceMove(param);
@@ -38,6 +38,7 @@ func g0F(param game.PlayerParam) game.PlayerRet {
 /*
 #g0FChoiceMove
 乱択アルゴリズムにより手を選択
+・1列のプレイアウトを終えるごとに、それまでの列ごとの勝率をinfoで送る(info機能に対応する場合)
 
 *引数
 param game.PlayerParam: ゲームから受信したパラメータ
@@ -60,8 +61,10 @@ func g0FChoiceMove(param game.PlayerParam) game.PlayerRet {
   // 各手に対するプレイの回数
   const TIMES int = 500;
   var move_count int = len(param.Moves) + 1;
+  // 列ごとの勝率(未評価、置けない列は-1)
+  var rates []float64 = []float64{ -1, -1, -1, -1, -1, -1, -1 };
 
-  for _, move := range valid_moves {
+  for n, move := range valid_moves {
     result_tbl[move] = &[3]uint{ 0, 0, 0 };
 
     stones = param.Stones;
@@ -80,6 +83,11 @@ func g0FChoiceMove(param game.PlayerParam) game.PlayerRet {
       result_tbl[move][result]++;
     }
     fmt.Println(move, result_tbl[move]);
+
+    rates[move] = float64(result_tbl[move][(move_count+1)%2]) / float64(TIMES);
+    if (param.Info != nil) {
+      param.Info(game.PlayerRet{ Nodes: int64((n+1) * TIMES), Rates: append([]float64{}, rates...) });
+    }
   }
 
   if (move_count%2 == 1) {
@@ -89,11 +97,15 @@ func g0FChoiceMove(param game.PlayerParam) game.PlayerRet {
   }
   next_move = valid_moves[0];
 
-  fmt.Println(next_move, "\n");
+  fmt.Println(next_move);
+  fmt.Println();
 
+  // プレイアウトの勝数をコメントとして返す(json機能でのみ送信される)
+  var wins uint = result_tbl[next_move][(move_count+1)%2];
   return game.PlayerRet {
     Command: "move",
     Move: next_move,
+    Comment: fmt.Sprintf("playout %d/%d", wins, TIMES),
   };
 }
 
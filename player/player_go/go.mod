@@ -4,4 +4,4 @@ go 1.21.7
 
 replace voda => ../../voda
 
-require voda v0.0.0-00010101000000-000000000000 // indirect
+require voda v0.0.0-00010101000000-000000000000
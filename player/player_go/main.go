@@ -15,6 +15,12 @@ func main() {
     g0F: g0F             --- 乱択アルゴリズム
   */
   var player *string = flag.String("player", "random", "player");
+  // --stdioで標準入出力を介して接続(ゲームが子プロセスとして起動する場合)
+  var stdio *bool = flag.Bool("stdio", false, "communicate over stdin/stdout");
+  // --sessionで異常終了した対局に再接続(ゲームから受け取ったセッショントークンを指定)
+  var session *string = flag.String("session", "", "session token to resume a game after a crash");
+  // --gameでロビーで希望する対局を設定
+  var game_name *string = flag.String("game", "", "game to ask for in the lobby (empty: any)");
 
   flag.Parse();
 
@@ -26,10 +32,14 @@ func main() {
   case "g0F":
     player_func = g0F;
   default:
-    fmt.Println(fmt.Sprintf("Unknown Player Name `%s`", player));
+    fmt.Println(fmt.Sprintf("Unknown Player Name `%s`", *player));
     return;
   }
 
   // ゲームに接続
-  connector.Play(player_func, uint(*port));
+  if (*stdio) {
+    connector.PlayStdio(player_func);
+    return;
+  }
+  connector.Play(player_func, uint(*port), connector.Options{ Session: *session, Game: *game_name });
 }
@@ -20,7 +20,7 @@ func randomPlayer(param game.PlayerParam) game.PlayerRet {
   switch (command) {
   case "name":
     return retPlayerName("RandomPlayer-Go");
-  case "start":
+  case "start", "undo":
     return game.PlayerRet{ Command: "ready" };
   case "go":
     return randomChoiceMove(param);
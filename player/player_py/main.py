@@ -2,7 +2,7 @@ import sys
 import argparse
 
 from g0F import g0F
-from play import play
+from play import play, play_stdio
 from random_player import random_player
 
 if __name__ == "__main__":
@@ -10,6 +10,8 @@ if __name__ == "__main__":
     parser = argparse.ArgumentParser()
     parser.add_argument("--port", default=8000, type=int)
     parser.add_argument("--player", default="random", type=str)
+    # 標準入出力を介して接続(ゲームが子プロセスとして起動する場合)
+    parser.add_argument("--stdio", action="store_true")
 
     args = parser.parse_args()
 
@@ -24,4 +26,7 @@ if __name__ == "__main__":
         print(f"Unknown Player Name {args.player}")
         sys.exit(0)
 
-    play(player_func, player_name, args.port)
+    if args.stdio:
+        play_stdio(player_func, player_name)
+    else:
+        play(player_func, player_name, args.port)
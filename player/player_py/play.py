@@ -1,3 +1,4 @@
+import sys
 import socket
 
 # ゲームとプレイヤー関数のやり取り
@@ -5,10 +6,16 @@ def play(player_func, name, port):
     # 指定ポート番号に接続
     client = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
     client.connect(("localhost", port))
+    # メッセージは改行で区切られる
+    reader = client.makefile("r", encoding="utf-8", newline="\n")
 
     while True:
         # ゲームからのメッセージを受信
-        msg = client.recv(1024).decode("utf-8")
+        msg = read_message(reader)
+        if (msg is None):
+            break
+        if (msg.strip() == ""):
+            continue
 
         command = msg.split()[0]
         # 送信されたコマンドごとの処理
@@ -24,14 +31,56 @@ def play(player_func, name, port):
             break
 
         # レスポンスを送信
-        client.sendall(res.encode("utf-8"))
+        client.sendall((res + "\n").encode("utf-8"))
 
     # 通信終了
+    reader.close()
     client.close()
 
+# 標準入出力を介したゲームとプレイヤー関数のやり取り
+# ゲームが子プロセスとして起動した場合に用いる(1行に1メッセージ)
+def play_stdio(player_func, name):
+    while True:
+        msg = read_message(sys.stdin)
+        if (msg is None):
+            break
+        if (msg.strip() == ""):
+            continue
+
+        command = msg.split()[0]
+        # 送信されたコマンドごとの処理
+        if (command == "name"):
+            res = ret_player_name(name)
+        elif (command == "go"):
+            res = build_next_move_response(msg, player_func)
+        elif (command == "start"):
+            res = "ready"
+        elif (command == "end"):
+            res = "bye"
+        elif (command == "quit"):
+            break
+
+        # レスポンスを送信
+        sys.stdout.write(res + "\n")
+        sys.stdout.flush()
+
+# 1行分のメッセージを受信(改行を除く)
+# 入力が閉じられた場合、又は長すぎる行の場合はNone
+MAX_LINE_LENGTH = 4096
+def read_message(reader):
+    line = reader.readline(MAX_LINE_LENGTH + 2)
+    if (line == "" or not line.endswith("\n")):
+        return None
+    return line.rstrip("\r\n")
+
+# 対応するプロトコルのバージョンと機能
+PROTOCOL_VERSION = 2
+CAPABILITIES = ["reason"]
+
 # プレイヤー名を設定するためのレスポンスを設定
+# プロトコルのバージョンと対応する機能を付ける
 def ret_player_name(name):
-    return f"setname {name}"
+    return f"setname {name} {PROTOCOL_VERSION} {','.join(CAPABILITIES) or '-'}"
 
 # 次の手を返すためのレスポンスを構成
 def build_next_move_response(msg, player_func):
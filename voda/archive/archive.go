@@ -0,0 +1,227 @@
+package archive
+
+import "os"
+import "fmt"
+import "bufio"
+import "time"
+import "strings"
+import "encoding/json"
+
+import "voda/record"
+
+/*
+#archive
+終局した対局の保管庫
+・1行に1局、棋譜(record.Record)をJSONとして追記する(JSON Lines)
+・条件(Filter)に合う対局を検索する
+*/
+
+// 検索条件
+// 空文字列、nilの項目は条件としない
+type Filter struct {
+  Player string // いずれかの手番のプレイヤー名
+  Black string  // 先手プレイヤー名
+  White string  // 後手プレイヤー名
+
+  Result *uint8 // 結果(record.RESULT_*)
+  PlayerResult string // Playerから見た結果(win, lose, draw)
+
+  Opening []uint8 // 初手からの手順の接頭辞
+  DateFrom string // 対局日の下限(2006.01.02形式、その日を含む)
+  DateTo string   // 対局日の上限(2006.01.02形式、その日を含む)
+  Termination string // 終局理由
+  Assisted *bool     // 補助(ヒント、脅威の表示)を有効にした対局か
+}
+
+/*
+#Append
+棋譜を保管庫に追記する
+
+*引数
+path string     : 保管庫のファイル
+r record.Record: 追記する棋譜
+
+*返り値
+error: 書き込みのエラー
+*/
+func Append(path string, r record.Record) error {
+  line, err := json.Marshal(r);
+  if (err != nil) { return err; }
+
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644);
+  if (err != nil) { return err; }
+  defer file.Close();
+
+  _, err = file.Write(append(line, '\n'));
+  return err;
+}
+
+/*
+#Query
+保管庫から条件に合う棋譜を取り出す
+
+*引数
+path string  : 保管庫のファイル
+filter Filter: 検索条件
+
+*返り値
+[]record.Record: 条件に合う棋譜のリスト(追記順)
+error          : 不正な検索条件、読み込み、解釈のエラー
+*/
+func Query(path string, filter Filter) ([]record.Record, error) {
+  if err := filter.Validate(); err != nil { return nil, err; }
+
+  file, err := os.Open(path);
+  if (err != nil) { return nil, err; }
+  defer file.Close();
+
+  var records []record.Record;
+  var scanner *bufio.Scanner = bufio.NewScanner(file);
+  // 長い棋譜でも1行に収まるようバッファを拡張
+  scanner.Buffer(make([]byte, 64*1024), 16*1024*1024);
+
+  var line_no int = 0;
+  for scanner.Scan() {
+    line_no++;
+    var line string = strings.TrimSpace(scanner.Text());
+    if (line == "") { continue; }
+
+    var r record.Record;
+    if err := json.Unmarshal([]byte(line), &r); err != nil {
+      return nil, fmt.Errorf("%s:%d: %w", path, line_no, err);
+    }
+
+    if (filter.Match(r)) { records = append(records, r); }
+  }
+  if err := scanner.Err(); err != nil { return nil, err; }
+
+  return records, nil;
+}
+
+/*
+#Validate
+検索条件を検証する
+・PlayerResultはPlayerと共に指定し、win, lose, drawのいずれかとする
+・DateFrom、DateToは2006.01.02形式の日付とし、DateFromはDateTo以前とする
+
+*返り値
+error: 不正な検索条件のエラー
+*/
+func (f Filter) Validate() error {
+  if (f.PlayerResult != "") {
+    if (f.Player == "") { return fmt.Errorf("player result `%s` requires a player", f.PlayerResult); }
+    switch f.PlayerResult {
+    case "win", "lose", "draw":
+    default:
+      return fmt.Errorf("invalid player result `%s` (win, lose, draw)", f.PlayerResult);
+    }
+  }
+
+  for _, date := range []string{ f.DateFrom, f.DateTo } {
+    if (date == "") { continue; }
+    if _, err := time.Parse(record.DATE_FORMAT, date); err != nil {
+      return fmt.Errorf("invalid date `%s` (%s)", date, record.DATE_FORMAT);
+    }
+  }
+  if (f.DateFrom != "" && f.DateTo != "" && f.DateFrom > f.DateTo) {
+    return fmt.Errorf("date from `%s` is after date to `%s`", f.DateFrom, f.DateTo);
+  }
+
+  return nil;
+}
+
+/*
+#Match
+棋譜が条件に合うか判定する
+
+*引数
+r record.Record: 判定する棋譜
+
+*返り値
+bool: 条件に合うか
+*/
+func (f Filter) Match(r record.Record) bool {
+  if (f.Player != "" && r.Black != f.Player && r.White != f.Player) { return false; }
+  if (f.Black != "" && r.Black != f.Black) { return false; }
+  if (f.White != "" && r.White != f.White) { return false; }
+
+  if (f.Result != nil && r.Result != *f.Result) { return false; }
+  if (f.PlayerResult != "" && !f.matchPlayerResult(r)) { return false; }
+
+  if (f.DateFrom != "" && r.Date < f.DateFrom) { return false; }
+  if (f.DateTo != "" && r.Date > f.DateTo) { return false; }
+  if (f.Termination != "" && r.Termination != f.Termination) { return false; }
+  if (f.Assisted != nil && r.Assisted != *f.Assisted) { return false; }
+
+  // 手順の接頭辞
+  if (len(f.Opening) > len(r.Moves)) { return false; }
+  for i, col := range f.Opening {
+    if (r.Moves[i].Col != col) { return false; }
+  }
+
+  return true;
+}
+
+/*
+#matchPlayerResult
+Playerから見た結果が条件に合うか判定する
+・Playerが指定されていない場合は合わないものとする
+
+*引数
+r record.Record: 判定する棋譜
+
+*返り値
+bool: 条件に合うか
+*/
+func (f Filter) matchPlayerResult(r record.Record) bool {
+  if (f.Player == "") { return false; }
+
+  var results []string;
+  if (r.Black == f.Player) { results = append(results, sideResult(r.Result, record.RESULT_BLACK)); }
+  if (r.White == f.Player) { results = append(results, sideResult(r.Result, record.RESULT_WHITE)); }
+
+  for _, result := range results {
+    if (result == f.PlayerResult) { return true; }
+  }
+  return false;
+}
+
+/*
+#sideResult
+対局の結果を一方の手番から見た結果に変換する
+
+*引数
+result uint8: 対局の結果
+side uint8  : 手番(record.RESULT_BLACK, record.RESULT_WHITE)
+
+*返り値
+string: 結果(win, lose, draw、中断は空文字列)
+*/
+func sideResult(result uint8, side uint8) string {
+  switch result {
+  case record.RESULT_DRAW: return "draw";
+  case side: return "win";
+  case record.RESULT_BLACK, record.RESULT_WHITE: return "lose";
+  default: return "";
+  }
+}
+
+/*
+#ParseResult
+結果の表記(1-0, 0-1, 1/2-1/2, *)を検索条件の結果に変換する
+
+*引数
+str string: 結果の表記
+
+*返り値
+*uint8: 結果
+error : 不明な表記の場合のエラー
+*/
+func ParseResult(str string) (*uint8, error) {
+  for _, result := range []uint8{ record.RESULT_BLACK, record.RESULT_WHITE, record.RESULT_DRAW, record.RESULT_UNKNOWN } {
+    if (record.ResultStr(result) == str) {
+      return &result, nil;
+    }
+  }
+  return nil, fmt.Errorf("invalid result `%s`", str);
+}
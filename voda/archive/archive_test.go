@@ -0,0 +1,167 @@
+package archive
+
+import "time"
+import "reflect"
+import "testing"
+import "path/filepath"
+
+import "voda/record"
+
+/*
+#archive_test
+保管庫の検索の検証
+・検索条件の各項目が対局を絞り込むこと
+・不正な検索条件がエラーとなること
+・追記した対局を検索できること
+*/
+
+/*
+#testRecord
+検証用の棋譜を生成する
+
+*引数
+black string      : 先手プレイヤー名
+white string      : 後手プレイヤー名
+day int           : 対局日(2026年10月の日)
+result uint8      : 結果
+termination string: 終局理由
+assisted bool     : 補助を有効にしたか
+moves []uint8     : 指し手の列
+
+*返り値
+record.Record: 棋譜
+*/
+func testRecord(black string, white string, day int, result uint8, termination string, assisted bool, moves []uint8) record.Record {
+  var r record.Record = record.New(black, white, time.Date(2026, 10, day, 0, 0, 0, 0, time.UTC));
+  r.Result = result;
+  r.Termination = termination;
+  r.Assisted = assisted;
+  for _, col := range moves {
+    r.Moves = append(r.Moves, record.Move{ Col: col, Elapsed: time.Second });
+  }
+  return r;
+}
+
+// 検証用の対局(添字で期待する結果を表す)
+var test_records []record.Record = []record.Record{
+  testRecord("g0F", "Random", 1, record.RESULT_BLACK, record.TERMINATION_ALIGNMENT, false, []uint8{ 3, 3, 4 }),
+  testRecord("Random", "g0F", 2, record.RESULT_BLACK, record.TERMINATION_ALIGNMENT, true, []uint8{ 3, 2 }),
+  testRecord("human", "g0F", 3, record.RESULT_DRAW, record.TERMINATION_FULL, false, []uint8{ 2, 3 }),
+  testRecord("human", "Random", 4, record.RESULT_UNKNOWN, record.TERMINATION_ABORT, true, []uint8{}),
+};
+
+/*
+#matching
+条件に合う対局の添字を求める
+
+*引数
+filter Filter: 検索条件
+
+*返り値
+[]int: 条件に合う対局の添字(test_recordsの順)
+*/
+func matching(filter Filter) []int {
+  var indices []int = []int{};
+  for i, r := range test_records {
+    if (filter.Match(r)) { indices = append(indices, i); }
+  }
+  return indices;
+}
+
+/*
+#TestFilter
+検索条件の各項目が対局を絞り込むこと
+*/
+func TestFilter(t *testing.T) {
+  var black uint8 = record.RESULT_BLACK;
+  var draw uint8 = record.RESULT_DRAW;
+  var assisted bool = true;
+  var unassisted bool = false;
+
+  var cases []struct { name string; filter Filter; want []int } = []struct { name string; filter Filter; want []int }{
+    { "none", Filter{}, []int{ 0, 1, 2, 3 } },
+    { "player", Filter{ Player: "g0F" }, []int{ 0, 1, 2 } },
+    { "black", Filter{ Black: "human" }, []int{ 2, 3 } },
+    { "white", Filter{ White: "Random" }, []int{ 0, 3 } },
+    { "black and white", Filter{ Black: "Random", White: "g0F" }, []int{ 1 } },
+    { "result", Filter{ Result: &black }, []int{ 0, 1 } },
+    { "result draw", Filter{ Result: &draw }, []int{ 2 } },
+    { "player win", Filter{ Player: "g0F", PlayerResult: "win" }, []int{ 0 } },
+    { "player lose", Filter{ Player: "g0F", PlayerResult: "lose" }, []int{ 1 } },
+    { "player draw", Filter{ Player: "g0F", PlayerResult: "draw" }, []int{ 2 } },
+    { "aborted has no player result", Filter{ Player: "human", PlayerResult: "lose" }, []int{} },
+    { "player result without player", Filter{ PlayerResult: "win" }, []int{} },
+    { "opening", Filter{ Opening: []uint8{ 3 } }, []int{ 0, 1 } },
+    { "opening prefix", Filter{ Opening: []uint8{ 3, 3 } }, []int{ 0 } },
+    { "opening longer than game", Filter{ Opening: []uint8{ 3, 3, 4, 4 } }, []int{} },
+    { "date from", Filter{ DateFrom: "2026.10.02" }, []int{ 1, 2, 3 } },
+    { "date to", Filter{ DateTo: "2026.10.02" }, []int{ 0, 1 } },
+    { "date range", Filter{ DateFrom: "2026.10.02", DateTo: "2026.10.03" }, []int{ 1, 2 } },
+    { "termination", Filter{ Termination: record.TERMINATION_ALIGNMENT }, []int{ 0, 1 } },
+    { "assisted", Filter{ Assisted: &assisted }, []int{ 1, 3 } },
+    { "unassisted", Filter{ Assisted: &unassisted }, []int{ 0, 2 } },
+  };
+  for _, tc := range cases {
+    if got := matching(tc.filter); !reflect.DeepEqual(got, tc.want) {
+      t.Errorf("%s: got %v, want %v", tc.name, got, tc.want);
+    }
+  }
+}
+
+/*
+#TestValidate
+不正な検索条件がエラーとなること
+*/
+func TestValidate(t *testing.T) {
+  var valid []Filter = []Filter{
+    {},
+    { Player: "g0F", PlayerResult: "draw" },
+    { DateFrom: "2026.10.01", DateTo: "2026.10.01" },
+  };
+  for _, filter := range valid {
+    if err := filter.Validate(); err != nil { t.Errorf("Validate(%+v): %v", filter, err); }
+  }
+
+  var invalid []Filter = []Filter{
+    { PlayerResult: "win" },
+    { Player: "g0F", PlayerResult: "won" },
+    { DateFrom: "2026-10-01" },
+    { DateTo: "2026.13.01" },
+    { DateFrom: "2026.10.03", DateTo: "2026.10.02" },
+  };
+  for _, filter := range invalid {
+    if err := filter.Validate(); err == nil { t.Errorf("Validate(%+v): got nil, want error", filter); }
+  }
+}
+
+/*
+#TestQuery
+保管庫に追記した対局を条件で検索でき、不正な検索条件はエラーとなること
+*/
+func TestQuery(t *testing.T) {
+  var path string = filepath.Join(t.TempDir(), "archive.jsonl");
+  for _, r := range test_records {
+    if err := Append(path, r); err != nil { t.Fatalf("Append: %v", err); }
+  }
+
+  records, err := Query(path, Filter{});
+  if (err != nil) { t.Fatalf("Query: %v", err); }
+  if (len(records) != len(test_records)) { t.Fatalf("Query: got %d records, want %d", len(records), len(test_records)); }
+  for i, got := range records {
+    var want record.Record = test_records[i];
+    if (got.Black != want.Black || got.White != want.White || got.Date != want.Date || got.Result != want.Result ||
+        got.Termination != want.Termination || got.Assisted != want.Assisted || len(got.Moves) != len(want.Moves)) {
+      t.Errorf("record %d: got %+v, want %+v", i, got, want);
+    }
+  }
+
+  records, err = Query(path, Filter{ Player: "g0F", PlayerResult: "win", Opening: []uint8{ 3 } });
+  if (err != nil) { t.Fatalf("Query: %v", err); }
+  if (len(records) != 1 || records[0].Black != "g0F" || records[0].Date != "2026.10.01") {
+    t.Errorf("Query g0F wins: got %+v", records);
+  }
+
+  if _, err := Query(path, Filter{ PlayerResult: "win" }); err == nil {
+    t.Errorf("Query with player result and no player: got nil, want error");
+  }
+}
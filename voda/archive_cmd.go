@@ -0,0 +1,134 @@
+package main
+
+import "os"
+import "fmt"
+import "flag"
+import "strconv"
+import "encoding/json"
+
+import "voda/record"
+import "voda/archive"
+
+/*
+#archiveCommand
+保管庫の検索(voda archive ...)
+・条件に合う対局を一覧表示、又は棋譜/JSON Linesとして書き出す
+
+*引数
+args []string: サブコマンド以降の実行時引数
+*/
+func archiveCommand(args []string) {
+  var fs *flag.FlagSet = flag.NewFlagSet("archive", flag.ExitOnError);
+
+  var path *string = fs.String("archive", "games.jsonl", "archive file");
+
+  // 検索条件
+  var player *string = fs.String("player", "", "player name (either side)");
+  var black *string = fs.String("black", "", "black player name");
+  var white *string = fs.String("white", "", "white player name");
+  var result *string = fs.String("result", "", "result (1-0, 0-1, 1/2-1/2, *)");
+  var player_result *string = fs.String("player-result", "", "result for --player (win, lose, draw)");
+  var opening *string = fs.String("opening", "", "opening prefix (ex. 3324)");
+  var from *string = fs.String("from", "", "date from (2006.01.02)");
+  var to *string = fs.String("to", "", "date to (2006.01.02)");
+  var termination *string = fs.String("termination", "", "termination reason");
+  var assisted *string = fs.String("assisted", "", "whether hints were enabled (true, false)");
+
+  // 出力
+  var export *string = fs.String("export", "", "write matching games to the file");
+  var as_json *bool = fs.Bool("json", false, "export as JSON lines instead of game records");
+  fs.Parse(args);
+
+  var filter archive.Filter = archive.Filter {
+    Player: *player,
+    Black: *black,
+    White: *white,
+    PlayerResult: *player_result,
+    DateFrom: *from,
+    DateTo: *to,
+    Termination: *termination,
+  };
+
+  if (*result != "") {
+    r, err := archive.ParseResult(*result);
+    if (err != nil) {
+      fmt.Println(err);
+      return;
+    }
+    filter.Result = r;
+  }
+
+  if (*assisted != "") {
+    a, err := strconv.ParseBool(*assisted);
+    if (err != nil) {
+      fmt.Println(fmt.Sprintf("invalid assisted `%s`", *assisted));
+      return;
+    }
+    filter.Assisted = &a;
+  }
+
+  for _, c := range *opening {
+    if (c < '0' || c > '6') {
+      fmt.Println(fmt.Sprintf("invalid opening `%s`", *opening));
+      return;
+    }
+    filter.Opening = append(filter.Opening, uint8(c - '0'));
+  }
+
+  records, err := archive.Query(*path, filter);
+  if (err != nil) {
+    fmt.Println(err);
+    return;
+  }
+
+  if (*export == "") {
+    // 一覧表示
+    for _, r := range records {
+      var line string = fmt.Sprintf(
+        "%s %s - %s %s %s %d moves",
+        r.Date, r.Black, r.White, record.ResultStr(r.Result), r.Termination, len(r.Moves),
+      );
+      // 補助を有効にした対局は印を付ける
+      if (r.Assisted) { line += " (assisted)"; }
+      fmt.Println(line);
+    }
+    fmt.Println(fmt.Sprintf("%d games", len(records)));
+    return;
+  }
+
+  // 書き出し
+  if err := exportRecords(*export, records, *as_json); err != nil {
+    fmt.Println(err);
+    return;
+  }
+  fmt.Println(fmt.Sprintf("%d games exported to %s", len(records), *export));
+}
+
+/*
+#exportRecords
+棋譜をファイルに書き出す
+
+*引数
+path string            : 書き出し先
+records []record.Record: 棋譜のリスト
+as_json bool           : JSON Linesとして書き出すか(falseなら棋譜の書式)
+
+*返り値
+error: 書き込みのエラー
+*/
+func exportRecords(path string, records []record.Record, as_json bool) error {
+  file, err := os.Create(path);
+  if (err != nil) { return err; }
+  defer file.Close();
+
+  for _, r := range records {
+    if (as_json) {
+      err = json.NewEncoder(file).Encode(r);
+    } else {
+      _, err = file.WriteString(r.String() + "\n");
+    }
+    if (err != nil) { return err; }
+  }
+
+  return nil;
+}
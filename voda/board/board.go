@@ -110,11 +110,12 @@ func MakeMove(stones uint64 , opp_stones uint64, col uint8) uint64 {
 指定列最上段の石を除く
 
 *引数
-stones uint64: 変更を加える盤面
-col uint8    : 列 0~6
+stones uint64    : 変更を加える盤面(最上段の石を持つ側)
+opp_stones uint64: 相手の石
+col uint8        : 列 0~6
 
 *返り値
-uint64: 石を置いた後の盤面
+uint64: 石を除いた後の盤面(列が空の場合はそのまま)
 
 board uint64   : 盤面
 col_mask uint64: 列を抜き出すマスク
@@ -126,9 +127,9 @@ func RemoveStone(stones uint64 , opp_stones uint64, col uint8) uint64 {
   // 列を抜き出すマスク
   var col_mask uint64 = 63 << (col*7);
 
-  // MakeMoveと同じ手順で次に石を置くためのマスクを生成
-  // そのマスクを1ビット右シフトすることで、除く石の位置を取得
-  return stones^((((board&col_mask)+1)<<(col*7))>>1);
+  // MakeMoveと同じ手順で次に石を置くためのマスク(列の最下位ビットに揃えたもの)を生成
+  // そのマスクを1ビット右シフトすることで、除く石の位置を取得し、列の位置に戻す
+  return stones^(((((board&col_mask)>>(col*7))+1)>>1)<<(col*7));
 }
 
 /*
@@ -201,6 +202,34 @@ func CheckAlignment(stones uint64) bool {
   return false;
 }
 
+/*
+#Threats
+石を置けば4つ揃う空きマス(脅威)を求める
+・そのマスに今置けるか(下のマスが埋まっているか)は問わない
+
+*引数
+stones uint64    : 脅威を求める側の盤面
+opp_stones uint64: 相手の盤面
+
+*返り値
+uint64: 脅威となるマス
+
+board uint64: 盤面
+*/
+func Threats(stones uint64, opp_stones uint64) uint64 {
+  var board uint64 = stones | opp_stones;
+  var threats uint64 = 0;
+
+  for col := 0; col < 7; col++ {
+    for row := 0; row < 6; row++ {
+      var cell uint64 = 1 << (col*7 + row);
+      if (board&cell == 0 && CheckAlignment(stones|cell)) { threats |= cell; }
+    }
+  }
+
+  return threats;
+}
+
 /*
 #PrintBoard
 盤面を標準出力に出力
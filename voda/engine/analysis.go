@@ -0,0 +1,219 @@
+package engine
+
+import "fmt"
+import "math/bits"
+
+import "voda/board"
+
+/*
+#analysis
+局面の解析(ブラウザの解析モード)
+・列ごとに、そこへ置いた場合の評価値、読み筋、決着までの手数を求める
+・評価値はsolver.goと同じく手番の側から見た値とし、探索の深さ以内に決着しない場合は0
+・読み筋は、評価値を求める探索の中で、各局面の最善手を記録してたどる(探索し直さない)
+*/
+
+// 解析の既定の深さ(手数)
+const ANALYSIS_DEPTH int = 12;
+
+// 解析の深さの上限(ブラウザから指定できる最大)
+const ANALYSIS_MAX_DEPTH int = 16;
+
+// 決着の見込み
+const (
+  OUTCOME_WIN string = "win"         // 手番の側の勝ち
+  OUTCOME_LOSS string = "loss"       // 手番の側の負け
+  OUTCOME_UNKNOWN string = "unknown" // 探索の深さ以内に決着しない(引き分けを含む)
+)
+
+// 探索中に記録する読み筋
+type pvLine struct {
+  moves [42]uint8 // 読み筋の手
+  n int           // 手数
+}
+
+// 列ごとの解析の結果
+type ColumnAnalysis struct {
+  Col int         // 列
+  Legal bool      // 置けるか(以下は置ける場合のみ)
+  Score int       // 評価値
+  Outcome string  // 決着の見込み(win, loss, unknown)
+  Distance int    // 決着までの手数(この手を含む、unknownは0)
+  PV []int        // 読み筋(この列から)
+}
+
+/*
+#Analyze
+局面を解析し、列ごとの評価値、読み筋、決着までの手数を求める
+
+*引数
+stones uint64    : 手番の側の石
+opp_stones uint64: 相手の石
+depth int        : 探索の深さ(手数)
+
+*返り値
+[]ColumnAnalysis: 列ごとの解析の結果(列の順)
+*/
+func Analyze(stones uint64, opp_stones uint64, depth int) []ColumnAnalysis {
+  var count int = bits.OnesCount64(stones | opp_stones);
+
+  var columns []ColumnAnalysis = []ColumnAnalysis{};
+  for col := uint8(0); col < 7; col++ {
+    var column ColumnAnalysis = ColumnAnalysis{ Col: int(col), Outcome: OUTCOME_UNKNOWN, PV: []int{} };
+    if (board.CanMove(stones, opp_stones, col)) {
+      var line pvLine;
+      column.Legal = true;
+      column.Score = scoreColumn(stones, opp_stones, count, col, depth, &line);
+      column.Outcome, column.Distance = outcomeOf(column.Score, count);
+      column.PV = append(column.PV, int(col));
+      for _, move := range line.moves[:line.n] { column.PV = append(column.PV, int(move)); }
+    }
+    columns = append(columns, column);
+  }
+  return columns;
+}
+
+/*
+#scoreColumn
+列に置いた場合の評価値(ScoreMovesと同じ値)と、その後の読み筋を求める
+
+*引数
+stones uint64    : 手番の側の石
+opp_stones uint64: 相手の石
+count int        : 置かれた石の数
+col uint8        : 置く列(置けること)
+depth int        : 探索の深さ(手数)
+line *pvLine     : 置いた後の読み筋の記録先
+
+*返り値
+int: 評価値
+*/
+func scoreColumn(stones uint64, opp_stones uint64, count int, col uint8, depth int, line *pvLine) int {
+  var next uint64 = board.MakeMove(stones, opp_stones, col);
+  if (board.CheckAlignment(next)) { return (43 - (count+1)) / 2; }
+  if (depth <= 1) { return 0; }
+  return -negamaxPV(opp_stones, next, count+1, depth-1, -43, 43, line);
+}
+
+/*
+#outcomeOf
+評価値から決着の見込みと、決着までの手数を求める
+・勝ちとなる石を置いた時点の石の数nは、評価値 = (43 - n) / 2 (切り捨て)から43-2*評価値か、その1つ前に絞られ、
+  手番の側の勝ちならcount+1と、負けならcountと偶奇が一致するものとなる
+
+*引数
+score int: 評価値
+count int: 解析する局面の石の数
+
+*返り値
+string: 決着の見込み
+int   : 決着までの手数(この手を含む)
+*/
+func outcomeOf(score int, count int) (string, int) {
+  var outcome string = OUTCOME_WIN;
+  var parity int = (count+1)%2;
+  if (score == 0) { return OUTCOME_UNKNOWN, 0; }
+  if (score < 0) {
+    outcome = OUTCOME_LOSS;
+    parity = count%2;
+    score = -score;
+  }
+
+  var n int = 43 - 2*score;
+  if (n%2 != parity) { n--; }
+  return outcome, n - count;
+}
+
+/*
+#negamaxPV
+negamax(solver.go)と同じ評価値を求め、評価値を与える手をたどった読み筋を記録する
+・alphaを超えた手のみ記録するため、読み筋はalphaとbetaの間の値を返した局面でのみ意味を持つ
+  (解析では根から全幅で呼ぶため、評価値を与える手の列となる)
+
+*引数
+stones uint64    : 手番の側の石
+opp_stones uint64: 相手の石
+count int        : 置かれた石の数
+depth int        : 残りの探索の深さ
+alpha int        : 下限
+beta int         : 上限
+line *pvLine     : 読み筋の記録先
+
+*返り値
+int: 評価値
+*/
+func negamaxPV(stones uint64, opp_stones uint64, count int, depth int, alpha int, beta int, line *pvLine) int {
+  line.n = 0;
+  // すべて埋まった場合は引き分け
+  if (count >= 42) { return 0; }
+
+  // 次で勝てる場合
+  for col := uint8(0); col < 7; col++ {
+    if (board.CanMove(stones, opp_stones, col) && board.CheckAlignment(board.MakeMove(stones, opp_stones, col))) {
+      line.moves[0], line.n = col, 1;
+      return (43 - (count+1)) / 2;
+    }
+  }
+  if (depth <= 1) { return 0; }
+
+  // 次で勝てない以上、これより良い評価値はない
+  var max int = (40 - count) / 2;
+  if (beta > max) {
+    beta = max;
+    if (alpha >= beta) { return beta; }
+  }
+
+  var child pvLine;
+  for _, col := range SEARCH_ORDER {
+    if (!board.CanMove(stones, opp_stones, col)) { continue; }
+    var score int = -negamaxPV(opp_stones, board.MakeMove(stones, opp_stones, col), count+1, depth-1, -beta, -alpha, &child);
+    // 上限に達した手も、上限が最善の評価値であれば読み筋となるため記録してから打ち切る
+    if (score > alpha) {
+      line.moves[0] = col;
+      copy(line.moves[1:], child.moves[:child.n]);
+      line.n = child.n + 1;
+    }
+    if (score >= beta) { return score; }
+    if (score > alpha) { alpha = score; }
+  }
+  return alpha;
+}
+
+/*
+#ValidatePosition
+解析できる局面か検証し、手番を求める
+・各列の石は下から詰まっていること、先手の石の数は後手と同じか1つ多いこと
+・既に4つ揃っている局面、すべて埋まった局面は解析しない
+
+*引数
+black_stones uint64: 先手の石
+white_stones uint64: 後手の石
+
+*返り値
+bool : 先手の手番か
+error: 解析できない局面の場合のエラー
+*/
+func ValidatePosition(black_stones uint64, white_stones uint64) (bool, error) {
+  if (black_stones & white_stones != 0) { return false, fmt.Errorf("stones overlap"); }
+
+  var stones uint64 = black_stones | white_stones;
+  for col := 0; col < 7; col++ {
+    // 列の石は最下段から連続し(2^h - 1)、6段まで
+    var column uint64 = (stones >> (col*7)) & 127;
+    if (column & (column+1) != 0 || column > 63) {
+      return false, fmt.Errorf("stones in column %d are not stacked from the bottom", col);
+    }
+  }
+  if (stones >> 49 != 0) { return false, fmt.Errorf("stones out of the board"); }
+
+  var black int = bits.OnesCount64(black_stones);
+  var white int = bits.OnesCount64(white_stones);
+  if (black != white && black != white+1) {
+    return false, fmt.Errorf("black must have as many stones as white or one more (black %d, white %d)", black, white);
+  }
+  if (board.CheckAlignment(black_stones) || board.CheckAlignment(white_stones)) {
+    return false, fmt.Errorf("four are already aligned");
+  }
+  if (black + white >= 42) { return false, fmt.Errorf("board is full"); }
+  return black == white, nil;
+}
@@ -0,0 +1,81 @@
+package engine
+
+import "testing"
+
+import "voda/board"
+
+/*
+#analysis_test
+局面の解析の検証
+・探索中に記録した読み筋が評価値と整合すること
+*/
+
+/*
+#playMoves
+初期局面から列の順に石を置く
+
+*引数
+moves []uint8: 列
+
+*返り値
+uint64: 手番の側の石
+uint64: 相手の石
+*/
+func playMoves(moves []uint8) (uint64, uint64) {
+  var stones, opp_stones uint64;
+  for _, col := range moves {
+    stones, opp_stones = opp_stones, board.MakeMove(stones, opp_stones, col);
+  }
+  return stones, opp_stones;
+}
+
+/*
+#TestAnalyzePV
+評価値はScoreMovesと一致し、読み筋は合法な手の列で、勝敗が決まる列では決着までの手数で4つ揃うこと
+*/
+func TestAnalyzePV(t *testing.T) {
+  var positions [][]uint8 = [][]uint8{
+    {},
+    { 3, 3, 2, 4 },
+    { 3, 2, 3, 2, 4 },
+    { 3, 3, 3, 3, 2, 4, 4, 2, 1 },
+    { 0, 6, 1, 5, 3, 3, 2 },
+  };
+  for _, moves := range positions {
+    stones, opp_stones := playMoves(moves);
+    var scores [7]int = ScoreMoves(stones, opp_stones, 10);
+
+    for _, column := range Analyze(stones, opp_stones, 10) {
+      if (!column.Legal) {
+        if (scores[column.Col] != NO_SCORE) { t.Errorf("%v col %d: not legal", moves, column.Col); }
+        continue;
+      }
+      if (column.Score != scores[column.Col]) {
+        t.Errorf("%v col %d: score %d, ScoreMoves %d", moves, column.Col, column.Score, scores[column.Col]);
+      }
+      if (len(column.PV) == 0 || column.PV[0] != column.Col || len(column.PV) > 10) {
+        t.Errorf("%v col %d: pv %v", moves, column.Col, column.PV);
+        continue;
+      }
+
+      // 読み筋をたどり、4つ揃った時点の手数を求める
+      var s, o uint64 = stones, opp_stones;
+      var aligned int = 0;
+      for i, col := range column.PV {
+        if (!board.CanMove(s, o, uint8(col))) {
+          t.Errorf("%v col %d: illegal move %d in pv %v", moves, column.Col, col, column.PV);
+          break;
+        }
+        var next uint64 = board.MakeMove(s, o, uint8(col));
+        if (board.CheckAlignment(next)) {
+          aligned = i+1;
+          break;
+        }
+        s, o = o, next;
+      }
+      if (column.Outcome != OUTCOME_UNKNOWN && (aligned != column.Distance || aligned != len(column.PV))) {
+        t.Errorf("%v col %d: %s in %d, pv %v", moves, column.Col, column.Outcome, column.Distance, column.PV);
+      }
+    }
+  }
+}
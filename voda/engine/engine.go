@@ -0,0 +1,129 @@
+package engine
+
+import "fmt"
+import "math/rand"
+
+import "voda/protocol"
+
+/*
+#engine
+vodaのプロセス内で動くエンジン
+・プレイヤーモジュールと同じ func(Param) Ret の形の関数とする(game.FuncPlayerで用いる)
+・状態を持たないため、複数の対局で同時に用いてよい
+*/
+
+// プロセス内のエンジン
+type Builtin struct {
+  ID string   // 指定名
+  Name string // 表示名
+  Play func(protocol.Param) protocol.Ret // プレイヤー関数
+}
+
+// 強さごとの探索の深さ(手数)
+var SOLVER_DEPTHS []int = []int{ 2, 6, 12, 16 };
+
+/*
+#Builtins
+プロセス内のエンジンの一覧を返す
+
+*返り値
+[]Builtin: エンジン(弱い順)
+*/
+func Builtins() []Builtin {
+  var builtins []Builtin = []Builtin{
+    { ID: "random", Name: "Random", Play: Random },
+    { ID: "g0F", Name: "g0F", Play: G0F },
+  };
+  for _, depth := range SOLVER_DEPTHS {
+    builtins = append(builtins, Builtin{
+      ID: fmt.Sprintf("solver-%d", depth),
+      Name: fmt.Sprintf("Solver (depth %d)", depth),
+      Play: Solver(depth),
+    });
+  }
+  return builtins;
+}
+
+/*
+#Find
+指定名からエンジンを探す
+
+*引数
+id string: 指定名
+
+*返り値
+Builtin: エンジン
+bool   : 見つかったか
+*/
+func Find(id string) (Builtin, bool) {
+  for _, b := range Builtins() {
+    if (b.ID == id) { return b, true; }
+  }
+  return Builtin{}, false;
+}
+
+/*
+#respond
+go以外のコマンドへの共通の応答を生成する
+
+*引数
+param protocol.Param: ゲームからのパラメータ
+name string         : プレイヤー名
+choose func(protocol.Param) protocol.Ret: goへの応答
+
+*返り値
+protocol.Ret: ゲームへの応答
+*/
+func respond(param protocol.Param, name string, choose func(protocol.Param) protocol.Ret) protocol.Ret {
+  switch param.Command {
+  case protocol.CMD_NAME:
+    return protocol.Ret{
+      Command: protocol.CMD_SETNAME,
+      Name: name,
+      Version: protocol.VERSION,
+      Capabilities: []string{ protocol.CAP_REASON, protocol.CAP_UNDO, protocol.CAP_INFO },
+    };
+  case protocol.CMD_START, protocol.CMD_UNDO:
+    // 局面はgoで受け取るため、取り消しは確認のみ行う
+    return protocol.Ret{ Command: protocol.CMD_READY, Ready: true };
+  case protocol.CMD_GO:
+    return choose(param);
+  case protocol.CMD_END:
+    return protocol.Ret{ Command: protocol.CMD_BYE };
+  }
+  return protocol.Ret{};
+}
+
+/*
+#sendInfo
+思考の状況をゲームへ渡す(info)
+・ゲームが受け渡し先を設定していない場合は何もしない
+
+*引数
+param protocol.Param: ゲームからのパラメータ(go)
+info protocol.Ret   : 思考の状況
+*/
+func sendInfo(param protocol.Param, info protocol.Ret) {
+  if (param.Info == nil) { return; }
+  info.Command = protocol.CMD_INFO;
+  param.Info(info);
+}
+
+/*
+#Random
+完全にランダムに手を選択するエンジン
+
+*引数
+param protocol.Param: ゲームからのパラメータ
+
+*返り値
+protocol.Ret: ゲームへの応答
+*/
+func Random(param protocol.Param) protocol.Ret {
+  return respond(param, "Random", func(param protocol.Param) protocol.Ret {
+    return protocol.Ret{
+      Command: protocol.CMD_MOVE,
+      Move: param.ValidMoves[rand.Intn(len(param.ValidMoves))],
+    };
+  });
+}
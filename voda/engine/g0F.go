@@ -0,0 +1,107 @@
+package engine
+
+import "fmt"
+import "sort"
+import "math/rand"
+
+import "voda/board"
+import "voda/protocol"
+
+// 各手に対するプレイアウトの回数
+const G0F_PLAYOUTS int = 500;
+
+/*
+#G0F
+乱択アルゴリズムのエンジン(player_goのg0Fと同じ)
+・各手からランダムに最後までプレイし、勝数の最も多い手を選ぶ
+・次で勝つ、次で負ける、打ってはならないなどの手を検出しない
+
+*引数
+param protocol.Param: ゲームからのパラメータ
+
+*返り値
+protocol.Ret: ゲームへの応答
+*/
+func G0F(param protocol.Param) protocol.Ret {
+  return respond(param, "g0F", g0FChoiceMove);
+}
+
+/*
+#g0FChoiceMove
+乱択アルゴリズムにより手を選択
+・1列のプレイアウトを終えるごとに、それまでの列ごとの勝率をinfoで送る
+
+*引数
+param protocol.Param: ゲームからのパラメータ
+
+*返り値
+protocol.Ret: ゲームへの応答
+*/
+func g0FChoiceMove(param protocol.Param) protocol.Ret {
+  // 引数の一覧は並べ替えない
+  var valid_moves []uint8 = append([]uint8{}, param.ValidMoves...);
+  var move_count int = len(param.Moves) + 1;
+  // 手番の側の勝ち(プレイアウトの結果の添字)
+  var win int = (move_count+1)%2;
+
+  var wins map[uint8]int = make(map[uint8]int, 7);
+  // 列ごとの勝率(未評価、置けない列は-1)
+  var rates []float64 = []float64{ -1, -1, -1, -1, -1, -1, -1 };
+  for n, move := range valid_moves {
+    var stones uint64 = board.MakeMove(param.Stones, param.OppStones, move);
+
+    var black_stones uint64 = param.OppStones;
+    var white_stones uint64 = stones;
+    if (move_count%2 == 1) {
+      black_stones = stones;
+      white_stones = param.OppStones;
+    }
+
+    for i:=0; i<G0F_PLAYOUTS; i++ {
+      if (int(playOut(black_stones, white_stones, move_count)) == win) { wins[move]++; }
+    }
+    rates[move] = float64(wins[move]) / float64(G0F_PLAYOUTS);
+    sendInfo(param, protocol.Ret{ Nodes: int64((n+1) * G0F_PLAYOUTS), Rates: append([]float64{}, rates...) });
+  }
+
+  sort.SliceStable(valid_moves, func(i int, j int) bool { return wins[valid_moves[i]] > wins[valid_moves[j]]; });
+  var next_move uint8 = valid_moves[0];
+
+  return protocol.Ret{
+    Command: protocol.CMD_MOVE,
+    Move: next_move,
+    Comment: fmt.Sprintf("playout %d/%d", wins[next_move], G0F_PLAYOUTS),
+  };
+}
+
+/*
+#playOut
+ランダムに最後までプレイする
+
+*返り値
+uint8: 結果(0: 先手勝ち、1: 後手勝ち、2: 引き分け)
+*/
+func playOut(black_stones uint64, white_stones uint64, move_count int) uint8 {
+  var stones *uint64;
+  var opp_stones *uint64;
+
+  for i:=move_count; i<42; i++ {
+    if (i%2 == 0) {
+      stones = &black_stones;
+      opp_stones = &white_stones;
+    } else {
+      stones = &white_stones;
+      opp_stones = &black_stones;
+    }
+
+    // 直前に打った側が揃えた
+    if (board.CheckAlignment(*opp_stones)) { return uint8((i+1)%2); }
+
+    var valid_moves []uint8 = board.GenValidMoves(black_stones, white_stones);
+    *stones = board.MakeMove(*stones, *opp_stones, valid_moves[rand.Intn(len(valid_moves))]);
+  }
+
+  // 最後の1手で揃った場合
+  if (board.CheckAlignment(white_stones)) { return 1; }
+  return 2;
+}
@@ -0,0 +1,154 @@
+package engine
+
+import "fmt"
+import "math/bits"
+
+import "voda/board"
+import "voda/protocol"
+
+/*
+#solver
+アルファベータ法による探索
+・評価値は手番の側から見た値とし、早く勝つほど大きい
+  勝ち: (43 - 勝ちとなる石を置いた時点の石の数) / 2
+  負け: その符号を反転した値
+  引き分け、探索の深さに達した局面: 0
+・探索の深さ以内に決着がつく場合、評価値は最善を尽くした場合の結果となる
+*/
+
+// 探索する列の順(中央から)
+var SEARCH_ORDER []uint8 = []uint8{ 3, 2, 4, 1, 5, 0, 6 };
+
+// 評価値を持たない列(置けない列)
+const NO_SCORE int = -1000;
+
+/*
+#Solver
+指定の深さまで探索するエンジンを生成
+
+*引数
+depth int: 探索の深さ(手数)
+
+*返り値
+func(protocol.Param) protocol.Ret: エンジン
+*/
+func Solver(depth int) func(protocol.Param) protocol.Ret {
+  var name string = fmt.Sprintf("Solver-%d", depth);
+  return func(param protocol.Param) protocol.Ret {
+    return respond(param, name, func(param protocol.Param) protocol.Ret {
+      move, score := Search(param.Stones, param.OppStones, depth);
+      sendInfo(param, protocol.Ret{ Depth: depth, Score: &score, PV: []uint8{ move } });
+      return protocol.Ret{
+        Command: protocol.CMD_MOVE,
+        Move: move,
+        Score: &score,
+        PV: []uint8{ move },
+        Comment: fmt.Sprintf("depth %d", depth),
+      };
+    });
+  };
+}
+
+/*
+#Search
+最善の手とその評価値を求める
+・評価値が等しい手は中央に近い列を選ぶ
+
+*引数
+stones uint64    : 手番の側の石
+opp_stones uint64: 相手の石
+depth int        : 探索の深さ(手数)
+
+*返り値
+uint8: 最善の手
+int  : 評価値
+*/
+func Search(stones uint64, opp_stones uint64, depth int) (uint8, int) {
+  var scores [7]int = ScoreMoves(stones, opp_stones, depth);
+  var best uint8 = 0;
+  var best_score int = NO_SCORE;
+  for _, col := range SEARCH_ORDER {
+    if (scores[col] > best_score) {
+      best = col;
+      best_score = scores[col];
+    }
+  }
+  return best, best_score;
+}
+
+/*
+#ScoreMoves
+列ごとに、そこへ置いた場合の評価値を求める
+
+*引数
+stones uint64    : 手番の側の石
+opp_stones uint64: 相手の石
+depth int        : 探索の深さ(手数)
+
+*返り値
+[7]int: 列ごとの評価値(置けない列はNO_SCORE)
+*/
+func ScoreMoves(stones uint64, opp_stones uint64, depth int) [7]int {
+  var scores [7]int;
+  var count int = bits.OnesCount64(stones | opp_stones);
+
+  for col := uint8(0); col < 7; col++ {
+    scores[col] = NO_SCORE;
+    if (!board.CanMove(stones, opp_stones, col)) { continue; }
+
+    var next uint64 = board.MakeMove(stones, opp_stones, col);
+    if (board.CheckAlignment(next)) {
+      scores[col] = (43 - (count+1)) / 2;
+      continue;
+    }
+    if (depth <= 1) {
+      scores[col] = 0;
+      continue;
+    }
+    scores[col] = -negamax(opp_stones, next, count+1, depth-1, -43, 43);
+  }
+  return scores;
+}
+
+/*
+#negamax
+手番の側から見た評価値を求める(アルファベータ法)
+
+*引数
+stones uint64    : 手番の側の石
+opp_stones uint64: 相手の石
+count int        : 置かれた石の数
+depth int        : 残りの探索の深さ
+alpha int        : 下限
+beta int         : 上限
+
+*返り値
+int: 評価値
+*/
+func negamax(stones uint64, opp_stones uint64, count int, depth int, alpha int, beta int) int {
+  // すべて埋まった場合は引き分け
+  if (count >= 42) { return 0; }
+
+  // 次で勝てる場合
+  for col := uint8(0); col < 7; col++ {
+    if (board.CanMove(stones, opp_stones, col) && board.CheckAlignment(board.MakeMove(stones, opp_stones, col))) {
+      return (43 - (count+1)) / 2;
+    }
+  }
+  if (depth <= 1) { return 0; }
+
+  // 次で勝てない以上、これより良い評価値はない
+  var max int = (40 - count) / 2;
+  if (beta > max) {
+    beta = max;
+    if (alpha >= beta) { return beta; }
+  }
+
+  for _, col := range SEARCH_ORDER {
+    if (!board.CanMove(stones, opp_stones, col)) { continue; }
+    var score int = -negamax(opp_stones, board.MakeMove(stones, opp_stones, col), count+1, depth-1, -beta, -alpha);
+    if (score >= beta) { return score; }
+    if (score > alpha) { alpha = score; }
+  }
+  return alpha;
+}
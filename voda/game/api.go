@@ -0,0 +1,305 @@
+package game
+
+import "fmt"
+import "time"
+import "errors"
+import "strings"
+import "net/http"
+import "encoding/json"
+
+import "voda/record"
+
+/*
+#api
+対局ごとの資源を扱うJSONのAPI(/api/games)
+・他のツール、別のフロントエンドから対局を操作するためのもの
+・状態の参照は対局を所有するgoroutineが公開したものを読み、エンジンの思考中も待たない
+・操作は/games/{id}/gameと同じく対局を所有するgoroutineに依頼し、権限も同じく確かめる
+・エラーは{Error}で返す(権限がない場合は403、受け付けられない操作は409)
+
+GET    /api/games              : 対局の一覧([APIGame])
+POST   /api/games              : {Black, White, Rules}で対局を生成し、{ID, Token, Code}を返す
+GET    /api/games/{id}         : 対局の状態(APIGame)
+GET    /api/games/{id}/history : 指し手の履歴([APIMove])
+POST   /api/games/{id}/start   : {Token|Seat}で対局を開始し、状態を返す
+POST   /api/games/{id}/moves   : {Col, Token|Seat}で人間の手番に着手し、状態を返す
+POST   /api/games/{id}/abort   : {Token}で対局を中断し、状態を返す
+*/
+
+// 対局の状態
+const (
+  STATUS_WAITING string = "waiting"   // 開始前
+  STATUS_PLAYING string = "playing"   // 対局中
+  STATUS_FINISHED string = "finished" // 終局
+  STATUS_ABORTED string = "aborted"   // 中断
+)
+
+// 対局の状態(GET /api/games/{id})
+type APIGame struct {
+  ID string
+  Rules string     // ルール
+  Black APIPlayer  // 先手
+  White APIPlayer  // 後手
+
+  BlackStones uint64 // 先手の石
+  WhiteStones uint64 // 後手の石
+  Moves []int        // 着手の履歴(列)
+  Ply int            // 手数
+
+  Status string      // waiting, playing, finished, aborted
+  ToMove string      // 手番(black, white、対局中のみ)
+  Clock *APIClock    // 消費時間(開始前はnil)
+  Result string      // 結果(1-0, 0-1, 1/2-1/2, *)
+  Termination string // 終局理由
+  Assist bool        // 補助(ヒント、脅威の表示)が有効か
+}
+
+// 対局のプレイヤー
+type APIPlayer struct {
+  Name string   // プレイヤー名
+  Engine string // 種類と識別(Player.Identity)
+  Human bool    // ブラウザ上の人間か
+}
+
+// 消費時間
+type APIClock struct {
+  BlackMs int64    // 先手の消費時間の合計(ミリ秒、対局中は現在の手番の経過時間を含む)
+  WhiteMs int64    // 後手の消費時間の合計(ミリ秒、対局中は現在の手番の経過時間を含む)
+  Side string      // 手番(black, white、対局中のみ)
+  ElapsedMs int64  // 現在の手番の経過時間(ミリ秒、対局中のみ)
+}
+
+// 1手分の履歴(GET /api/games/{id}/history)
+type APIMove struct {
+  Ply int          // 手数(1から)
+  Side string      // 着手した側(black, white)
+  Col int          // 列
+  ElapsedMs int64  // 消費時間(ミリ秒、記録なしは0)
+  Comment string   // 評価値、読み筋などのコメント
+}
+
+// APIへの操作のリクエスト
+type apiRequest struct {
+  Col uint8
+  Token string // 操作用のトークン
+  Seat string  // 席のトークン
+}
+
+/*
+#apiGamesHandler
+/api/gamesに対するハンドラ
+・GETで対局の一覧を返す
+・POSTで{Black, White, Rules}を指定して対局を生成する(Rulesは省略時7x6、それ以外は未対応)
+・生成はローカルホスト、またはcreate=(生成用のトークン)を示したリクエストのみ受け付ける(canCreate)
+*/
+func (s *Server) apiGamesHandler(w http.ResponseWriter, r *http.Request) {
+  switch r.Method {
+  case http.MethodGet:
+    var games []APIGame = []APIGame{};
+    for _, id := range s.gameIDs() {
+      if g, ok := s.Game(id); ok { games = append(games, g.apiGame(id)); }
+    }
+    writeJSON(w, http.StatusOK, games);
+
+  case http.MethodPost:
+    if (!s.canCreate(r)) {
+      writeError(w, http.StatusForbidden, fmt.Errorf("creating games is not allowed from this client"));
+      return;
+    }
+    var request struct {
+      Black string
+      White string
+      Rules string
+    };
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+      writeError(w, http.StatusBadRequest, err);
+      return;
+    }
+    if (request.Rules != "" && request.Rules != record.DEFAULT_RULES) {
+      writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported rules `%s`", request.Rules));
+      return;
+    }
+    created, err := s.CreateGameBySpec(request.Black, request.White);
+    if (err != nil) {
+      writeError(w, createStatus(err), err);
+      return;
+    }
+    writeJSON(w, http.StatusCreated, created);
+
+  default:
+    writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"));
+  }
+}
+
+/*
+#apiGameRouter
+/api/games/{id}以下のリクエストを対局に振り分ける
+*/
+func (s *Server) apiGameRouter(w http.ResponseWriter, r *http.Request) {
+  var parts []string = strings.Split(strings.TrimPrefix(r.URL.Path, "/api/games/"), "/");
+  var id string = parts[0];
+
+  g, ok := s.Game(id);
+  if (!ok) {
+    writeError(w, http.StatusNotFound, fmt.Errorf("game `%s` not found", id));
+    return;
+  }
+
+  if (len(parts) == 1 && r.Method == http.MethodGet) {
+    writeJSON(w, http.StatusOK, g.apiGame(id));
+    return;
+  }
+  if (len(parts) == 2 && parts[1] == "history" && r.Method == http.MethodGet) {
+    writeJSON(w, http.StatusOK, g.apiHistory());
+    return;
+  }
+
+  // 操作(対局を所有するgoroutineのコマンドに対応する)
+  var commands map[string]string = map[string]string{
+    "start": "start",
+    "moves": "drop",
+    "abort": "abort",
+  };
+  if command, ok := commands[parts[len(parts)-1]]; ok && len(parts) == 2 && r.Method == http.MethodPost {
+    var request apiRequest;
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+      writeError(w, http.StatusBadRequest, err);
+      return;
+    }
+    _, err := g.sendCommand(browserCommand{
+      Command: command,
+      Col: request.Col,
+      Token: request.Token,
+      Seat: request.Seat,
+    });
+    if (errors.Is(err, errForbidden)) {
+      writeError(w, http.StatusForbidden, err);
+      return;
+    } else if (err != nil) {
+      writeError(w, http.StatusConflict, err);
+      return;
+    }
+    // 操作の処理後に公開された状態を返す
+    writeJSON(w, http.StatusOK, g.apiGame(id));
+    return;
+  }
+
+  writeError(w, http.StatusNotFound, fmt.Errorf("not found"));
+}
+
+/*
+#writeError
+エラーをJSONのレスポンスとして書き込む
+
+*引数
+w http.ResponseWriter: レスポンス
+status int           : ステータスコード
+err error            : エラー
+*/
+func writeError(w http.ResponseWriter, status int, err error) {
+  writeJSON(w, status, struct{ Error string }{ Error: err.Error() });
+}
+
+/*
+#apiGame
+対局を所有するgoroutineが公開した局面、棋譜から対局の状態を求める
+
+*引数
+id string: 対局のID
+
+*返り値
+APIGame: 対局の状態
+*/
+func (g *Game) apiGame(id string) APIGame {
+  // 局面、棋譜、計時は同じ時点の写しを読む
+  var snapshot *gameSnapshot = g.published.Load();
+  var state *GameState = &snapshot.state;
+  var rec *record.Record = &snapshot.record;
+
+  var game APIGame = APIGame{
+    ID: id,
+    Rules: rec.Rules,
+    Black: APIPlayer{ Name: state.BlackName, Engine: state.BlackEngine, Human: state.BlackHuman },
+    White: APIPlayer{ Name: state.WhiteName, Engine: state.WhiteEngine, Human: state.WhiteHuman },
+    BlackStones: state.BlackStones,
+    WhiteStones: state.WhiteStones,
+    Moves: state.Moves,
+    Ply: int(state.Counter),
+    Status: STATUS_WAITING,
+    Result: record.ResultStr(rec.Result),
+    Termination: rec.Termination,
+    Assist: state.Assist,
+  };
+
+  switch {
+  case state.Playing:
+    game.Status = STATUS_PLAYING;
+    game.ToMove = sideName(state.Counter%2 == 0);
+  case rec.Termination == record.TERMINATION_ABORT:
+    game.Status = STATUS_ABORTED;
+  case rec.Termination != "":
+    game.Status = STATUS_FINISHED;
+  }
+  if (game.Status != STATUS_WAITING) { game.Clock = snapshot.apiClock(game.ToMove); }
+  return game;
+}
+
+/*
+#apiClock
+棋譜の消費時間と手番の開始時刻から、先後それぞれの消費時間の合計を求める
+
+*引数
+to_move string: 手番(black, white、対局中でなければ空文字列)
+
+*返り値
+*APIClock: 消費時間
+*/
+func (s *gameSnapshot) apiClock(to_move string) *APIClock {
+  var clock APIClock;
+  for i, m := range s.record.Moves {
+    if (i%2 == 0) { clock.BlackMs += m.Elapsed.Milliseconds(); } else { clock.WhiteMs += m.Elapsed.Milliseconds(); }
+  }
+  if (to_move == "" || s.turn_started.IsZero()) { return &clock; }
+
+  clock.Side = to_move;
+  clock.ElapsedMs = time.Since(s.turn_started).Milliseconds();
+  if (to_move == "black") { clock.BlackMs += clock.ElapsedMs; } else { clock.WhiteMs += clock.ElapsedMs; }
+  return &clock;
+}
+
+/*
+#apiHistory
+対局を所有するgoroutineが公開した棋譜から指し手の履歴を求める
+
+*返り値
+[]APIMove: 指し手の履歴
+*/
+func (g *Game) apiHistory() []APIMove {
+  var rec *record.Record = &g.published.Load().record;
+  var history []APIMove = []APIMove{};
+  for i, m := range rec.Moves {
+    history = append(history, APIMove{
+      Ply: i+1,
+      Side: sideName(i%2 == 0),
+      Col: int(m.Col),
+      ElapsedMs: m.Elapsed.Milliseconds(),
+      Comment: m.Comment,
+    });
+  }
+  return history;
+}
+
+/*
+#sideName
+先後の名称を返す
+
+*引数
+black bool: 先手か
+
+*返り値
+string: black, white
+*/
+func sideName(black bool) string {
+  if (black) { return "black"; }
+  return "white";
+}
@@ -1,42 +1,88 @@
 var move_count = 0; // 手数
 var board = 0; // 盤面
+var moves = []; // 着手の履歴(列)
+var view_ply = null; // 履歴を遡って表示中の手数(nullは現在の局面)
 
 var is_black_human = false; // 先手が人間か
 var is_white_human = false; // 後手が人間か
-
-var col = 0; // 石を落とす列
-
-// ゲームを進める
-async function game() {
-	/*
-	result
-		0: 先手勝ち
-		1: 後手勝ち
-		2: 引き分け
-		3: ゲーム中
-		255: 異常終了
-	*/
-	result = 3;
-	for (i=0; i<42; i++) {
-		// ゲームが終了
-		if (result != 3) {
-			break;
-		}
-		result = await getNextMove();
-	}
-
-	// i==42
-	if (result == 3) { result=2; }
-
-	showResult(result);
+var playing = false; // 対局中か
+var assist = false; // 補助(ヒント、脅威の表示)が有効か
+
+var game_id = new URLSearchParams(location.search).get("game"); // 表示中の対局のID
+var events = null; // 対局のイベント(Server-Sent Events)
+var token = null; // 対局の操作用のトークン(nullなら観戦のみ)
+var join_code = null; // 人間の席に参加するコード
+var seat = null; // このタブが確保した席({color, seat})
+var seats = {"Black": false, "White": false}; // 席が確保されているか
+
+var analysis_mode = new URLSearchParams(location.search).get("analysis") != null; // 解析モードか
+var analysis_stones = [0, 0]; // 解析モードの先手、後手の石
+var analysis_history = []; // 解析モードで置いた石({col, turn})
+var analysis_seq = 0; // 解析のリクエストの番号(古いリクエストの結果を捨てる)
+var analysis_abort = null; // 解析中のリクエストの中止(新しい局面を送る前に古いリクエストを取り消す)
+
+/*
+result
+	0: 先手勝ち
+	1: 後手勝ち
+	2: 引き分け
+	3: ゲーム中
+	255: 異常終了
+*/
+
+// 対局のイベントを購読する
+// 盤面、プレイヤー、結果の表示はすべてイベントにより更新する
+function subscribeEvents() {
+	if (game_id == null) { return; }
+	events = new EventSource(`/games/${game_id}/events`);
+
+	events.addEventListener("state", (e) => { onState(JSON.parse(e.data)); });
+	events.addEventListener("takeback", (e) => { onState(JSON.parse(e.data)); });
+	events.addEventListener("players", (e) => { showPlayers(JSON.parse(e.data)); });
+	events.addEventListener("seats", (e) => {
+		seats = JSON.parse(e.data);
+		showControls();
+	});
+	events.addEventListener("start", (e) => { onStart(JSON.parse(e.data)); });
+	events.addEventListener("move", (e) => { onMove(JSON.parse(e.data)); });
+	events.addEventListener("clock", (e) => { showClock(JSON.parse(e.data)); });
+	events.addEventListener("info", (e) => { showInfo(JSON.parse(e.data)); });
+	events.addEventListener("assist", (e) => {
+		assist = JSON.parse(e.data)["Enabled"];
+		clearHint();
+		showControls();
+	});
+	events.addEventListener("end", (e) => {
+		let res = JSON.parse(e.data);
+		playing = false;
+		showResult(res["Result"], res["Termination"]);
+		showControls();
+	});
+	events.addEventListener("quit", (e) => {
+		events.close();
+		document.querySelector("#cover").style.display = "flex";
+	});
 }
 
-// ゲームを初期化する
+// ゲームを開始する
 async function startGame() {
+	// 対局中は開始できない(サーバも受け付けない)
+	if (playing) {
+		alert("The game is in progress. Abort it before starting a new one.");
+		return;
+	}
 	// プレイヤー名を待機中にする
 	newRotatingStr("Waiting...", "black-player-lbl");
 	newRotatingStr("Waiting...", "white-player-lbl");
 
+	// 開始を通知(結果はstartイベントで受け取る)
+	await sendRequest({
+		command: "start"
+	});
+}
+
+// 対局の開始
+function onStart(res) {
 	// 盤の表示をリセット
 	clearBoard();
 	// 結果をリセット
@@ -44,43 +90,219 @@ async function startGame() {
 	// 手数、盤面をリセット
 	move_count = 1;
 	board = 0;
+	moves = [];
+	view_ply = null;
 	showTurn();
+	showMoves();
+	clearHint();
+	clearInfo();
 
-	// 開始を通知
-	res = await sendRequest({
-		command: "start"
+	playing = res["Result"] == 3;
+	showPlayers(res);
+}
+
+// 購読の開始時、手の取り消し後の局面(途中から開いた場合も着手の履歴を表示する)
+function onState(state) {
+	showStones(state["BlackStones"], state["WhiteStones"]);
+	move_count = state["Counter"] + 1;
+	moves = state["Moves"];
+	view_ply = null;
+	showTurn();
+	showMoves();
+	clearHint();
+	playing = state["Playing"];
+	assist = state["Assist"];
+	showPlayers(state);
+	showResult(state["Result"], state["Termination"]);
+}
+
+// 着手
+// 履歴を遡って表示している間は、盤面を変えずに履歴のみ伸ばす
+// 購読の開始時の局面に含まれる着手は読み捨てる
+function onMove(res) {
+	if (res["Counter"] <= moves.length) { return; }
+	moves.push(res["NextMove"]);
+	// 非合法手は石を落とさない
+	if (res["Valid"] && view_ply == null) {
+		drop(res["Pos"], (res["Counter"]+1)%2);
+	}
+	move_count = res["Counter"] + 1;
+	board = res["Board"];
+	showTurn();
+	showMoves();
+	// ヒントは着手前の局面のもの
+	clearHint();
+	showControls();
+}
+
+// 石の配置から盤面を表示する
+function showStones(black_stones, white_stones) {
+	clearBoard();
+	for (let pos=0; pos<42; pos++) {
+		// 盤面のビットは列*7+行、表示のマスは列*6+行
+		let bit = Math.floor(pos/6)*7 + pos%6;
+		if (Math.floor(black_stones / 2**bit) % 2 == 1) { placeStone(pos, 0); }
+		if (Math.floor(white_stones / 2**bit) % 2 == 1) { placeStone(pos, 1); }
+	}
+}
+
+// 着手の履歴を表示する(表示中の手を強調する)
+function showMoves() {
+	let list = document.querySelector("#move-list");
+	list.innerHTML = "";
+	let current = view_ply ?? moves.length;
+	moves.forEach((col, i) => {
+		let item = document.createElement("li");
+		item.innerText = `${i%2 == 0? "Black" : "White"} ${col}`;
+		item.classList.add("move-item");
+		if (i+1 == current) { item.classList.add("current"); }
+		item.addEventListener("click", () => { showPly(i+1); });
+		list.appendChild(item);
 	});
+	document.querySelector("#ply-lbl").innerText = view_ply == null? "Live" : `${view_ply} / ${moves.length}`;
+}
+
+// 指定の手数の局面をサーバから取得して表示する
+// 最新の手数以上を指定すると現在の局面に戻る
+async function showPly(ply) {
+	if (game_id == null) { return; }
+	ply = Math.max(0, ply);
+	view_ply = ply >= moves.length? null : ply;
+
+	let query = view_ply == null? "" : `?ply=${view_ply}`;
+	let result = await fetch(`/games/${game_id}/position${query}`);
+	if (!result.ok) { return; }
+	let position = await result.json();
+	showStones(position["BlackStones"], position["WhiteStones"]);
+	showMoves();
+}
+
+// 表示中の手数から前後に移動する
+function stepPly(delta) {
+	showPly((view_ply ?? moves.length) + delta);
+}
+
+// 人間の直前の手まで取り消す(結果はtakebackイベントで受け取る)
+async function takeBack() {
+	await sendRequest({
+		command: "takeback"
+	});
+}
 
-	// 各プレイヤーが人間か否か設定する
-	if (res["BlackPort"] == 0) {
-		is_black_human = true;
+// 補助(ヒント、脅威の表示)を切り替える(結果はassistイベントで受け取る)
+// 有効にした対局は棋譜に補助ありと記録される
+async function toggleAssist() {
+	await sendRequest({
+		command: "assist",
+		assist: !assist,
+	});
+}
+
+// 推奨する列の置く位置を強調し、双方の脅威(置けば4つ揃うマス)を網掛けする
+async function showHint() {
+	let params = new URLSearchParams({ token: token ?? "", code: join_code ?? "" });
+	let result = await fetch(`/games/${game_id}/hint?${params}`);
+	if (!result.ok) { return; }
+	let hint = await result.json();
+
+	clearHint();
+	for (let pos=0; pos<42; pos++) {
+		// 盤面のビットは列*7+行、表示のマスは列*6+行
+		let bit = Math.floor(pos/6)*7 + pos%6;
+		let cell = document.querySelector("#board-" + ("0"+String(pos)).slice(-2));
+		if (Math.floor(hint["BlackThreats"] / 2**bit) % 2 == 1) { cell.classList.add("threat-black"); }
+		if (Math.floor(hint["WhiteThreats"] / 2**bit) % 2 == 1) { cell.classList.add("threat-white"); }
 	}
-	if (res["WhitePort"] == 0) {
-		is_white_human = true;
+	// 推奨する列の最も下の空きマス
+	for (let row=0; row<6; row++) {
+		let cell = document.querySelector("#board-" + ("0"+String(hint["Col"]*6 + row)).slice(-2));
+		if (cell.childElementCount == 0) {
+			cell.classList.add("hint");
+			break;
+		}
 	}
+	document.querySelector("#hint-lbl").innerText = `Hint: ${hint["Col"]} (${hint["Score"] > 0? "+" : ""}${hint["Score"]})`;
+}
 
-	// プレイヤーの名称
-	// 人間なら"Human"
-	var black_name = res["BlackName"];
-	var white_name = res["WhiteName"];
+// ヒントの表示を消す
+function clearHint() {
+	for (let cell of document.querySelectorAll(".board-cell")) {
+		cell.classList.remove("hint", "threat-black", "threat-white");
+	}
+	document.querySelector("#hint-lbl").innerText = "";
+}
 
-	if (black_name == "") { black_name = "Human"; }
-	if (white_name == "") { white_name = "Human"; }
+// エンジンの思考の状況(info)を表示する
+// 報告された項目のみ表示し、列ごとの勝率はヒートマップにする(負の値の列は空欄)
+function showInfo(info) {
+	let color = info["Black"]? "black" : "white";
+	let items = [`${info["Black"]? "Black" : "White"} #${info["Ply"]}:`];
+	if (info["Depth"] > 0) { items.push(`depth ${info["Depth"]}`); }
+	if (info["Score"] != null) { items.push(`score ${info["Score"] > 0? "+" : ""}${info["Score"]}`); }
+	if (info["Nodes"] > 0) { items.push(`nodes ${info["Nodes"]}`); }
+	if (info["PV"].length > 0) { items.push(`pv ${info["PV"].join(" ")}`); }
+	document.querySelector(`#info-${color}-lbl`).innerText = items.join(" ");
+
+	if (info["Rates"].length == 0) { return; }
+	for (let col=0; col<7; col++) {
+		let cell = document.querySelector(`#info-${color}-${col}`);
+		let rate = col < info["Rates"].length? info["Rates"][col] : -1;
+		if (rate < 0) {
+			cell.innerText = "";
+			cell.style.backgroundColor = "";
+			continue;
+		}
+		// 勝率0で赤、1で緑
+		cell.innerText = `${Math.round(rate*100)}%`;
+		cell.style.backgroundColor = `hsl(${Math.round(rate*120)}, 70%, 75%)`;
+	}
+}
 
-	document.querySelector("#black-player-lbl").innerHTML = `<span>${black_name}</span>`;
-	document.querySelector("#white-player-lbl").innerHTML = `<span>${white_name}</span>`;
+// エンジンの思考の状況の表示を消す
+function clearInfo() {
+	for (let color of ["black", "white"]) {
+		document.querySelector(`#info-${color}-lbl`).innerText = `${color == "black"? "Black" : "White"}: -`;
+		for (let col=0; col<7; col++) {
+			let cell = document.querySelector(`#info-${color}-${col}`);
+			cell.innerText = "";
+			cell.style.backgroundColor = "";
+		}
+	}
+}
 
-	// ゲーム開始
-	game();
+// プレイヤーの名称、種類(サーバが報告するエンジンの識別)を表示
+function showPlayers(res) {
+	is_black_human = res["BlackHuman"];
+	is_white_human = res["WhiteHuman"];
+	// 人間の側には思考の状況を表示しない
+	document.querySelector("#info-black").style.display = is_black_human? "none" : "";
+	document.querySelector("#info-white").style.display = is_white_human? "none" : "";
+
+	for (let [color, id] of [["Black", "#black-player-lbl"], ["White", "#white-player-lbl"]]) {
+		let name = document.createElement("span");
+		name.innerText = res[`${color}Name`];
+		let engine = document.createElement("small");
+		engine.innerText = res[`${color}Engine`]? ` (${res[`${color}Engine`]})` : "";
+
+		let lbl = document.querySelector(id);
+		lbl.innerHTML = "";
+		lbl.appendChild(name);
+		lbl.appendChild(engine);
+	}
+	showControls();
+}
+
+// 手番の経過時間を表示
+function showClock(clock) {
+	document.querySelector("#clock-lbl").innerText = `${Math.floor(clock["Elapsed"]/1000)}s`;
 }
 
 // ゲーム終了
 async function quitGame() {
-	res = await sendRequest({
+	// 終了はquitイベントで表示する
+	await sendRequest({
 		command: "quit"
 	});
-
-	document.querySelector("#cover").style.display = "flex";
 }
 
 
@@ -94,46 +316,12 @@ function showTurn() {
 	} else {
 		turn_lbl.innerText = "White";
 	}
-}
-
-// 次の手が入力されるのを待つ
-function waitCol() {
-	return new Promise(function(resolve, reject){
-		wait_interval_id = setInterval(()=>{
-			if(col!=-1) { clearInterval(wait_interval_id); resolve(); }
-			else { return }
-		}, 500);
-	})
-}
-
-// 次の手に進める
-async function getNextMove() {
-	var pos;
-	let is_human = move_count%2==1? is_black_human : is_white_human;
-
-	if (is_human) {
-		col = -1;
-		await waitCol();
-		res = await sendRequest({
-			command: "drop",
-			col: col,
-		});
-	} else {
-		res = await sendRequest({
-			command: "move",
-		});
-	}
-
-	pos = res["Pos"];
-	drop(pos, (move_count+1)%2)
-	move_count++;
-	showTurn();
-
-	return res["Result"];
+	document.querySelector("#clock-lbl").innerText = "0s";
 }
 
 // 結果表示
-function showResult(result) {
+// 相手の失敗による勝ちは理由を付ける
+function showResult(result, termination) {
 	let lbl = document.querySelector("#result-lbl");
 
 	if (result == 0) {
@@ -142,25 +330,315 @@ function showResult(result) {
 		lbl.innerText = "White Wins";
 	} else if (result == 2) {
 		lbl.innerText = "Draw";
+	} else if (termination == "abort") {
+		lbl.innerText = "Aborted";
 	} else {
 		lbl.innerText = "---";
 	}
+
+	if (result <= 1 && (termination == "illegal" || termination == "disconnect" || termination == "protocol")) {
+		lbl.innerText += ` (${termination})`;
+	}
+}
+
+// 対局の一覧を取得し、表示中の対局を選ぶ
+// IDの指定がなければ最初の対局を表示する
+async function loadGames() {
+	let games = await fetch("/games").then((result) => result.json());
+
+	let select = document.querySelector("#game-select");
+	select.innerHTML = "";
+	for (let g of games) {
+		let option = document.createElement("option");
+		option.value = g["ID"];
+		option.innerText = `#${g["ID"]} ${g["BlackName"]} vs ${g["WhiteName"]}`;
+		select.appendChild(option);
+	}
+
+	if (game_id == null && games.length > 0) { game_id = games[0]["ID"]; }
+	select.value = game_id;
+}
+
+// 対局の生成時に選べるプレイヤー(人間、エンジン、ロビー)を表示する
+async function loadPlayerChoices() {
+	let choices = await fetch("/players").then((result) => result.json());
+	for (let id of ["#new-black-select", "#new-white-select"]) {
+		let select = document.querySelector(id);
+		select.innerHTML = "";
+		for (let c of choices) {
+			let option = document.createElement("option");
+			option.value = c["Spec"];
+			option.innerText = c["Name"];
+			select.appendChild(option);
+		}
+	}
+}
+
+// 対局を切り替える
+function selectGame(id) {
+	location.search = `?game=${id}`;
+}
+
+// 操作用のトークン、参加用のコード、席を読み込む
+// URLで渡されたトークン、コードは対局ごとに保存し、次からはURLになくても用いる
+// 席はタブごとに確保する
+function loadCredentials() {
+	if (game_id == null) { return; }
+	let params = new URLSearchParams(location.search);
+	for (let [name, key] of [["token", `voda-token-${game_id}`], ["join", `voda-join-${game_id}`]]) {
+		if (params.get(name) != null) { localStorage.setItem(key, params.get(name)); }
+	}
+	token = localStorage.getItem(`voda-token-${game_id}`);
+	join_code = localStorage.getItem(`voda-join-${game_id}`);
+	seat = JSON.parse(sessionStorage.getItem(`voda-seat-${game_id}`));
+
+	showControls();
+}
+
+// 権限に応じて操作のボタン、参加用のリンクを表示する
+// 観戦のみの場合は操作のボタンを隠す
+function showControls() {
+	let show = (id, visible) => { document.querySelector(id).style.display = visible? "" : "none"; };
+	show("#start-btn", token != null || seat != null);
+	show("#quit-btn", token != null);
+	show("#remove-btn", token != null);
+	// 待ったは人間とエンジンの対局でのみ行える
+	show("#takeback-btn", playing && is_black_human != is_white_human && (token != null || seat != null));
+	// 補助は対局者が切り替え、有効な間は自分の手番にヒントを求められる
+	show("#assist-btn", token != null || seat != null);
+	document.querySelector("#assist-btn").innerText = `Hints: ${assist? "On" : "Off"}`;
+	show("#hint-btn", assist && isMyTurn());
+
+	// 席は、参加できるタブで、人間の側が空いている場合に確保できる
+	let can_claim = (token != null || join_code != null) && seat == null;
+	show("#claim-black-btn", can_claim && is_black_human && !seats["Black"]);
+	show("#claim-white-btn", can_claim && is_white_human && !seats["White"]);
+
+	let mode = "";
+	if (seat != null) {
+		mode = `Playing ${seat["color"]}`;
+	} else if (token == null) {
+		mode = "Spectating";
+	}
+	if (token != null && join_code != null && (is_black_human || is_white_human)) {
+		mode += ` Join: ${location.origin}/?game=${game_id}&join=${join_code}`;
+	}
+	document.querySelector("#mode-lbl").innerText = mode;
+}
+
+// このタブが着手できる手番か
+// 対局中の人間の手番で、手番の席が確保されていれば、その席を確保したタブのみが着手できる
+function isMyTurn() {
+	let color = move_count%2==1? "Black" : "White";
+	let is_human = move_count%2==1? is_black_human : is_white_human;
+	let is_mine = seats[color]? (seat != null && seat["color"].toLowerCase() == color.toLowerCase()) : token != null;
+	return playing && is_human && is_mine;
+}
+
+// 人間の側の席を確保する
+async function claimSeat(color) {
+	let res = await sendRequest({
+		command: "claim",
+		color: color,
+		code: join_code,
+	});
+	if (res == undefined) { return; }
+	seat = {"color": color, "seat": res["Seat"]};
+	sessionStorage.setItem(`voda-seat-${game_id}`, JSON.stringify(seat));
+	showControls();
+}
+
+// 対局を生成して切り替える
+// 生成用のトークン(--create-token)があれば示す
+async function newGame() {
+	let create = localStorage.getItem("voda-create");
+	let query = create == null? "" : `?create=${encodeURIComponent(create)}`;
+	let result = await fetch(`/games${query}`, {
+		method: "POST",
+		body: JSON.stringify({
+			Black: document.querySelector("#new-black-select").value,
+			White: document.querySelector("#new-white-select").value,
+		}),
+	});
+	if (!result.ok) {
+		alert(await result.text());
+		return;
+	}
+	let data = await result.json();
+	localStorage.setItem(`voda-token-${data["ID"]}`, data["Token"]);
+	localStorage.setItem(`voda-join-${data["ID"]}`, data["Code"]);
+	selectGame(data["ID"]);
+}
+
+// 表示中の対局を取り除く
+async function removeGame() {
+	if (game_id == null) { return; }
+	await fetch(`/games/${game_id}?token=${token}`, { method: "DELETE" });
+	localStorage.removeItem(`voda-token-${game_id}`);
+	localStorage.removeItem(`voda-join-${game_id}`);
+	location.search = "";
 }
 
 async function sendRequest(body) {
-	return await fetch("/game", {
+	body["token"] = token;
+	if (seat != null) { body["seat"] = seat["seat"]; }
+	return await fetch(`/games/${game_id}/game`, {
 		method: "POST",
 		body: JSON.stringify(body),
 	})
-		.then((result) => {
+		.then(async (result) => {
 		if (result.ok) {
 			return result.json();
 		};
+		// 受け付けられなかった操作(置けない列など)は理由を表示する
+		alert(await result.text());
 	}).then((data) => {
 		return data;
 	});
 }
 
+// 解析モードに切り替える
+function openAnalysis() {
+	location.search = "?analysis=1";
+}
+
+// 対局の表示に戻る
+function openGames() {
+	location.search = "";
+}
+
+// 解析モードを開始する
+// 対局によらず、盤に両方の石を自由に置いて局面を解析する
+function startAnalysis() {
+	document.body.classList.add("analysis");
+	document.querySelector("#mode-lbl").innerText = "Analysis";
+	document.querySelector("#result-lbl").innerText = "";
+	showAnalysisTurn();
+	analyze();
+}
+
+// 列に置かれた石の数
+function columnHeight(col) {
+	return analysis_history.filter((stone) => stone["col"] == col).length;
+}
+
+// 置いた石の数、次に置く側を表示
+function showAnalysisTurn() {
+	move_count = analysis_history.length + 1;
+	showTurn();
+}
+
+// 解析モードで列に石を置く
+// 置く色は選択に従う(Alternateは石の数から先後を交互にする)
+function placeAnalysisStone(col) {
+	let height = columnHeight(col);
+	if (height >= 6) { return; }
+
+	let color = document.querySelector("#analysis-color-select").value;
+	let turn = color == "black"? 0 : color == "white"? 1 : analysis_history.length%2;
+	// 盤面のビットは列*7+行、表示のマスは列*6+行
+	analysis_stones[turn] += 2**(col*7 + height);
+	analysis_history.push({"col": col, "turn": turn});
+	drop(col*6 + height, turn);
+
+	showAnalysisTurn();
+	analyze();
+}
+
+// 解析モードで最後に置いた石を取り除く
+function undoStone() {
+	let stone = analysis_history.pop();
+	if (stone == undefined) { return; }
+	analysis_stones[stone["turn"]] -= 2**(stone["col"]*7 + columnHeight(stone["col"]));
+	showStones(analysis_stones[0], analysis_stones[1]);
+
+	showAnalysisTurn();
+	analyze();
+}
+
+// 解析モードの盤をすべて空にする
+function clearAnalysis() {
+	analysis_stones = [0, 0];
+	analysis_history = [];
+	clearBoard();
+
+	showAnalysisTurn();
+	analyze();
+}
+
+// 解析モードの局面をサーバで解析し、列ごとの評価値を表示する
+// 解析できない局面(石の数が合わない、既に揃っているなど)は理由を表示する
+async function analyze() {
+	if (!analysis_mode) { return; }
+	let seq = ++analysis_seq;
+	let lbl = document.querySelector("#analysis-lbl");
+	showScores(null);
+	newRotatingStr("Analyzing...", "analysis-lbl");
+
+	if (analysis_abort != null) { analysis_abort.abort(); }
+	analysis_abort = new AbortController();
+	let result;
+	try {
+		result = await fetch("/analysis", {
+			method: "POST",
+			body: JSON.stringify({
+				BlackStones: analysis_stones[0],
+				WhiteStones: analysis_stones[1],
+				Depth: Number(document.querySelector("#analysis-depth-select").value),
+			}),
+			signal: analysis_abort.signal,
+		});
+	} catch (e) {
+		// 新しい局面を送るために取り消した
+		if (seq != analysis_seq) { return; }
+		lbl.innerText = String(e);
+		return;
+	}
+	// 結果を待つ間に局面が変わった
+	if (seq != analysis_seq) { return; }
+	if (!result.ok) {
+		lbl.innerText = await result.text();
+		return;
+	}
+
+	let analysis = await result.json();
+	showScores(analysis);
+	let best = analysis["Columns"][analysis["Best"]];
+	lbl.innerText = `${analysis["ToMove"] == "black"? "Black" : "White"} to move. Best: ${analysis["Best"]} (${best["PV"].join(" ")})`;
+}
+
+// 盤の上に列ごとの評価値、決着までの手数を表示する(nullの場合は消す)
+// 最善の列を強調し、読み筋は列にカーソルを合わせると表示する
+function showScores(analysis) {
+	for (let col=0; col<7; col++) {
+		let cell = document.querySelector(`#score-${col}`);
+		cell.innerHTML = "";
+		cell.title = "";
+		cell.classList.remove("best", "win", "loss");
+		if (analysis == null) { continue; }
+
+		let column = analysis["Columns"][col];
+		if (!column["Legal"]) {
+			cell.innerText = "-";
+			continue;
+		}
+		let score = document.createElement("div");
+		score.innerText = column["Score"] > 0? `+${column["Score"]}` : `${column["Score"]}`;
+		let distance = document.createElement("small");
+		if (column["Outcome"] == "win") {
+			distance.innerText = `W in ${column["Distance"]}`;
+			cell.classList.add("win");
+		} else if (column["Outcome"] == "loss") {
+			distance.innerText = `L in ${column["Distance"]}`;
+			cell.classList.add("loss");
+		}
+		cell.appendChild(score);
+		cell.appendChild(distance);
+		cell.title = `PV: ${column["PV"].join(" ")}`;
+		if (col == analysis["Best"]) { cell.classList.add("best"); }
+	}
+}
+
 // 回転する文字列をidの要素に追加する
 function newRotatingStr(str, id) {
 	let elm = document.querySelector("#" + id);
@@ -202,6 +680,12 @@ function drop(pos, turn) {
 	);
 }
 
+// 石を置く(落とす動きなし)
+function placeStone(pos, turn) {
+	let id = ("0"+String(pos)).slice(-2);
+	document.querySelector("#board-" + id).innerHTML = `<div class="stone ${turn == 0? "black" : "white"}" id="stone-${id}"></div>`;
+}
+
 // 盤面の表示をリセット
 function clearBoard() {
 	for (i=0; i<42; i++) {
@@ -220,5 +704,35 @@ document.querySelector("#board").addEventListener("click", function(event) {
 	let cell = document.querySelector("#board-00");
 	let cellSize = cell.getBoundingClientRect().width;
 
-	col = Math.floor(clickX / cellSize);
+	let col = Math.floor(clickX / cellSize);
+
+	// 解析モードでは手番によらず石を置く
+	if (analysis_mode) {
+		if (col >= 0 && col < 7) { placeAnalysisStone(col); }
+		return;
+	}
+
+	// 人間の手番のみ、クリックした列を送る(結果はmoveイベントで受け取る)
+	// 履歴を遡って表示している間は送らない
+	if (isMyTurn() && view_ply == null) {
+		sendRequest({
+			command: "drop",
+			col: col,
+		});
+	}
 });
+
+// URLで渡された生成用のトークンは保存し、次からはURLになくても用いる
+if (new URLSearchParams(location.search).get("create") != null) {
+	localStorage.setItem("voda-create", new URLSearchParams(location.search).get("create"));
+}
+loadPlayerChoices();
+if (analysis_mode) {
+	// 解析モードは対局を購読しない
+	startAnalysis();
+} else {
+	loadGames().then(() => {
+		loadCredentials();
+		subscribeEvents();
+	});
+}
@@ -0,0 +1,53 @@
+package game
+
+import "io/fs"
+import "embed"
+import "net/http"
+
+/*
+#assets
+ブラウザに配信する静的ファイル(html, css, javascript)
+・実行ファイルに埋め込むため、起動したディレクトリによらず配信できる
+・テーマのディレクトリを指定した場合、同じ名前のファイルはそのディレクトリのものを配信する
+  盤、石の色などはtheme.css(style.cssのCSS変数を上書きする)で変えられる
+*/
+//go:embed asset
+var assets embed.FS;
+
+/*
+#themeFS
+テーマのディレクトリのファイルを優先し、なければ埋め込んだファイルを開くファイルシステム
+・テーマのディレクトリはファイルのみ用い、ディレクトリの一覧は公開しない
+*/
+type themeFS struct {
+  theme http.FileSystem  // テーマのディレクトリ(nilの場合は埋め込んだファイルのみ)
+  assets http.FileSystem // 埋め込んだファイル
+}
+
+/*
+#newAssetFS
+配信する静的ファイルのファイルシステムを生成
+
+*引数
+theme_dir string: テーマのディレクトリ(空の場合は埋め込んだファイルのみ)
+
+*返り値
+http.FileSystem: ファイルシステム
+*/
+func newAssetFS(theme_dir string) http.FileSystem {
+  // 埋め込んだasset/をルートとする(パスは固定のため失敗しない)
+  sub, _ := fs.Sub(assets, "asset");
+  var t themeFS = themeFS{ assets: http.FS(sub) };
+  if (theme_dir != "") { t.theme = http.Dir(theme_dir); }
+  return t;
+}
+
+func (t themeFS) Open(name string) (http.File, error) {
+  if (t.theme != nil) {
+    if f, err := t.theme.Open(name); err == nil {
+      if info, err := f.Stat(); err == nil && !info.IsDir() { return f, nil; }
+      f.Close();
+    }
+  }
+  return t.assets.Open(name);
+}
@@ -3,267 +3,670 @@ package game
 import "net"
 import "fmt"
 import "sync"
-import "strconv"
-import "strings"
+import "time"
+import "errors"
+import "crypto/rand"
+import "encoding/hex"
+
+import "voda/record"
+import "voda/protocol"
 
 /*
 #コマンド
-name
-  *プレイヤー名を要求
-  Param: -
-  Msg: name
-
-start
-  *ゲーム開始
-  Param:
-    Turn bool: 先後
-      先手: true, 後手: false
-  Msg: start (black, white)
-
-go
-  *次の手を要求
-  Param:
-    Stones uint64      : 自分の石の配置
-    OppStones uint64   : 相手の石の配置
-    Moves []uint8      : 操作履歴
-    ValidMoves []uint8 : 合法手のリスト
-  Msg: go (Stones) (OppStones) (Moves) (ValidMoves)
-
-end
-  *ゲーム終了
-  Param:
-    Result string: 結果(win, lose, draw)
-  Msg: end (win, lose, draw)
-
-quit
-  *プレイヤー終了
-  Param: -
-  Msg: -
+・メッセージの仕様、変換はvoda/protocolを参照
 */
 
 /*
-#connectToPlayer
-プレイヤーとソケット通信を行う
+#SocketPlayer
+ソケット通信によるプレイヤー
+・指定ポートで接続を待ち受け、プレイヤーとのやり取りは別プロセスで行う
+・プロセスとはチャネルでパラメータ、応答を受け渡す
+・通信に失敗した後は、以後のすべてのコマンドに同じエラーを返す
+・session機能に対応するプレイヤーは、切断後の猶予時間内であれば再接続できる
+・ロビー(lobby.go)が受け付けた接続からも生成される
+*/
+type SocketPlayer struct {
+  negotiation
+  label string // ログ出力に用いる名前(ポート番号など)
+  grace time.Duration // 再接続を待つ猶予時間(0は再接続を待たない)
+  session string      // 再接続に用いるセッショントークン
+
+  ln net.Listener             // 再接続を待ち受けるリスナ(ロビー経由の場合はnil)
+  resumed chan *socketConn    // ロビーが受け付けた再接続(再接続を待っている間のみ受け取る)
+  failed chan struct{}        // 通信に失敗し、再接続できなかったことの通知
+  done chan struct{}          // 通信の終了の通知
+  quit_once sync.Once         // quitを一度だけ送る
+
+  param_channel chan PlayerParam // パラメータ送信用チャネル
+  ret_channel chan socketRet     // 返り値受信用チャネル
+
+  wg *sync.WaitGroup // 通信終了を待つためのWaitGroup
+
+  mu sync.Mutex              // connの保護(cancelは別のgoroutineから呼ばれる)
+  conn net.Conn              // 通信中の接続
+  stop chan struct{}         // 思考の打ち切りの通知
+  stop_once sync.Once        // stopを一度だけ閉じる
+}
+
+// 通信プロセスからの応答
+type socketRet struct {
+  ret PlayerRet // プレイヤーの応答
+  err error     // 通信、プロトコルの失敗
+}
+
+/*
+#NewSocketPlayer
+ソケット通信によるプレイヤーを生成し、接続の待ち受けを開始する
 
 *引数
-param_channel chan PlayerParam: プレイヤーに送る情報を受け取るチャネル
-ret_channel chan PlayerRet    : プレイヤーから受け受け取った情報を送信するチャネル
-port uint                     : 通信先のポート番号
-wg *sync.WaitGroup            : 通信終了のためWaitGroupが必要
+port uint          : 通信に用いるポート番号
+grace time.Duration: 切断後、再接続を待つ猶予時間(0は再接続を待たない)
+
+*返り値
+*SocketPlayer: プレイヤー
+error        : 待ち受けを開始できない場合のエラー
 */
-func connectToPlayer(
-  param_channel chan PlayerParam,
-  ret_channel chan PlayerRet,
-  port uint, wg *sync.WaitGroup,
-) {
-  // 通信を開始
+func NewSocketPlayer(port uint, grace time.Duration) (*SocketPlayer, error) {
+  session, err := newSessionToken();
+  if (err != nil) { return nil, err; }
+
+  // 待ち受けを開始
   ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port));
-  if err != nil {
+  if (err != nil) { return nil, err; }
+
+  var p *SocketPlayer = newSocketPlayer(fmt.Sprint(port), grace, session);
+  if (grace != 0) { p.ln = ln; }
+
+  // 通信を確立する
+  p.wg.Add(1);
+  go p.connectToPlayer(ln);
+
+  return p, nil;
+}
+
+/*
+#newSocketPlayer
+ソケット通信によるプレイヤーを生成(通信は開始しない)
+
+*引数
+label string       : ログ出力に用いる名前
+grace time.Duration: 切断後、再接続を待つ猶予時間(0は再接続を待たない)
+session string     : 再接続に用いるセッショントークン
+
+*返り値
+*SocketPlayer: プレイヤー
+*/
+func newSocketPlayer(label string, grace time.Duration, session string) *SocketPlayer {
+  return &SocketPlayer {
+    label: label,
+    grace: grace,
+    session: session,
+    resumed: make(chan *socketConn),
+    failed: make(chan struct{}),
+    done: make(chan struct{}),
+    stop: make(chan struct{}),
+    param_channel: make(chan PlayerParam),
+    ret_channel: make(chan socketRet),
+    wg: new(sync.WaitGroup),
+  };
+}
+
+/*
+#newSessionToken
+推測できないセッショントークンを生成
+
+*返り値
+string: セッショントークン(16進数)
+error : 乱数生成のエラー
+*/
+func newSessionToken() (string, error) {
+  var buf []byte = make([]byte, 16);
+  if _, err := rand.Read(buf); err != nil { return "", err; }
+  return hex.EncodeToString(buf), nil;
+}
+
+/*
+Command: name
+Param:
+  Version int          : ゲームのプロトコルバージョン
+  Capabilities []string: ゲームが対応する機能
+  Session string       : セッショントークン(最初の接続のみ)
+
+Ret:
+  Name string          : プレイヤー名
+  Version int          : プレイヤーのプロトコルバージョン
+  Capabilities []string: プレイヤーが対応する機能
+*/
+func (p *SocketPlayer) Name() (string, error) {
+  var param PlayerParam = nameParam();
+  param.Session = p.session;
+  ret, err := p.send(param);
+  if (err != nil) { return "", err; }
+  p.negotiate(ret);
+  return ret.Name, nil;
+}
+
+/*
+Command: start
+Param:
+  Turn bool: 先後
+    先手: true, 後手: false
+
+Ret:
+  Ok bool: 開始の確認
+*/
+func (p *SocketPlayer) Start(turn bool) error {
+  _, err := p.send(PlayerParam{ Command: "start", Turn: turn });
+  return err;
+}
+
+/*
+Command: go
+Param:
+  Stones uint64      : 自分の石の配置
+  OppStones uint64   : 相手の石の配置
+  Moves []uint8      : 操作履歴
+  ValidMoves []uint8 : 合法手のリスト
+
+Ret:
+  Move uint8: 操作
+・moveまでに受け取ったinfoはparam.Infoに渡す(info機能に対応する場合のみ)
+*/
+func (p *SocketPlayer) Go(param PlayerParam) (PlayerRet, error) {
+  return p.send(p.goParam(param));
+}
+
+/*
+Command: end
+Param:
+  Result uint8 : 結果(0:win, 1:lose, 2:draw)
+  Reason string: 終局理由(reason機能に対応する場合のみ)
+
+Ret: -
+*/
+func (p *SocketPlayer) End(result uint8, reason string) error {
+  _, err := p.send(p.endParam(result, reason));
+  return err;
+}
+
+/*
+Command: undo
+Param:
+  Undone int   : 取り消した手数
+  Moves []uint8: 取り消した後の操作履歴
+
+Ret: -
+・undo機能に対応しない場合は送らない
+*/
+func (p *SocketPlayer) Undo(count int, moves []uint8) error {
+  if (!p.supports(protocol.CAP_UNDO)) { return nil; }
+  _, err := p.send(undoParam(count, moves));
+  return err;
+}
+
+/*
+Command: quit
+Param: -
+
+Ret: -
+・応答を待たず、通信の終了を待つ
+・2回目以降、通信を終了した後に呼ばれた場合は送信せず、終了を待つのみとする
+*/
+func (p *SocketPlayer) Quit() {
+  p.quit_once.Do(func() {
+    select {
+    case p.param_channel <- PlayerParam{ Command: "quit" }:
+    case <-p.done:
+    }
+  });
+  p.wg.Wait();
+}
+
+func (p *SocketPlayer) Identity() string {
+  return "socket:" + p.label;
+}
+
+/*
+#cancel
+通信中の接続を切断し、以後は再接続を待たない
+・応答を待っているgoは切断として戻る
+*/
+func (p *SocketPlayer) cancel() {
+  p.stop_once.Do(func() {
+    p.mu.Lock();
+    defer p.mu.Unlock();
+    close(p.stop);
+    if (p.conn != nil) { p.conn.Close(); }
+  });
+}
+
+/*
+#setConn
+通信中の接続を記録する
+・打ち切られた後の接続はすぐに切断する
+
+*引数
+sc *socketConn: 接続(nilの場合は記録を消す)
+*/
+func (p *SocketPlayer) setConn(sc *socketConn) {
+  p.mu.Lock();
+  defer p.mu.Unlock();
+  p.conn = nil;
+  if (sc == nil) { return; }
+  p.conn = sc.conn;
+  select {
+  case <-p.stop:
+    sc.conn.Close();
+  default:
   }
+}
+
+// プレイヤーとの1つの接続
+type socketConn struct {
+  conn net.Conn            // 接続
+  reader *protocol.Reader  // プレイヤーからの読み込み
+  writer *protocol.Writer  // プレイヤーへの書き込み
+}
+
+/*
+#newSocketConn
+接続からメッセージの読み書きを準備する
+
+*引数
+conn net.Conn: 接続
+
+*返り値
+*socketConn: 接続
+*/
+func newSocketConn(conn net.Conn) *socketConn {
+  // メッセージは改行で区切る
+  return &socketConn{ conn: conn, reader: protocol.NewReader(conn), writer: protocol.NewWriter(conn) };
+}
+
+/*
+#connectToPlayer
+最初の接続を待ち、プレイヤーとソケット通信を行う
+・再接続を待たない場合は最初の接続の後に待ち受けを終了する
+
+*引数
+ln net.Listener: 待ち受けを開始したリスナ
+*/
+func (p *SocketPlayer) connectToPlayer(ln net.Listener) {
+  // 待ち受けは終了時に必ず閉じる
+  defer ln.Close();
 
   // 通信に用いるTCPConn構造体を取得
-  conn, err := ln.Accept();
-  // コネクションは終了時に必ず切断
-  defer conn.Close();
-  // WaitGroupのカウンタを1減ずる
-  defer wg.Done();
-  if err != nil {
-    return;
+  var sc *socketConn;
+  conn, conn_err := ln.Accept();
+  if (conn_err != nil) {
+    conn_err = disconnectError(protocol.CMD_NAME, conn_err);
+  } else {
+    sc = newSocketConn(conn);
   }
+  if (p.grace == 0) { ln.Close(); }
+
+  p.serve(sc, conn_err);
+}
+
+/*
+#serve
+quitを受け取るまでパラメータの送信と応答の受信を繰り返す
+・切断された場合、猶予時間内の再接続を待ち、応答を得られなかったパラメータを送り直す
+・パラメータを待つ間の切断も検知し、猶予時間内の再接続を待つ
+・通信に失敗した後は、quitを受け取るまで以後のすべてのパラメータに同じエラーを返す
+
+*引数
+sc *socketConn: 接続(接続できなかった場合はnil)
+conn_err error: 接続のエラー
+*/
+func (p *SocketPlayer) serve(sc *socketConn, conn_err error) {
+  // WaitGroupのカウンタを1減ずる
+  defer p.wg.Done();
+  defer close(p.done);
+  if (conn_err != nil) { close(p.failed); }
+  p.setConn(sc);
 
   for {
     // プレイヤーに送信するパラメータを受け取る
-    param, ok := <- param_channel;
-    if (!ok) {
-      break
+    param, ok, idle_err := p.waitParam(sc, conn_err);
+    // 待っている間に切断された場合は再接続を待つ
+    if (idle_err != nil) {
+      sc.conn.Close();
+      sc, conn_err = p.reconnect(idle_err);
+      p.setConn(sc);
+      if (sc == nil) { close(p.failed); }
+      continue;
     }
-    // プレイヤーに送信するメッセージを組み立て
-    ok, msg := build_message(param);
-    fmt.Println(fmt.Sprintf("msg(%d)", port), msg)
     if (!ok) {
+      break
     }
 
-    // プレイヤーにメッセージを送信
-    _, err = conn.Write([]byte(msg))
-    if err != nil {
+    // 通信に失敗している場合は送信しない
+    if (conn_err != nil) {
+      if param.Command == "quit" { break; }
+      p.ret_channel <- socketRet{ err: conn_err };
+      continue;
     }
 
+    ret, err := exchangeMessage(param, sc.reader, sc.writer, p.codec(), p.label);
     // 送信したメッセージがquitだった場合、通信を終了
     if param.Command == "quit" {
       break;
     }
 
-    // プレイヤーからメッセージを受信
-    var buf []byte = make([]byte, 1024);
-    n, err := conn.Read(buf);
-    if err != nil {
+    // 切断された場合は再接続を待ち、送り直す
+    if (terminationOf(err) == record.TERMINATION_DISCONNECT && param.Command != protocol.CMD_NAME) {
+      sc.conn.Close();
+      sc, ret, err = p.resume(param, err);
+      p.setConn(sc);
+      // 再接続できなければ以後も同じエラーを返す
+      if (sc == nil) {
+        conn_err = err;
+        close(p.failed);
+      }
     }
-    fmt.Println(fmt.Sprintf("rsv(%d)", port), string(buf[:n]))
+    p.ret_channel <- socketRet{ ret: ret, err: err };
+  }
+
+  if (sc != nil) { sc.conn.Close(); }
+}
+
+// パラメータを待つ間の切断のエラーに記すコマンド
+const SOCKET_IDLE string = "idle";
+
+/*
+#waitParam
+プレイヤーに送信するパラメータを待つ
+・待っている間は接続を監視し、切断を検知した場合はパラメータを待たずに戻る
+・待っている間に届いた想定外のメッセージは読み捨てる
+
+*引数
+sc *socketConn: 接続(接続できなかった場合はnil)
+conn_err error: 接続のエラー(失敗している場合は監視しない)
+
+*返り値
+PlayerParam: 受け取ったパラメータ
+bool       : 受け取れたか(チャネルが閉じられた場合はfalse)
+error      : 待っている間の切断のエラー
+*/
+func (p *SocketPlayer) waitParam(sc *socketConn, conn_err error) (PlayerParam, bool, error) {
+  if (sc == nil || conn_err != nil) {
+    param, ok := <- p.param_channel;
+    return param, ok, nil;
+  }
 
-    // プレイヤーからの応答をPlayerRet構造体に変換し、ゲームに通知
-    var ret PlayerRet = buildPlayerRet(string(buf[:n]));
-    ret_channel <- ret;
+  var watched chan error = make(chan error, 1);
+  go func() {
+    for {
+      if err := sc.reader.WaitInput(); err != nil {
+        watched <- err;
+        return;
+      }
+      msg, err := sc.reader.ReadMessage();
+      if (err != nil) {
+        watched <- err;
+        return;
+      }
+      fmt.Println(fmt.Sprintf("rsv(%s) ignored while idle:", p.label), msg);
+    }
+  }();
+
+  select {
+  case param, ok := <- p.param_channel:
+    // 監視を読み込みの期限で止める(止める前に切断された場合は、送信で検知する)
+    sc.conn.SetReadDeadline(time.Now());
+    <-watched;
+    sc.conn.SetReadDeadline(time.Time{});
+    return param, ok, nil;
+  case err := <-watched:
+    return PlayerParam{}, true, disconnectError(SOCKET_IDLE, fmt.Errorf("disconnected while idle: %w", err));
   }
 }
 
 /*
-#sendMessage
-プレイヤーにメッセージを送信する
+#reconnect
+パラメータを待つ間に切断された場合に、猶予時間内の再接続を待つ
+・session機能に対応しないプレイヤー、猶予時間が0の場合、打ち切られた場合は再接続を待たない
 
 *引数
-param PlayerParam             : 送信するパラメータ
-param_channel chan PlayerParam: パラメータを送信するチャネル
-ret_channel chan PlayerRet    : プレイヤーの応答を受け取るチャネル
+err error: 切断のエラー
+
+*返り値
+*socketConn: 再接続した接続(再接続できない場合はnil)
+error      : 再接続できない場合のエラー
 */
-func sendMessage(param PlayerParam, param_channel chan PlayerParam, ret_channel chan PlayerRet) PlayerRet{
-  // パラメータをconnectToPlayerのプロセスへ送信
-  param_channel <- param;
+func (p *SocketPlayer) reconnect(err error) (*socketConn, error) {
+  if (p.grace == 0 || !p.supports(protocol.CAP_SESSION) || p.stopped()) {
+    fmt.Println(fmt.Sprintf("rsv(%s) %s", p.label, err));
+    return nil, err;
+  }
 
-  // connectToPlayerのプロセスからプレイヤーの応答を受け取り
-  var ret PlayerRet = <-ret_channel;
-  return ret;
+  fmt.Println(fmt.Sprintf("wait(%s) reconnect within %s: %s", p.label, p.grace, err));
+  sc, accept_err := p.waitSession(time.Now().Add(p.grace));
+  if (accept_err != nil) {
+    return nil, disconnectError(SOCKET_IDLE, fmt.Errorf("not reconnected within %s: %w", p.grace, causeOf(err)));
+  }
+  fmt.Println(fmt.Sprintf("resume(%s)", p.label));
+  return sc, nil;
 }
 
 /*
-#build_message
-プレイヤーに送信するメッセージ文字列を構成
+#resume
+猶予時間内の再接続を待ち、応答を得られなかったパラメータを送り直す
+・session機能に対応しないプレイヤー、猶予時間が0の場合、打ち切られた場合は再接続を待たない
+・送り直しの途中で再び切断された場合も、猶予時間内であれば再接続を待つ
 
 *引数
-param PlayerParam: コマンドのパラメータ
+param PlayerParam: 送り直すパラメータ
+err error        : 切断のエラー
+
+*返り値
+*socketConn: 再接続した接続(再接続できない場合はnil)
+PlayerRet  : 送り直したパラメータへの応答
+error      : 通信、プロトコルの失敗の*PlayerError
+*/
+func (p *SocketPlayer) resume(param PlayerParam, err error) (*socketConn, PlayerRet, error) {
+  if (p.grace == 0 || !p.supports(protocol.CAP_SESSION) || p.stopped()) { return nil, PlayerRet{}, err; }
+
+  fmt.Println(fmt.Sprintf("wait(%s) reconnect within %s: %s", p.label, p.grace, err));
+  var deadline time.Time = time.Now().Add(p.grace);
+  for {
+    sc, accept_err := p.waitSession(deadline);
+    if (accept_err != nil) {
+      return nil, PlayerRet{}, disconnectError(param.Command, fmt.Errorf("not reconnected within %s: %w", p.grace, causeOf(err)));
+    }
+    fmt.Println(fmt.Sprintf("resume(%s)", p.label));
+    p.setConn(sc);
+
+    ret, err := exchangeMessage(param, sc.reader, sc.writer, p.codec(), p.label);
+    if (terminationOf(err) != record.TERMINATION_DISCONNECT) { return sc, ret, err; }
+    sc.conn.Close();
+    if (p.stopped()) { return nil, PlayerRet{}, err; }
+  }
+}
+
+/*
+#stopped
+思考を打ち切られたか判定する
 
 *返り値
-bool  : エラーの有無
-string: メッセージ文字列
+bool: 打ち切られたか
 */
-func build_message(param PlayerParam) (bool, string) {
-  // メッセージ
-  var msg string;
-
-  var command string = param.Command;
-  switch command {
-  case "name": // 名称の問い合せ
-    msg = "name";
-  case "start": // ゲーム開始の通知
-    // 引数としてターンを通知
-    msg = fmt.Sprintf("start %s", make_turn_str(param));
-  case "go": // 次の手の要求
-    msg = fmt.Sprintf("go %s", build_go_msg_param(param));
-  case "end": // ゲーム終了の通知
-    msg = fmt.Sprintf("end %s", makeResultStr(param));
-  case "quit": // プレイヤーの終了
-    msg = fmt.Sprintf("quit");
+func (p *SocketPlayer) stopped() bool {
+  select {
+  case <-p.stop:
+    return true;
   default:
-    return false, "";
+    return false;
   }
-  return true, msg;
 }
 
 /*
-#make_turn_str
-手番を示す文字列を生成
+#waitSession
+期限までに再接続を受け付ける
+・自身で待ち受けている場合はacceptSession、ロビー経由の場合はロビーが検証した接続を待つ
+・打ち切られた場合は期限を待たずに戻る
 
 *引数
-param PlayerParam: パラメータ
+deadline time.Time: 期限
 
 *返り値
-string: 手番の文字列(black, white)
+*socketConn: 受け付けた接続
+error      : 期限までに受け付けられない場合のエラー
 */
-func make_turn_str(param PlayerParam) string {
-  // trueが先手、falseが後手
-  if (param.Turn) {
-    return "black";
-  } else {
-    return "white";
+func (p *SocketPlayer) waitSession(deadline time.Time) (*socketConn, error) {
+  if (p.ln != nil) {
+    // 打ち切られた場合は待ち受けを閉じ、acceptSessionを戻す
+    var accepted chan struct{} = make(chan struct{});
+    defer close(accepted);
+    go func() {
+      select {
+      case <-p.stop:
+        p.ln.Close();
+      case <-accepted:
+      }
+    }();
+    return p.acceptSession(p.ln, deadline);
+  }
+
+  select {
+  case sc := <-p.resumed:
+    return sc, nil;
+  case <-p.stop:
+    return nil, fmt.Errorf("cancelled");
+  case <-time.After(time.Until(deadline)):
+    return nil, fmt.Errorf("timeout");
   }
 }
 
 /*
-#build_go_msg_param
-goコマンドのパラメータを組み立て
+#acceptSession
+期限までに正しいセッショントークンを提示した接続を受け付ける
+・トークンを付けないnameを送り、setnameのトークンを検証する
+・トークンが一致しない接続は切断し、待ち受けを続ける
 
 *引数
-param PlayerParam: パラメータ
+ln net.Listener   : 待ち受けを開始したリスナ
+deadline time.Time: 期限
 
 *返り値
-string: パラメータ文字列
-
-stones_str string     : 石配置(10進表記)
-opp_stones_str string : 相手方石配置(10進表記)
-moves_str string      : 操作履歴
-valid_moves string    : 有効手
+*socketConn: 受け付けた接続
+error      : 期限までに受け付けられない場合のエラー
 */
-func build_go_msg_param(param PlayerParam) string {
-  // 石の配置を10進表記で文字列化
-  var stones_str string = fmt.Sprintf("%d", param.Stones);
-  var opp_stones_str string = fmt.Sprintf("%d", param.OppStones);
-
-  // 操作履歴と合法手を文字列化
-  /*
-  1. fmt.Sprintでデフォルト形式によって配列を文字列化(ex. [1 0 0])
-  2. strings.Replaceで空白を削除(ex. [100])
-  3. strings.Trimで両端の[]を削除(ex. 100)
-  */
-  var moves_str string = strings.Trim(strings.Replace(fmt.Sprint(param.Moves), " ", "", -1), "[]");
-  var valid_moves_str string = strings.Trim(strings.Replace(fmt.Sprint(param.ValidMoves), " ", "", -1), "[]");
-
-  // パラメータを連結する
-  return fmt.Sprintf("%s %s %s %s", stones_str, opp_stones_str, valid_moves_str, moves_str);
+func (p *SocketPlayer) acceptSession(ln net.Listener, deadline time.Time) (*socketConn, error) {
+  if tcp_ln, ok := ln.(*net.TCPListener); ok {
+    tcp_ln.SetDeadline(deadline);
+    defer tcp_ln.SetDeadline(time.Time{});
+  }
+
+  for {
+    conn, err := ln.Accept();
+    if (err != nil) { return nil, err; }
+
+    // 応答を待つ間も期限を過ぎれば打ち切る
+    conn.SetDeadline(deadline);
+    var sc *socketConn = newSocketConn(conn);
+    ret, err := exchangeMessage(nameParam(), sc.reader, sc.writer, protocol.Codec{}, p.label);
+    conn.SetDeadline(time.Time{});
+
+    if (err == nil && ret.Session == p.session) { return sc, nil; }
+    fmt.Println(fmt.Sprintf("rsv(%s) session rejected", p.label));
+    conn.Close();
+  }
 }
 
 /*
-#makeResultStr
-結果を通知する文字列を生成
+#exchangeMessage
+プレイヤーにメッセージを送信し、応答を受け取る
+・quitの場合は応答を待たない
+・機能はnameの応答を受けてから次のパラメータを送るまでに確定している
+・goへのinfoは、受け渡し先があればそれに渡し、応答を待ち続ける
 
 *引数
-param PlayerParam
+param PlayerParam       : 送信するパラメータ
+reader *protocol.Reader : プレイヤーからの読み込み
+writer *protocol.Writer : プレイヤーへの書き込み
+codec protocol.Codec    : メッセージの形式
+label string            : ログ出力に用いる名前
 
 *返り値
-string: 結果
+PlayerRet: プレイヤーの応答
+error    : 通信、プロトコルの失敗の*PlayerError
 */
-func makeResultStr(param PlayerParam) string {
-  switch param.Result {
-  case 0: return "win";
-  case 1: return "lose";
-  case 2: return "draw";
-  default: return "";
+func exchangeMessage(
+  param PlayerParam,
+  reader *protocol.Reader, writer *protocol.Writer,
+  codec protocol.Codec, label string,
+) (PlayerRet, error) {
+  // プレイヤーに送信するメッセージを組み立て
+  msg, err := codec.EncodeParam(param);
+  if (err != nil) { return PlayerRet{}, protocolError(param.Command, err); }
+  fmt.Println(fmt.Sprintf("msg(%s)", label), msg);
+
+  // プレイヤーにメッセージを送信
+  if err := writer.WriteMessage(msg); err != nil {
+    return PlayerRet{}, disconnectError(param.Command, err);
+  }
+  if (param.Command == "quit") { return PlayerRet{}, nil; }
+
+  for {
+    // プレイヤーからメッセージを受信
+    ret_msg, err := reader.ReadMessage();
+    if (err == protocol.ErrLineTooLong || errors.As(err, new(*protocol.ParseError))) {
+      return PlayerRet{}, protocolError(param.Command, err);
+    }
+    if (err != nil) {
+      return PlayerRet{}, disconnectError(param.Command, err);
+    }
+    fmt.Println(fmt.Sprintf("rsv(%s)", label), ret_msg);
+
+    // プレイヤーからの応答をPlayerRet構造体に変換
+    ret, err := codec.DecodeRet(ret_msg);
+    if (err != nil) { return ret, protocolError(param.Command, err); }
+    if (isInfo(param, ret)) {
+      param.Info(ret);
+      continue;
+    }
+    return ret, checkRet(param.Command, ret);
   }
 }
 
 /*
-#buildPlayerRet
-プレイヤーからの応答を文字列からPlayerRet構造体へ変換
+#isInfo
+goへの応答までに送られたinfoとして受け付けるか判定する
 
 *引数
-ret_msg string: プレイヤーから受信した文字列
+param PlayerParam: 送信したパラメータ
+ret PlayerRet    : プレイヤーの応答
 
 *返り値
-PlayerRet: プレイヤーからのメッセージより生成したPlayerRet構造体
+bool: infoとして受け渡し先に渡すか(falseの場合は通常の応答として検証する)
 */
-func buildPlayerRet(ret_msg string) (PlayerRet){
-  // プレイヤーからの応答を分解
-  var ret_words []string = strings.Split(ret_msg, " ");
-
-  // 応答の最初の単語はコマンド、残りはその引数として解釈
-  var command string = ret_words[0];
-  var ret_param_list []string = ret_words[1:];
-
-  var ret PlayerRet;
-  ret.Command = command;
-
-  switch command {
-  case "setname": // 名称の設定
-    ret.Name = ret_param_list[0];
-  case "ready": // 準備完了
-    ret.Ready = true;
-  case "move": // 次の手
-    move_int, err := strconv.Atoi(ret_param_list[0]);
-    if (err==nil) {
-      ret.Move = uint8(move_int);
-    }
-  case "bye": // 終了
-  default:
-    fmt.Println(fmt.Sprintf("Unknown Commnad `%s`", command));
+func isInfo(param PlayerParam, ret PlayerRet) bool {
+  return param.Command == protocol.CMD_GO && param.Info != nil && ret.Command == protocol.CMD_INFO;
+}
+
+/*
+#send
+プレイヤーにメッセージを送信する
+・通信を終了した後(Quitの後)は送信せず、切断のエラーを返す
+
+*引数
+param PlayerParam: 送信するパラメータ
+
+*返り値
+PlayerRet: プレイヤーの応答
+error    : 通信、プロトコルの失敗の*PlayerError
+*/
+func (p *SocketPlayer) send(param PlayerParam) (PlayerRet, error) {
+  // パラメータをconnectToPlayerのプロセスへ送信
+  select {
+  case p.param_channel <- param:
+  case <-p.done:
+    return PlayerRet{}, disconnectError(param.Command, fmt.Errorf("player has quit"));
   }
 
-  return ret;
+  // connectToPlayerのプロセスからプレイヤーの応答を受け取り
+  var ret socketRet = <-p.ret_channel;
+  return ret.ret, ret.err;
 }
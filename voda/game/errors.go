@@ -0,0 +1,116 @@
+package game
+
+import "fmt"
+import "errors"
+
+import "voda/record"
+import "voda/protocol"
+
+/*
+#PlayerError
+プレイヤーとのやり取りで生じたエラー
+・通信、プロトコルのいずれの失敗もこの型で返す
+・エラーを起こしたプレイヤーは負けとし、Reasonを終局理由として棋譜に記録する
+*/
+type PlayerError struct {
+  Command string // 送信したコマンド
+  Reason string  // 終局理由(record.TERMINATION_DISCONNECT, TERMINATION_PROTOCOL)
+  Err error      // 元のエラー
+}
+
+func (e *PlayerError) Error() string {
+  return fmt.Sprintf("%s (%s): %v", e.Command, e.Reason, e.Err);
+}
+
+func (e *PlayerError) Unwrap() error {
+  return e.Err;
+}
+
+/*
+#disconnectError
+通信の失敗(接続できない、切断された)を表すエラーを生成
+
+*引数
+command string: 送信したコマンド
+err error     : 元のエラー
+
+*返り値
+error: *PlayerError
+*/
+func disconnectError(command string, err error) error {
+  return &PlayerError{ Command: command, Reason: record.TERMINATION_DISCONNECT, Err: err };
+}
+
+/*
+#protocolError
+プロトコル違反(解釈できない、想定外の応答)を表すエラーを生成
+
+*引数
+command string: 送信したコマンド
+err error     : 元のエラー
+
+*返り値
+error: *PlayerError
+*/
+func protocolError(command string, err error) error {
+  return &PlayerError{ Command: command, Reason: record.TERMINATION_PROTOCOL, Err: err };
+}
+
+// コマンドに対する応答
+var expectedRet map[string]string = map[string]string {
+  protocol.CMD_NAME: protocol.CMD_SETNAME,
+  protocol.CMD_START: protocol.CMD_READY,
+  protocol.CMD_GO: protocol.CMD_MOVE,
+  protocol.CMD_END: protocol.CMD_BYE,
+  protocol.CMD_UNDO: protocol.CMD_READY,
+};
+
+/*
+#checkRet
+応答がコマンドに対応するものか検証する
+
+*引数
+command string: 送信したコマンド
+ret PlayerRet : プレイヤーの応答
+
+*返り値
+error: 想定外の応答の場合の*PlayerError
+*/
+func checkRet(command string, ret PlayerRet) error {
+  if expected, ok := expectedRet[command]; ok && ret.Command != expected {
+    return protocolError(command, fmt.Errorf("expected `%s`, got `%s`", expected, ret.Command));
+  }
+  return nil;
+}
+
+/*
+#causeOf
+*PlayerErrorの元のエラーを求める
+
+*引数
+err error: プレイヤーのエラー
+
+*返り値
+error: 元のエラー(*PlayerError以外はそのまま)
+*/
+func causeOf(err error) error {
+  var player_err *PlayerError;
+  if (errors.As(err, &player_err)) { return player_err.Err; }
+  return err;
+}
+
+/*
+#terminationOf
+エラーから終局理由を求める
+
+*引数
+err error: プレイヤーのエラー
+
+*返り値
+string: 終局理由(*PlayerError以外は中断)
+*/
+func terminationOf(err error) string {
+  var player_err *PlayerError;
+  if (errors.As(err, &player_err)) { return player_err.Reason; }
+  return record.TERMINATION_ABORT;
+}
@@ -0,0 +1,203 @@
+package game
+
+import "sync"
+import "encoding/json"
+
+/*
+#eventHub
+ブラウザへ送るイベント(Server-Sent Events)の配信
+・対局を所有するgoroutineが発行し、購読中のすべてのブラウザへ送る
+・保持するイベントは、後から購読したブラウザにも最初に送る
+・受け取りが追いつかないブラウザは購読を打ち切る(ブラウザは再接続する)
+*/
+type eventHub struct {
+  mu sync.Mutex
+  subscribers map[chan browserEvent]struct{} // 購読中のブラウザ
+  retained []browserEvent                    // 保持するイベント(名前ごとに最新のもの)
+  closed bool                                // 配信を終了したか
+}
+
+// ブラウザへ送るイベント
+type browserEvent struct {
+  Name string // イベント名
+  Data []byte // JSON
+}
+
+// イベント名
+const (
+  EVENT_PLAYERS string = "players" // プレイヤーの情報
+  EVENT_START string = "start"     // 対局の開始
+  EVENT_MOVE string = "move"       // 着手
+  EVENT_CLOCK string = "clock"     // 手番の経過時間
+  EVENT_END string = "end"         // 終局
+  EVENT_QUIT string = "quit"       // プレイヤーの終了
+  EVENT_STATE string = "state"     // 局面と着手の履歴(購読の開始時のみ)
+  EVENT_SEATS string = "seats"     // 席の確保の状況
+  EVENT_TAKEBACK string = "takeback" // 手の取り消し(取り消した後の局面)
+  EVENT_ASSIST string = "assist"     // 補助の切り替え
+  EVENT_INFO string = "info"         // エンジンの思考の状況(info)
+)
+
+// 購読ごとに溜められるイベントの数
+const EVENT_BUFFER int = 64;
+
+/*
+#newEventHub
+イベントの配信を生成
+
+*返り値
+*eventHub: 配信
+*/
+func newEventHub() *eventHub {
+  return &eventHub{ subscribers: map[chan browserEvent]struct{}{} };
+}
+
+/*
+#subscribe
+イベントを購読する
+・保持しているイベントを先に受け取る
+・配信が終了している場合は、保持しているイベントの後に閉じられる
+
+*返り値
+chan browserEvent: イベントを受け取るチャネル
+*/
+func (h *eventHub) subscribe() chan browserEvent {
+  h.mu.Lock();
+  defer h.mu.Unlock();
+
+  var ch chan browserEvent = make(chan browserEvent, EVENT_BUFFER + len(h.retained));
+  for _, event := range h.retained {
+    ch <- event;
+  }
+  if (h.closed) {
+    close(ch);
+    return ch;
+  }
+  h.subscribers[ch] = struct{}{};
+  return ch;
+}
+
+/*
+#unsubscribe
+購読をやめる
+
+*引数
+ch chan browserEvent: subscribeで得たチャネル
+*/
+func (h *eventHub) unsubscribe(ch chan browserEvent) {
+  h.mu.Lock();
+  defer h.mu.Unlock();
+
+  if _, ok := h.subscribers[ch]; ok {
+    delete(h.subscribers, ch);
+    close(ch);
+  }
+}
+
+/*
+#broadcast
+イベントを購読中のブラウザへ送る
+
+*引数
+name string: イベント名
+v any      : 内容(JSONに変換する)
+*/
+func (h *eventHub) broadcast(name string, v any) {
+  h.send(name, v, false);
+}
+
+/*
+#retain
+イベントを購読中のブラウザへ送り、後から購読したブラウザのために保持する
+・同じ名前の保持しているイベントは置き換える
+
+*引数
+name string: イベント名
+v any      : 内容(JSONに変換する)
+*/
+func (h *eventHub) retain(name string, v any) {
+  h.send(name, v, true);
+}
+
+/*
+#snapshot
+イベントを後から購読したブラウザのためだけに保持する
+・購読中のブラウザには送らない(局面の全体など、差分のイベントで足りるもの)
+
+*引数
+name string: イベント名
+v any      : 内容(JSONに変換する)
+*/
+func (h *eventHub) snapshot(name string, v any) {
+  data, err := json.Marshal(v);
+  if (err != nil) { return; }
+
+  h.mu.Lock();
+  defer h.mu.Unlock();
+  if (h.closed) { return; }
+  h.keep(browserEvent{ Name: name, Data: data });
+}
+
+/*
+#keep
+イベントを保持する(要ロック)
+・同じ名前の保持しているイベントは置き換える
+
+*引数
+event browserEvent: イベント
+*/
+func (h *eventHub) keep(event browserEvent) {
+  for i := range h.retained {
+    if (h.retained[i].Name == event.Name) {
+      h.retained[i] = event;
+      return;
+    }
+  }
+  h.retained = append(h.retained, event);
+}
+
+/*
+#send
+イベントを送る(broadcast, retainの本体)
+
+*引数
+name string: イベント名
+v any      : 内容(JSONに変換する)
+retain bool: 保持するか
+*/
+func (h *eventHub) send(name string, v any, retain bool) {
+  data, err := json.Marshal(v);
+  if (err != nil) { return; }
+  var event browserEvent = browserEvent{ Name: name, Data: data };
+
+  h.mu.Lock();
+  defer h.mu.Unlock();
+  if (h.closed) { return; }
+
+  if (retain) { h.keep(event); }
+
+  for ch := range h.subscribers {
+    select {
+    case ch <- event:
+    default:
+      // 追いつかないブラウザは打ち切る
+      delete(h.subscribers, ch);
+      close(ch);
+    }
+  }
+}
+
+/*
+#close
+配信を終了し、すべての購読を閉じる
+*/
+func (h *eventHub) close() {
+  h.mu.Lock();
+  defer h.mu.Unlock();
+
+  h.closed = true;
+  for ch := range h.subscribers {
+    delete(h.subscribers, ch);
+    close(ch);
+  }
+}
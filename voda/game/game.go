@@ -1,21 +1,26 @@
 package game
 
 import "fmt"
-import "sync"
+import "time"
+import "strings"
 
 import "voda/board"
+import "voda/record"
+import "voda/archive"
 
 /*
 #game
 コネクトフォーのゲームを管理
 ・盤面操作にvoda/boardを利用
 ・盤面、履歴をGameDataに記録
-・プレイヤー関数のエラーやタイムアウトは考慮しない
+・棋譜をRecordに記録し、RecordPath、ArchivePathが指定されていれば終局時に保存
+・プレイヤーの通信、プロトコルの失敗は負けとし、終局理由を棋譜に記録する
+・タイムアウトは考慮しない
 */
 
 /*
 #プレイヤー
-・プレイヤーは一つの関数の形で指定
+・プレイヤーはPlayerインターフェースとして指定(接続方法は問わない)
 ・プレイヤーへの要求はPlayerParamを通して伝達
 ・プレイヤーからの応答はPlayerRetを通して受付
 */
@@ -25,8 +30,8 @@ import "voda/board"
 ゲームを初期化し、開始する
 
 *引数
-black_port string: 先手のポート
-white_port string: 後手のポート
+black Player: 先手のプレイヤー
+white Player: 後手のプレイヤー
 
 show_board bool : 盤面の出力
 show_result bool: 結果の出力
@@ -39,28 +44,82 @@ uint8: 結果
   255: 異常終了
 */
 func (g *Game) StartCLI(
-  black_port uint, white_port uint,
+  black Player, white Player,
   show_board bool, show_result bool,
 ) uint8 {
   // ゲームの初期化
-  g.initializeGame(black_port, white_port);
+  g.initializeGame(black, white);
 
-  // プレイヤーに開始コマンドを送信
-  var start bool = g.sendStartCommand();
-  // いずれかが開始に失敗した場合、異常終了
-  if (!start) {
-    g.WaitGroup.Done();
+  // ゲームを進める
+  var result uint8 = g.playGame([]uint8{}, show_board);
+
+  // 結果表示
+  if (show_result) {
+    printResult(result);
+  }
+
+  // プレイヤーを終了させる
+  g.quitPlayer();
+
+  return result;
+}
+
+/*
+#playGame
+接続済みのプレイヤー間で1局を開始から終了まで進める
+・盤面を初期化してから開始コマンドを送信する
+・開局の手順が与えられた場合、プレイヤーに問い合わせずに盤面に適用する
+・終局時にはプレイヤーに終了コマンドを送信する
+・通信、プロトコルに失敗したプレイヤーは負けとする(両者が開始に失敗した場合は異常終了)
+
+*引数
+opening []uint8: 開局の手順
+show_board bool: 盤面の出力
+
+*返り値
+uint8: 結果
+  0: 先手勝
+  1: 後手勝
+  2: 引き分け
+  255: 異常終了
+*/
+func (g *Game) playGame(opening []uint8, show_board bool) uint8 {
+  var result uint8 = 2; // 結果(すべて埋まった場合は引き分け)
+  var termination string = record.TERMINATION_FULL; // 終局理由
+
+  // 盤面をリセットし、プレイヤーに開始コマンドを送信
+  black_err, white_err := g.sendStartCommand();
+  if (black_err != nil && white_err != nil) {
+    // 両方が開始に失敗した場合、異常終了
+    printPlayerError(true, black_err);
+    printPlayerError(false, white_err);
     return 255;
+  } else if (black_err != nil) {
+    result, termination = forfeit(true, black_err);
+  } else if (white_err != nil) {
+    result, termination = forfeit(false, white_err);
+  }
+
+  // 開局の手順を適用する(手順の正当性は読み込み時に検証済み)
+  for _, move := range opening {
+    g.dropStone(move);
   }
 
-  // ゲームを進める
   var valid bool; // 手が合法か
+  var err error; // プレイヤーのエラー
   var stones uint64; // 打った側の石
-  var result uint8; // 結果
-  for i:=0; i<42; i++ { // 最大42手(7*6)
+  // 開始に失敗した場合は対局を進めない
+  for black_err == nil && white_err == nil && g.Board.Counter < 42 { // 最大42手(7*6)
     // 次の手に進む
     // 正当な手であった(valid)かを返す
-    valid, _ = g.inquireNextMove();
+    valid, _, err = g.inquireNextMove();
+
+    // 通信、プロトコルに失敗した場合
+    if (err != nil) {
+      // 手番のプレイヤーの負け
+      result, termination = forfeit(g.Board.Counter%2 == 0, err);
+      break;
+    }
 
     // 盤面表示
     if (show_board) {
@@ -71,8 +130,9 @@ func (g *Game) StartCLI(
 
     // 非合法手が選択された場合
     if (!valid) {
-      // 相手の勝ちとしてゲームを終了
-      g.endGame(g.Board.Counter%2);
+      // 相手の勝ち
+      result = g.Board.Counter%2;
+      termination = record.TERMINATION_ILLEGAL;
       break;
     }
 
@@ -84,57 +144,91 @@ func (g *Game) StartCLI(
 
     // いずれかが勝利した場合
     if (board.CheckAlignment(stones)) {
-      g.endGame((g.Board.Counter+1)%2);
       result = (g.Board.Counter+1) % 2;
+      termination = record.TERMINATION_ALIGNMENT;
       break;
     }
   }
 
-  if (g.Board.Counter == 42) {
-    // すべて埋まった場合は引き分け
-    g.endGame(2);
-    result = 2;
-  }
+  // プレイヤーに結果を通知
+  g.endGame(result, termination);
 
-  // 結果表示
-  if (show_result) {
-    switch result {
-    case 0:
-      fmt.Println("Win: Black, Lose: White");
-    case 1:
-      fmt.Println("Win: White, Lose: Black");
-    case 2:
-      fmt.Println("Draw");
-    }
-  }
+  // 棋譜を保存
+  g.saveRecord(result, termination);
 
-  // プレイヤーを終了させる
-  g.quitPlayer();
+  return result;
+}
 
-  // 並行処理のWaitGroupを待つ
-  g.WaitGroup.Wait();
+/*
+#forfeit
+通信、プロトコルに失敗したプレイヤーの負けとする
 
-  return result;
+*引数
+black bool: 失敗したプレイヤーが先手か
+err error : プレイヤーのエラー
+
+*返り値
+uint8 : 結果
+string: 終局理由
+*/
+func forfeit(black bool, err error) (uint8, string) {
+  printPlayerError(black, err);
+  if (black) { return 1, terminationOf(err); }
+  return 0, terminationOf(err);
+}
+
+/*
+#printPlayerError
+プレイヤーのエラーを出力
+
+*引数
+black bool: エラーを起こしたプレイヤーが先手か
+err error : プレイヤーのエラー
+*/
+func printPlayerError(black bool, err error) {
+  var side string = "White";
+  if (black) { side = "Black"; }
+  fmt.Println(fmt.Sprintf("Player error (%s): %s", side, err));
+}
+
+/*
+#printResult
+結果を標準出力に出力
+
+*引数
+result uint8: 結果
+*/
+func printResult(result uint8) {
+  switch result {
+  case 0:
+    fmt.Println("Win: Black, Lose: White");
+  case 1:
+    fmt.Println("Win: White, Lose: Black");
+  case 2:
+    fmt.Println("Draw");
+  default:
+    fmt.Println("Aborted");
+  }
 }
 
 /*
 #InitializeGame
 ゲームを初期化する
 ・盤面の初期化
-・ゲーム情報の初期化
-・プレイヤーとの接続
+・プレイヤーの設定
 ・プレイヤー情報の取得
 
 *引数
-black_port uint: 先手のポート番号
-white_port uint: 後手のポート番号
+black Player: 先手のプレイヤー
+white Player: 後手のプレイヤー
 */
-func (g *Game) initializeGame(black_port uint, white_port uint) {
+func (g *Game) initializeGame(black Player, white Player) {
   // 盤面を初期化
   g.initializeBoard();
 
-  // プレイヤーと接続
-  g.initializeConnection(black_port, white_port);
+  // プレイヤーを設定
+  (*g).Black = black;
+  (*g).White = white;
 
   // プレイヤーの名前を設定
   g.inquirePlayerName();
@@ -152,112 +246,48 @@ func (g *Game) initializeBoard() {
     []uint8{},  // Moves
     0,          // Counter
   };
-}
 
-/*
-#initializeConnection
-プレイヤーと接続を確立
-・通信は各々のプレイヤーに対し、並行で実行する
-
-*引数
-black_port uint:  先手のポート番号
-white_port uint: 後手のポート番号
-*/
-func (g *Game) initializeConnection(black_port uint, white_port uint) {
-  // プレイヤーとの通信は並行処理で実行するため、それらのWaitGroupを準備
-  var wg *sync.WaitGroup = new(sync.WaitGroup);
-  // 両プレイヤーに対し通信を行うため、プロセス2つ分待つ
-  wg.Add(2);
-
-  // WaitGroup, portを保持
-  (*g).WaitGroup = wg;
-  (*g).BlackPort = black_port;
-  (*g).WhitePort = white_port;
-
-  // 通信を行うためのチャネルを用意
-  // param_channel: プレイヤーへの送信
-  // ret_channel  : プレイヤーからの受信
-
-  if (black_port != 0) {
-    var black_param_channel chan PlayerParam = make(chan PlayerParam);
-    var black_ret_channel chan PlayerRet = make(chan PlayerRet);
-    (*g).BlackParamChannel = black_param_channel;
-    (*g).BlackRetChannel = black_ret_channel;
-    // 通信を確立する
-    go connectToPlayer(black_param_channel, black_ret_channel, black_port, wg);
-  }
-
-  if (white_port != 0) {
-    var white_param_channel chan PlayerParam = make(chan PlayerParam);
-    var white_ret_channel chan PlayerRet = make(chan PlayerRet);
-    (*g).WhiteParamChannel = white_param_channel;
-    (*g).WhiteRetChannel = white_ret_channel;
-    // 通信を確立する
-    go connectToPlayer(white_param_channel, white_ret_channel, white_port, wg);
-  }
+  // 棋譜の初期化
+  (*g).Record = record.New(g.BlackName, g.WhiteName, time.Now());
 }
 
 /*
 #inquirePlayerName
 プレイヤーの名称を取得
-
-Command: name
-Param: -
-
-Ret:
-  Name string: プレイヤー名
+・接続の確立は各々のプレイヤーで並行に行われる
+・名前を取得できない場合は先後を名前とする(失敗は開始時、着手時に改めて検出される)
 */
 func (g *Game) inquirePlayerName() {
-  var black_name string;
-  var white_name string;
-
-  // 名称の要求を送信
-  if (g.BlackPort != 0) {
-    black_name = sendMessage(PlayerParam{ Command: "name" }, g.BlackParamChannel, g.BlackRetChannel).Name;
+  // プレイヤー名を設定
+  var err error;
+  if (*g).BlackName, err = g.Black.Name(); err != nil {
+    printPlayerError(true, err);
+    (*g).BlackName = "Black";
   }
-
-  if (g.WhitePort != 0) {
-    white_name = sendMessage(PlayerParam{ Command: "name" }, g.WhiteParamChannel, g.WhiteRetChannel).Name;
+  if (*g).WhiteName, err = g.White.Name(); err != nil {
+    printPlayerError(false, err);
+    (*g).WhiteName = "White";
   }
-
-  // プレイヤー名を設定
-  (*g).BlackName = black_name;
-  (*g).WhiteName = white_name;
 }
 
 /*
 #SendStartCommand
-プレイヤーに開始コマンドを送信
+盤面をリセットし、プレイヤーに開始コマンドを送信
 
 *返り値
-bool: ゲーム開始の可否
-
-Command: start
-Param:
-  Turn bool: 先後
-    先手: true, 後手: false
-
-Ret:
-  Ok bool: 開始の確認
+error: 先手の開始の失敗
+error: 後手の開始の失敗
 */
-func (g Game) sendStartCommand() bool {
+func (g *Game) sendStartCommand() (error, error) {
   // 盤面のリセット
   g.initializeBoard();
 
   // 開始を通知する
-  // 準備ができた場合、Readyがtrue
-  var black_ok bool = true;
-  var white_ok bool = true;
-
-  if (g.BlackPort != 0) {
-    black_ok = sendMessage(PlayerParam{ Command: "start", Turn: true }, g.BlackParamChannel, g.BlackRetChannel).Ready;
-  }
-  if (g.WhitePort != 0) {
-    white_ok = sendMessage(PlayerParam{ Command: "start", Turn: false }, g.WhiteParamChannel, g.WhiteRetChannel).Ready;
-  }
+  var black_err error = g.Black.Start(true);
+  var white_err error = g.White.Start(false);
 
   // 両方準備できていた場合、ゲームを開始する
-  return black_ok && white_ok;
+  return black_err, white_err;
 }
 
 /*
@@ -267,59 +297,136 @@ func (g Game) sendStartCommand() bool {
 *返り値
 bool: 返却された手が合法手であるか
 uint8: 返却された手
+error: 通信、プロトコルの失敗(盤面は変更しない)
+*/
+func (g *Game) inquireNextMove() (bool, uint8, error) {
+  // 次の操作を要求する
+  var param PlayerParam = g.nextMoveParam();
+  var start time.Time = time.Now();
+  ret, err := g.currentPlayer().Go(param);
+  return g.applyMove(ret, time.Since(start), err);
+}
 
-Command: go
-Param:
-  Stones uint64      : 自分の石の配置
-  OppStones uint64   : 相手の石の配置
-  Moves []uint8      : 操作履歴
-  ValidMoves []uint8 : 合法手のリスト
+/*
+#nextMoveParam
+手番のプレイヤーに次の手を要求するパラメータを生成
 
-Ret:
-  Move uint8: 操作
+*返り値
+PlayerParam: goコマンドのパラメータ(操作履歴は写しを渡す)
 */
-func (g *Game) inquireNextMove() (bool, uint8) {
+func (g *Game) nextMoveParam() PlayerParam {
   var black bool = g.Board.Counter%2==0; // 先後
 
   var stones uint64;
   var opp_stones uint64;
-  var param_channel chan PlayerParam;
-  var ret_channel chan PlayerRet;
 
-  // 先後に応じ、プレイヤー関数、石の配置を設定
+  // 先後に応じ、石の配置を設定
   if (black) {
     stones = g.Board.BlackStones;
     opp_stones = g.Board.WhiteStones;
-    param_channel = g.BlackParamChannel;
-    ret_channel = g.BlackRetChannel;
   } else {
     stones = g.Board.WhiteStones;
     opp_stones = g.Board.BlackStones;
-    param_channel = g.WhiteParamChannel;
-    ret_channel = g.WhiteRetChannel;
   }
 
-  // 次の操作を要求する
-  var next_move uint8 = sendMessage(PlayerParam { 
+  return PlayerParam { 
     Command: "go",
     Stones: stones,
     OppStones: opp_stones,
-    Moves: g.Board.Moves,
+    Moves: append([]uint8{}, g.Board.Moves...),
     ValidMoves: board.GenValidMoves(stones, opp_stones),
-  }, param_channel, ret_channel).Move;
+    Rules: g.Record.Rules,
+    Info: g.forwardInfo(black),
+  };
+}
+
+/*
+#applyMove
+手番のプレイヤーの応答を盤面、棋譜に適用する
+
+*引数
+ret PlayerRet        : プレイヤーの応答
+elapsed time.Duration: 消費時間
+err error            : 通信、プロトコルの失敗
+
+*返り値
+bool: 返却された手が合法手であるか
+uint8: 返却された手
+error: 通信、プロトコルの失敗(盤面は変更しない)
+*/
+func (g *Game) applyMove(ret PlayerRet, elapsed time.Duration, err error) (bool, uint8, error) {
+  if (err != nil) { return false, ret.Move, err; }
+
+  valid, move := g.dropStone(ret.Move);
+
+  // 消費時間、評価値などを棋譜に記録
+  (*g).Record.Moves[len(g.Record.Moves)-1].Elapsed = elapsed;
+  (*g).Record.Moves[len(g.Record.Moves)-1].Comment = moveComment(ret);
+
+  return valid, move, nil;
+}
+
+/*
+#moveComment
+プレイヤーの応答から棋譜に記録するコメントを生成する
+・評価値は[%eval 値]、読み筋は[%pv 列]とする
+
+*引数
+ret PlayerRet: プレイヤーの応答
+
+*返り値
+string: コメント(記録がなければ空文字列)
+*/
+func moveComment(ret PlayerRet) string {
+  var parts []string;
+  if (ret.Score != nil) {
+    parts = append(parts, fmt.Sprintf("[%%eval %d]", *ret.Score));
+  }
+  if (len(ret.PV) > 0) {
+    var pv []string;
+    for _, col := range ret.PV { pv = append(pv, fmt.Sprint(col)); }
+    parts = append(parts, "[%pv " + strings.Join(pv, " ") + "]");
+  }
+  if (ret.Comment != "") {
+    parts = append(parts, ret.Comment);
+  }
+  return strings.Join(parts, " ");
+}
+
+/*
+#currentPlayer
+手番のプレイヤーを返す
 
-  return g.dropStone(next_move);
+*返り値
+Player: 手番のプレイヤー
+*/
+func (g *Game) currentPlayer() Player {
+  if (g.Board.Counter%2 == 0) { return g.Black; }
+  return g.White;
 }
 
+/*
+#dropStone
+石を落とし、盤面と棋譜を更新する
+・非合法手の場合も手数、履歴は進める
+
+*引数
+move uint8: 列
+
+*返り値
+bool : 合法手であったか
+uint8: 列
+*/
 func (g *Game) dropStone(move uint8) (bool, uint8) {
   var black bool = g.Board.Counter%2==0; // 先後
 
   // 手数のカウンタを進める
   (*g).Board.Counter++;
   (*g).Board.Moves = append(g.Board.Moves, move);
+  (*g).Record.Moves = append(g.Record.Moves, record.Move{ Col: move });
 
-  // 非合法手が返された
-  if (!board.CanMove(g.Board.BlackStones, g.Board.WhiteStones, move)) { return false, move; }
+  // 非合法手が返された(盤外の列を含む)
+  if (move >= 7 || !board.CanMove(g.Board.BlackStones, g.Board.WhiteStones, move)) { return false, move; }
 
   // 盤面の情報を更新
   if (black) {
@@ -335,6 +442,44 @@ func (g *Game) dropStone(move uint8) (bool, uint8) {
   return true, move;
 }
 
+/*
+#takeBack
+直前の手を取り消し、盤面と棋譜を戻す
+・取り消した後の操作履歴をプレイヤーに通知する(undo)
+
+*引数
+count int: 取り消す手数(履歴の手数まで)
+
+*返り値
+error: 先手への通知の失敗
+error: 後手への通知の失敗
+*/
+func (g *Game) takeBack(count int) (error, error) {
+  for i:=0; i<count && g.Board.Counter > 0; i++ {
+    var move uint8 = g.Board.Moves[len(g.Board.Moves)-1];
+
+    // 手数のカウンタを戻す
+    (*g).Board.Counter--;
+    (*g).Board.Moves = g.Board.Moves[:len(g.Board.Moves)-1];
+    (*g).Record.Moves = g.Record.Moves[:len(g.Record.Moves)-1];
+
+    // 取り消した手を打った側の石を除く
+    if (g.Board.Counter%2 == 0) {
+      (*g).Board.BlackStones = board.RemoveStone(
+        g.Board.BlackStones, g.Board.WhiteStones, move,
+      );
+    } else {
+      (*g).Board.WhiteStones = board.RemoveStone(
+        g.Board.WhiteStones, g.Board.BlackStones, move,
+      );
+    }
+  }
+
+  var black_err error = g.Black.Undo(count, g.Board.Moves);
+  var white_err error = g.White.Undo(count, g.Board.Moves);
+  return black_err, white_err;
+}
+
 /*
 #endGame
 ゲームを終了させる
@@ -344,33 +489,64 @@ result uint8: 結果
   0: 先手勝
   1: 後手勝
   2: 引き分け
+termination string: 終局理由
+*/
+func (g *Game) endGame(result uint8, termination string) {
+  g.endPlayer(true, result, termination);
+  g.endPlayer(false, result, termination);
+}
 
-Command: end
-Param:
-  Result uint8: 結果(0:win, 1:lose, 2:draw)
+/*
+#endPlayer
+一方のプレイヤーに終了を通知する
+・終局後の失敗は結果に影響しないため出力のみ行う
 
-Ret: -
+*引数
+black bool        : 先手に通知するか
+result uint8      : 結果(endGameと同じ)
+termination string: 終局理由
 */
-func (g *Game) endGame(result uint8) {
-  var black_result uint8 = 2; // draw
-  var white_result uint8 = 2; // draw
-
-  if (result == 0) {
-    // 先手勝利の場合
-    black_result = 0; // win
-    white_result = 1; // lose
-  } else {
-    // 後手勝利の場合
-    black_result = 1; // lose
-    white_result = 0; // win
+func (g *Game) endPlayer(black bool, result uint8, termination string) {
+  var player Player = g.White;
+  if (black) { player = g.Black; }
+
+  var player_result uint8 = 2; // draw
+  if (result == 0 || result == 1) {
+    // 勝った側にwin(0)、負けた側にlose(1)
+    player_result = 1;
+    if ((result == 0) == black) { player_result = 0; }
   }
 
   // 終了メッセージを送信
-  if (g.BlackPort != 0) {
-    sendMessage(PlayerParam{ Command: "end", Result: black_result }, g.BlackParamChannel, g.BlackRetChannel);
+  if err := player.End(player_result, termination); err != nil {
+    printPlayerError(black, err);
+  }
+}
+
+/*
+#saveRecord
+棋譜に結果を記録し、RecordPath、ArchivePathが指定されていればそれぞれに追記する
+
+*引数
+result uint8      : 結果
+termination string: 終局理由
+*/
+func (g *Game) saveRecord(result uint8, termination string) {
+  (*g).Record.Result = result;
+  (*g).Record.Termination = termination;
+
+  if (g.RecordPath != "") {
+    err := g.Record.Save(g.RecordPath);
+    if (err != nil) {
+      fmt.Println(fmt.Sprintf("Failed to save record: %s", err));
+    }
   }
-  if (g.WhitePort != 0) {
-    sendMessage(PlayerParam{ Command: "end", Result: white_result }, g.WhiteParamChannel, g.WhiteRetChannel);
+
+  if (g.ArchivePath != "") {
+    err := archive.Append(g.ArchivePath, g.Record);
+    if (err != nil) {
+      fmt.Println(fmt.Sprintf("Failed to archive game: %s", err));
+    }
   }
 }
 
@@ -379,12 +555,8 @@ func (g *Game) endGame(result uint8) {
 プレイヤーを終了させる
 */
 func (g *Game) quitPlayer() {
-  // 終了命令を送信
-  if (g.BlackPort != 0) {
-    go sendMessage(PlayerParam{ Command: "quit" }, g.BlackParamChannel, g.BlackRetChannel);
-  }
-  if (g.WhitePort != 0) {
-    go sendMessage(PlayerParam{ Command: "quit" }, g.WhiteParamChannel, g.WhiteRetChannel);
-  }
+  // 終了命令を送信し、終了を待つ
+  g.Black.Quit();
+  g.White.Quit();
 }
 
@@ -1,95 +1,1189 @@
 package game
 
 import "fmt"
+import "net"
 import "math"
+import "time"
+import "errors"
+import "strconv"
+import "strings"
+import "net/url"
 import "net/http"
+import "crypto/subtle"
 import "encoding/json"
 
 import "voda/board"
+import "voda/engine"
+import "voda/record"
 
 /*
 #StartBrowser
-http通信を介してクライアントと通信し、ゲームを開始する
+http通信を介してクライアントと通信し、対局を管理する
+・待ち受けを開始し、ブラウザで開くURLを表示してから応答する
+
+*引数
+port uint: ポート番号(0の場合は空いているものを割り当てる)
+
+*返り値
+error: http通信を開始できない場合のエラー
+*/
+func (s *Server) StartBrowser(port uint) error {
+  url, err := s.ListenBrowser(port);
+  if (err != nil) { return err; }
+  fmt.Println(s.CreateURL(url));
+  return s.ServeBrowser();
+}
+
+/*
+#ListenBrowser
+ブラウザからの接続の待ち受けを開始する
+・応答はServeBrowserで始める(待ち受けの後、対局のURLを表示してから応答できる)
+
+*引数
+port uint: ポート番号(0の場合は空いているものを割り当てる)
+
+*返り値
+string: ブラウザで開くURL(ex. http://localhost:8080)
+error : 待ち受けを開始できない場合のエラー
+*/
+func (s *Server) ListenBrowser(port uint) (string, error) {
+  ln, err := net.Listen("tcp", net.JoinHostPort(s.Host, strconv.FormatUint(uint64(port), 10)));
+  if (err != nil) { return "", err; }
+  s.ln = ln;
+  return browserURL(ln.Addr()), nil;
+}
+
+/*
+#CreateURL
+対局を生成できるURLを求める
+・生成用のトークンが設定されている場合はURLに含める
+
+*引数
+url string: ブラウザで開くURL(ListenBrowser)
+
+*返り値
+string: URL
 */
-func (g *Game) StartBrowser(
-  port uint, 
-  black_port uint, white_port uint,
-  show_board bool, show_result bool,
-) {
+func (s *Server) CreateURL(url string) string {
+  if (s.CreateToken == "") { return url; }
+  return url + "/?create=" + s.CreateToken;
+}
 
-  // 静的ファイル(html, css, javascript)のディレクトリを設定
-  http.Handle("/", http.FileServer(http.Dir("game/asset/")));
-  // /gameへのハンドラを設定
-  http.HandleFunc("/game", g.gameHandler)
-  fmt.Println("http://localhost:8080")
+/*
+#browserURL
+待ち受けのアドレスからブラウザで開くURLを求める
+・すべてのアドレスで待ち受ける場合はlocalhostとする
+
+*引数
+addr net.Addr: 待ち受けのアドレス
 
-  // ゲームの初期化
-  go g.initializeGame(black_port, white_port)
+*返り値
+string: URL
+*/
+func browserURL(addr net.Addr) string {
+  tcp, ok := addr.(*net.TCPAddr);
+  if (!ok) { return "http://" + addr.String(); }
 
-  // 指定ポート番号を用いてhttp通信
-  http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+  var host string = "localhost";
+  if (!tcp.IP.IsUnspecified()) { host = tcp.IP.String(); }
+  return "http://" + net.JoinHostPort(host, strconv.Itoa(tcp.Port));
+}
+
+/*
+#ServeBrowser
+ListenBrowserで開始した待ち受けで、クライアントと通信し、対局を管理する
+・ブラウザ上の人間はHumanPlayerとして指定する
+・対局へのリクエストは/games/{id}/gameで対局ごとに振り分ける
+
+*返り値
+error: http通信が終了した場合のエラー
+*/
+func (s *Server) ServeBrowser() error {
+  var mux *http.ServeMux = http.NewServeMux();
+
+  // 静的ファイル(html, css, javascript)は埋め込んだものを配信する(テーマのディレクトリで置き換えられる)
+  mux.Handle("/", http.FileServer(newAssetFS(s.ThemeDir)));
+  // 対局の一覧、生成
+  mux.HandleFunc("/games", s.gamesHandler);
+  // 対局の生成時に選べるプレイヤーの一覧
+  mux.HandleFunc("/players", func(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, s.PlayerChoices());
+  });
+  // 対局ごとのハンドラ
+  mux.HandleFunc("/games/", s.gameRouter);
+  // 対局ごとの資源を扱うJSONのAPI(api.go)
+  mux.HandleFunc("/api/games", s.apiGamesHandler);
+  mux.HandleFunc("/api/games/", s.apiGameRouter);
+  // 解析モード(対局によらない局面の解析)
+  mux.HandleFunc("/analysis", analysisHandler);
+
+  // 待ち受けたポート番号を用いてhttp通信
+  return http.Serve(s.ln, mux);
+}
+
+/*
+#gamesHandler
+/gamesに対するハンドラ
+・GETで対局の一覧を返す
+・POSTで{Black, White}(human, lobby, engine:ID, process:ID)を指定して対局を生成し、{ID, Token(操作用のトークン), Code(参加用のコード)}を返す
+・生成はローカルホスト、またはcreate=(生成用のトークン)を示したリクエストのみ受け付ける(canCreate)
+*/
+func (s *Server) gamesHandler(w http.ResponseWriter, r *http.Request) {
+  switch r.Method {
+  case http.MethodGet:
+    writeJSON(w, http.StatusOK, s.Games());
+
+  case http.MethodPost:
+    if (!s.canCreate(r)) {
+      http.Error(w, "creating games is not allowed from this client", http.StatusForbidden);
+      return;
+    }
+    var request struct {
+      Black string
+      White string
+    };
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest);
+      return;
+    }
+    created, err := s.CreateGameBySpec(request.Black, request.White);
+    if (err != nil) {
+      http.Error(w, err.Error(), createStatus(err));
+      return;
+    }
+    writeJSON(w, http.StatusCreated, created);
+
+  default:
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed);
+  }
+}
+
+/*
+#gameRouter
+/games/{id}以下のリクエストを対局に振り分ける
+・DELETE /games/{id}?token=(操作用のトークン)で対局を取り除く
+・POST /games/{id}/gameは対局のgameHandlerで処理する
+・GET /games/{id}/eventsは対局のeventsHandlerで処理する
+・GET /games/{id}/position?ply=(手数)は対局のpositionHandlerで処理する
+・GET /games/{id}/hint?token=(操作用のトークン)&code=(参加用のコード)は対局のhintHandlerで処理する
+*/
+func (s *Server) gameRouter(w http.ResponseWriter, r *http.Request) {
+  var parts []string = strings.Split(strings.TrimPrefix(r.URL.Path, "/games/"), "/");
+  var id string = parts[0];
+
+  if (len(parts) == 1 && r.Method == http.MethodDelete) {
+    g, ok := s.Game(id);
+    if (!ok) {
+      http.Error(w, fmt.Sprintf("game `%s` not found", id), http.StatusNotFound);
+      return;
+    }
+    if (!g.authorized(r.URL.Query().Get("token"))) {
+      http.Error(w, "spectators cannot remove the game", http.StatusForbidden);
+      return;
+    }
+    if err := s.RemoveGame(id); err != nil {
+      http.Error(w, err.Error(), http.StatusNotFound);
+      return;
+    }
+    w.WriteHeader(http.StatusNoContent);
+    return;
+  }
+
+  if (len(parts) == 2 && parts[1] == "game" && r.Method == http.MethodPost) {
+    g, ok := s.Game(id);
+    if (!ok) {
+      http.Error(w, fmt.Sprintf("game `%s` not found", id), http.StatusNotFound);
+      return;
+    }
+    g.gameHandler(w, r);
+    return;
+  }
+
+  if (len(parts) == 2 && parts[1] == "events" && r.Method == http.MethodGet) {
+    g, ok := s.Game(id);
+    if (!ok) {
+      http.Error(w, fmt.Sprintf("game `%s` not found", id), http.StatusNotFound);
+      return;
+    }
+    g.eventsHandler(w, r);
+    return;
+  }
+
+  if (len(parts) == 2 && parts[1] == "position" && r.Method == http.MethodGet) {
+    g, ok := s.Game(id);
+    if (!ok) {
+      http.Error(w, fmt.Sprintf("game `%s` not found", id), http.StatusNotFound);
+      return;
+    }
+    g.positionHandler(w, r);
+    return;
+  }
+
+  if (len(parts) == 2 && parts[1] == "hint" && r.Method == http.MethodGet) {
+    g, ok := s.Game(id);
+    if (!ok) {
+      http.Error(w, fmt.Sprintf("game `%s` not found", id), http.StatusNotFound);
+      return;
+    }
+    g.hintHandler(w, r);
+    return;
+  }
+
+  http.NotFound(w, r);
+}
+
+// 同時に行う解析の数
+const ANALYSIS_CONCURRENCY int = 2;
+
+// 解析中の枠(ANALYSIS_CONCURRENCYまで)
+var analysis_slots chan struct{} = make(chan struct{}, ANALYSIS_CONCURRENCY);
+
+/*
+#analysisHandler
+/analysisに対するハンドラ(解析モード)
+・POSTで{BlackStones, WhiteStones, Depth}の局面を解析し、Analysisを返す
+・対局によらないため、手番は石の数から求める
+・探索は重いため、同時に行う解析はANALYSIS_CONCURRENCYまでとし、超えた分は順に待たせる
+*/
+func analysisHandler(w http.ResponseWriter, r *http.Request) {
+  if (r.Method != http.MethodPost) {
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed);
+    return;
+  }
+
+  var request AnalysisRequest;
+  if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest);
+    return;
+  }
+  if (request.Depth == 0) { request.Depth = engine.ANALYSIS_DEPTH; }
+  if (request.Depth < 1 || request.Depth > engine.ANALYSIS_MAX_DEPTH) {
+    http.Error(w, fmt.Sprintf("depth must be between 1 and %d", engine.ANALYSIS_MAX_DEPTH), http.StatusBadRequest);
+    return;
+  }
+  black, err := engine.ValidatePosition(request.BlackStones, request.WhiteStones);
+  if (err != nil) {
+    http.Error(w, err.Error(), http.StatusBadRequest);
+    return;
+  }
+
+  // 待つ間にブラウザが諦めた(新しい局面を送った)場合は解析しない
+  select {
+  case analysis_slots <- struct{}{}:
+    defer func() { <-analysis_slots; }();
+  case <-r.Context().Done():
+    return;
+  }
+
+  // 手番の側から見て解析する
+  var stones, opp_stones uint64 = request.BlackStones, request.WhiteStones;
+  if (!black) { stones, opp_stones = opp_stones, stones; }
+  var analysis Analysis = Analysis{
+    ToMove: sideName(black),
+    Depth: request.Depth,
+    Best: -1,
+    Columns: engine.Analyze(stones, opp_stones, request.Depth),
+  };
+
+  // 評価値が最も高い列(同じ場合は中央に近い列)
+  var best_score int = engine.NO_SCORE;
+  for _, col := range engine.SEARCH_ORDER {
+    var column engine.ColumnAnalysis = analysis.Columns[col];
+    if (column.Legal && column.Score > best_score) {
+      analysis.Best = int(col);
+      best_score = column.Score;
+    }
+  }
+  writeJSON(w, http.StatusOK, analysis);
+}
+
+/*
+#writeJSON
+JSONのレスポンスを書き込む
+
+*引数
+w http.ResponseWriter: レスポンス
+status int           : ステータスコード
+v any                : 書き込む値
+*/
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json; charset=UTF-8");
+  w.WriteHeader(status);
+  json.NewEncoder(w).Encode(v);
 }
 
 /*
 #gameHandler
-/gameに対するハンドラ
-クライアントとの通信、ゲームとのやり取り
+/games/{id}/gameに対するハンドラ
+クライアントとの通信、対局を所有するgoroutineとのやり取り
+・操作の権限(操作用のトークン、席のトークン)は対局を所有するgoroutineが確かめる
 */
 func (g *Game) gameHandler(w http.ResponseWriter, r *http.Request) {
   // リクエストを受け取る構造体
   var request struct {
     Command string
     Col uint8
+    Token string
+    Seat string
+    Color string
+    Code string
+    Assist bool
   };
   // リクエストをパース
   json.NewDecoder(r.Body).Decode(&request);
 
+  // 対局を所有するgoroutineに処理させる
+  response, err := g.sendCommand(browserCommand{
+    Command: request.Command,
+    Col: request.Col,
+    Token: request.Token,
+    Seat: request.Seat,
+    Color: request.Color,
+    Code: request.Code,
+    Assist: request.Assist,
+  });
+  if (errors.Is(err, errForbidden)) {
+    http.Error(w, err.Error(), http.StatusForbidden);
+    return;
+  } else if (err != nil) {
+    http.Error(w, err.Error(), http.StatusConflict);
+    return;
+  }
+
+  // レスポンス設定
+  w.Header().Set("Content-Type", "application/json; charset=UTF-8");
+  json.NewEncoder(w).Encode(response);
+}
+
+/*
+#eventsHandler
+/games/{id}/eventsに対するハンドラ
+・対局のイベントをServer-Sent Eventsとして送り続ける
+・購読の開始時に、保持しているイベント(プレイヤーの情報など)を送る
+*/
+func (g *Game) eventsHandler(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher);
+  if (!ok) {
+    http.Error(w, "streaming unsupported", http.StatusInternalServerError);
+    return;
+  }
+
+  var events chan browserEvent = g.hub.subscribe();
+  defer g.hub.unsubscribe(events);
+
+  w.Header().Set("Content-Type", "text/event-stream");
+  w.Header().Set("Cache-Control", "no-cache");
+  flusher.Flush();
+
+  for {
+    select {
+    case <-r.Context().Done():
+      return;
+    case event, ok := <-events:
+      // 対局の終了、または受け取りが追いつかない場合
+      if (!ok) { return; }
+      fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, event.Data);
+      flusher.Flush();
+    }
+  }
+}
+
+/*
+#positionHandler
+/games/{id}/positionに対するハンドラ
+・plyで指定した手数の局面を返す(省略時は現在の局面)
+・観戦者も参照できる
+*/
+func (g *Game) positionHandler(w http.ResponseWriter, r *http.Request) {
+  var ply int = -1;
+  if (r.URL.Query().Has("ply")) {
+    var err error;
+    ply, err = strconv.Atoi(r.URL.Query().Get("ply"));
+    if (err != nil || ply < 0) {
+      http.Error(w, "invalid ply", http.StatusBadRequest);
+      return;
+    }
+  }
+
+  position, err := g.Position(ply);
+  if (err != nil) {
+    http.Error(w, err.Error(), http.StatusNotFound);
+    return;
+  }
+  writeJSON(w, http.StatusOK, position);
+}
+
+/*
+#Position
+着手の履歴中の局面を求める
+・対局を所有するgoroutineが最後に公開した棋譜を、初期局面から適用し直す
+
+*引数
+ply int: 手数(0は初期局面、負の場合は現在の局面)
+
+*返り値
+Position: 局面
+error   : 手数が履歴を超える場合のエラー
+*/
+func (g *Game) Position(ply int) (Position, error) {
+  var rec *record.Record = &g.published.Load().record;
+  if (ply < 0) { ply = len(rec.Moves); }
+  if (ply > len(rec.Moves)) {
+    return Position{}, fmt.Errorf("ply %d is beyond the %d moves played", ply, len(rec.Moves));
+  }
+
+  var position Position = Position{ Ply: ply, Total: len(rec.Moves), Moves: []int{}, LastMove: -1 };
+  for _, m := range rec.Moves[:ply] {
+    position.Moves = append(position.Moves, int(m.Col));
+  }
+  // 非合法手は終局の手であり、石を落とさない(その前の局面となる)
+  position.BlackStones, position.WhiteStones, _ = rec.Position(ply);
+  if (ply > 0) { position.LastMove = position.Moves[ply-1]; }
+  return position, nil;
+}
+
+// ヒントの探索の深さ(手数)
+const HINT_DEPTH int = engine.ANALYSIS_DEPTH;
+
+/*
+#hintHandler
+/games/{id}/hintに対するハンドラ
+・補助を有効にした対局中に、手番の側に推奨する列と、双方の脅威(置けば4つ揃うマス)を返す
+・操作用のトークン(token)、または参加用のコード(code)を示したブラウザのみに返す(観戦者には返さない)
+・対局を所有するgoroutineが公開した局面から求め、エンジンの思考中も待たない
+・探索は対局ごとに1つずつ行い、同じ局面へのヒントは探索し直さない
+*/
+func (g *Game) hintHandler(w http.ResponseWriter, r *http.Request) {
+  var query url.Values = r.URL.Query();
+  if (!g.authorized(query.Get("token")) && subtle.ConstantTimeCompare([]byte(query.Get("code")), []byte(g.join)) != 1) {
+    http.Error(w, "only players can see hints", http.StatusForbidden);
+    return;
+  }
+
+  var state *GameState = &g.published.Load().state;
+  if (!state.Assist) {
+    http.Error(w, "hints are disabled for this game", http.StatusConflict);
+    return;
+  }
+  if (!state.Playing) {
+    http.Error(w, "game is not in progress", http.StatusConflict);
+    return;
+  }
+
+  writeJSON(w, http.StatusOK, g.hintFor(state));
+}
+
+/*
+#hintFor
+局面へのヒントを求める
+・直前に求めた局面と同じであれば、探索せずに同じヒントを返す
+・同時に求められた場合は1つずつ探索する
+
+*引数
+state *GameState: 公開された局面
+
+*返り値
+Hint: ヒント
+*/
+func (g *Game) hintFor(state *GameState) Hint {
+  g.hint_mu.Lock();
+  defer g.hint_mu.Unlock();
+  if (g.hint_cache != nil && g.hint_cache.black == state.BlackStones && g.hint_cache.white == state.WhiteStones) {
+    return g.hint_cache.hint;
+  }
+
+  var black bool = state.Counter%2 == 0;
+  var stones, opp_stones uint64 = state.BlackStones, state.WhiteStones;
+  if (!black) { stones, opp_stones = opp_stones, stones; }
+  col, score := engine.Search(stones, opp_stones, HINT_DEPTH);
+
+  var hint Hint = Hint{
+    ToMove: sideName(black),
+    Col: int(col),
+    Score: score,
+    BlackThreats: board.Threats(state.BlackStones, state.WhiteStones),
+    WhiteThreats: board.Threats(state.WhiteStones, state.BlackStones),
+  };
+  g.hint_cache = &hintCache{ black: state.BlackStones, white: state.WhiteStones, hint: hint };
+  return hint;
+}
+
+/*
+#startOwner
+対局を所有するgoroutineを開始する
+・以降、盤面、プレイヤーはこのgoroutineのみが操作し、操作はsendCommandで依頼する
+
+*引数
+black Player: 先手のプレイヤー
+white Player: 後手のプレイヤー
+*/
+func (g *Game) startOwner(black Player, white Player) {
+  g.commands = make(chan browserCommand);
+  g.done = make(chan struct{});
+  g.removed = make(chan struct{});
+  g.hub = newEventHub();
+  g.published.Store(&gameSnapshot{
+    state: GameState{ Moves: []int{}, Result: 3 },
+    record: record.New("", "", time.Now()),
+  });
+  go g.runOwner(black, white);
+}
+
+/*
+#runOwner
+対局を所有するgoroutineの本体
+・プレイヤーとの接続の後、ブラウザからの操作を1つずつ処理する
+・対局中、エンジンの手番では別のgoroutineで次の手を取得させ、思考中も操作を受け付ける
+・取得した手は盤面に適用し、結果をイベントで送る
+・quitを処理する、または取り除かれると終了する
+
+*引数
+black Player: 先手のプレイヤー
+white Player: 後手のプレイヤー
+*/
+func (g *Game) runOwner(black Player, white Player) {
+  defer close(g.done);
+  defer g.hub.close();
+  defer g.stopClock();
+
+  // 接続中に届いた操作は接続の後に処理される
+  g.initializeGame(black, white);
+  g.publishInfo();
+  g.hub.retain(EVENT_PLAYERS, g.playersResponse());
+
+  for {
+    if (g.playing && !g.isHumanTurn() && g.thinking == nil) { g.startThinking(); }
+
+    // 思考中でなければthinkingはnilのため、操作のみを待つ
+    select {
+    case t := <-g.thinking:
+      g.finishThinking(t);
+      g.publishInfo();
+
+    case <-g.removed:
+      g.handleCommand(browserCommand{ Command: "quit", Token: g.control });
+      return;
+
+    case cmd := <-g.commands:
+      response, err := g.handleCommand(cmd);
+      g.publishInfo();
+      cmd.reply <- browserReply{ response: response, err: err };
+      if (response.Quit) { return; }
+    }
+  }
+}
+
+/*
+#startThinking
+手番のエンジンに別のgoroutineで次の手を求める(対局を所有するgoroutineのみが呼ぶ)
+・パラメータは所有するgoroutineで生成し、思考するgoroutineは盤面に触れない
+・結果はthinkingに届き、finishThinkingで適用する
+*/
+func (g *Game) startThinking() {
+  var param PlayerParam = g.nextMoveParam();
+  var player Player = g.currentPlayer();
+  var thinking chan thought = make(chan thought, 1);
+  g.thinking = thinking;
+  g.thinker = player;
+  g.thinking_black = g.Board.Counter%2 == 0;
+
+  go func() {
+    var start time.Time = time.Now();
+    ret, err := player.Go(param);
+    thinking <- thought{ ret: ret, err: err, elapsed: time.Since(start) };
+  }();
+}
+
+/*
+#finishThinking
+エンジンの思考の結果を盤面に適用し、イベントで送る(対局を所有するgoroutineのみが呼ぶ)
+・思考中に中断した対局の結果は捨て、エンジンに終了を通知する
+
+*引数
+t thought: 思考の結果
+*/
+func (g *Game) finishThinking(t thought) {
+  g.thinking = nil;
+  g.thinker = nil;
+  if (g.abandoned) {
+    g.abandoned = false;
+    if (t.err != nil) {
+      printPlayerError(g.thinking_black, t.err);
+      return;
+    }
+    g.endPlayer(g.thinking_black, record.RESULT_UNKNOWN, record.TERMINATION_ABORT);
+    return;
+  }
+
+  var response Response;
+  valid, next_move, err := g.applyMove(t.ret, t.elapsed, t.err);
+  g.dropStoneBrowser(&response, next_move, valid, err);
+  g.notifyMove(response, err);
+}
+
+/*
+#quitBrowser
+プレイヤーを終了させる(対局を所有するgoroutineのみが呼ぶ)
+・思考中のエンジンは打ち切り(canceler)、応答を待ってから別のgoroutineで終了させる
+・プロセス内の関数は打ち切れないため、応答まで終了を待つ
+*/
+func (g *Game) quitBrowser() {
+  if (g.thinking == nil) {
+    g.quitPlayer();
+    return;
+  }
+  if c, ok := g.thinker.(canceler); ok { c.cancel(); }
+
+  var thinking chan thought = g.thinking;
+  var black, white Player = g.Black, g.White;
+  g.thinking = nil;
+  g.thinker = nil;
+  go func() {
+    <-thinking;
+    black.Quit();
+    white.Quit();
+  }();
+}
+
+/*
+#sendCommand
+対局を所有するgoroutineに操作を依頼し、処理結果を待つ
+
+*引数
+cmd browserCommand: 操作(start, drop, takeback, claim, assist, abort, quit)
+
+*返り値
+Response: クライアントへのレスポンス
+error   : 対局が終了している、操作を受け付けられない場合のエラー
+*/
+func (g *Game) sendCommand(cmd browserCommand) (Response, error) {
+  cmd.reply = make(chan browserReply, 1);
+  select {
+  case g.commands <- cmd:
+  case <-g.done:
+    return Response{}, fmt.Errorf("game is closed");
+  }
+  var reply browserReply = <-cmd.reply;
+  return reply.response, reply.err;
+}
+
+/*
+#handleCommand
+ブラウザからの操作を処理する(対局を所有するgoroutineのみが呼ぶ)
+・エンジンの手は自動で進めるため、moveは受け付けない
+・start: 操作用のトークン、またはいずれかの席のトークン(対局中は受け付けない)
+・drop : 手番の席が確保されていればその席のトークン、なければ操作用のトークン(置けない列は受け付けない)
+・takeback: 人間とエンジンの対局で、人間の手番にdropと同じ権限
+・claim: 参加用のコード、または操作用のトークン
+・assist: 操作用のトークン、またはいずれかの席のトークン
+・abort: 操作用のトークン
+・quit : 操作用のトークン
+
+*引数
+cmd browserCommand: 操作
+
+*返り値
+Response: クライアントへのレスポンス
+error   : 操作を受け付けられない場合のエラー(権限がない場合はerrForbidden)
+*/
+func (g *Game) handleCommand(cmd browserCommand) (Response, error) {
   var response Response;
 
   // リクエストのコマンドに従い、処理を行う
-  switch request.Command {
+  switch cmd.Command {
   case "start": // ゲーム開始
+    if (!g.authorized(cmd.Token) && g.seatOf(cmd.Seat) < 0) {
+      return response, fmt.Errorf("%w: only players can start the game", errForbidden);
+    }
+    // 対局中の対局を破棄しない(中断してから開始する)
+    if (g.playing) {
+      return response, fmt.Errorf("game is in progress, abort it before starting a new one");
+    }
+    if (g.thinking != nil) {
+      return response, fmt.Errorf("the engine is still thinking about the aborted game");
+    }
     g.startGameBrowser(&response);
+    g.playing = response.Result == 3;
+    if (g.playing) { g.startClock(); }
+    g.publishInfo();
+    g.hub.retain(EVENT_PLAYERS, g.playersResponse());
+    g.hub.broadcast(EVENT_START, response);
+    if (!g.playing) { g.hub.broadcast(EVENT_END, response); }
 
   case "drop": // 石を落とす
-    valid, next_move := g.dropStone(request.Col);
-    g.dropStoneBrowser(&response, next_move, valid);
-    case "move": // プレイヤーから次の手を取得
-    valid, next_move := g.inquireNextMove();
-    g.dropStoneBrowser(&response, next_move, valid);
+    // 対局中の人間の手番のみ、クリックされた列を渡す
+    if (!g.playing || !g.isHumanTurn()) {
+      return response, fmt.Errorf("not a human's turn");
+    }
+    if err := g.checkSide(cmd); err != nil { return response, err; }
+    // 人間の誤操作では負けとせず、置けない列は受け付けない
+    if (cmd.Col > 6 || !board.CanMove(g.Board.BlackStones, g.Board.WhiteStones, cmd.Col)) {
+      return response, fmt.Errorf("column %d is full or out of range", cmd.Col);
+    }
+    g.currentPlayer().(*HumanPlayer).Submit(cmd.Col);
+    // 人間の消費時間は手番の開始(startClock)から数える
+    ret, err := g.currentPlayer().Go(g.nextMoveParam());
+    valid, next_move, err := g.applyMove(ret, time.Since(g.turn_started), err);
+    g.dropStoneBrowser(&response, next_move, valid, err);
+    g.notifyMove(response, err);
+
+  case "takeback": // 人間の直前の手まで取り消す(待った)
+    if err := g.takeBackBrowser(cmd, &response); err != nil { return response, err; }
+
+  case "claim": // 人間の側の席を確保
+    seat, err := g.claimSeat(cmd);
+    if (err != nil) { return response, err; }
+    response.Seat = seat;
+
+  case "assist": // 補助(ヒント、脅威の表示)の切り替え
+    if (!g.authorized(cmd.Token) && g.seatOf(cmd.Seat) < 0) {
+      return response, fmt.Errorf("%w: only players can change hints", errForbidden);
+    }
+    g.setAssist(cmd.Assist);
+
+  case "abort": // 対局を中断
+    if (!g.authorized(cmd.Token)) {
+      return response, fmt.Errorf("%w: spectators cannot operate the game", errForbidden);
+    }
+    if (!g.playing) {
+      return response, fmt.Errorf("game is not in progress");
+    }
+    g.abortGame(&response);
 
   case "quit": // プレイヤーを終了
-    g.quitPlayer();
+    if (!g.authorized(cmd.Token)) {
+      return response, fmt.Errorf("%w: spectators cannot operate the game", errForbidden);
+    }
+    g.quitBrowser();
     response.Quit = true;
+    g.hub.retain(EVENT_QUIT, response);
 
   default:
-    fmt.Println("???")
+    return response, fmt.Errorf("unknown command `%s`", cmd.Command);
   }
 
-  // レスポンス設定
-  w.Header().Set("Content-Type", "application/json; charset=UTF-8");
-  json.NewEncoder(w).Encode(response);
+  return response, nil;
+}
+
+/*
+#forwardInfo
+手番のプレイヤーから受け取ったinfoをブラウザへ送る関数を生成(対局を所有するgoroutineのみが呼ぶ)
+・生成した関数はプレイヤーとの通信のgoroutineからも呼ばれるため、生成時の手数のみを用いる
+・ブラウザでの対局でない場合はnil(infoは読み捨てられる)
+
+*引数
+black bool: 先手の手番か
+
+*返り値
+func(PlayerRet): infoの受け渡し先
+*/
+func (g *Game) forwardInfo(black bool) func(PlayerRet) {
+  var hub *eventHub = g.hub;
+  if (hub == nil) { return nil; }
+  var ply int = int(g.Board.Counter) + 1;
+
+  return func(info PlayerRet) {
+    var event InfoEvent = InfoEvent{
+      Black: black,
+      Ply: ply,
+      Depth: info.Depth,
+      Score: info.Score,
+      Nodes: info.Nodes,
+      PV: []int{},
+      Rates: info.Rates,
+    };
+    if (event.Rates == nil) { event.Rates = []float64{}; }
+    for _, col := range info.PV { event.PV = append(event.PV, int(col)); }
+    hub.broadcast(EVENT_INFO, event);
+  };
+}
+
+/*
+#setAssist
+補助(ヒント、脅威の表示)を切り替える(対局を所有するgoroutineのみが呼ぶ)
+・対局中に有効にした場合は、その対局の棋譜を補助ありとする(後から無効にしても残す)
+・開始前に有効にした場合は、開始時に棋譜を補助ありとする
+
+*引数
+enabled bool: 補助を有効にするか
+*/
+func (g *Game) setAssist(enabled bool) {
+  g.assist = enabled;
+  if (g.playing && enabled) { g.Record.Assisted = true; }
+  g.hub.retain(EVENT_ASSIST, AssistEvent{ Enabled: enabled });
+}
+
+/*
+#abortGame
+対局を結果なしで中断する(対局を所有するgoroutineのみが呼ぶ)
+・プレイヤーには引き分けとして終了を通知し(終局理由abort)、棋譜の結果は不明(*)とする
+・思考中のエンジンは待たず、応答を捨ててから終了を通知する(finishThinking)
+
+*引数
+response *Response: 中断した局面を設定するレスポンス
+*/
+func (g *Game) abortGame(response *Response) {
+  response.BlackStones = g.Board.BlackStones;
+  response.WhiteStones = g.Board.WhiteStones;
+  response.Board = g.Board.BlackStones | g.Board.WhiteStones;
+  response.Counter = g.Board.Counter;
+  response.Result = record.RESULT_UNKNOWN;
+  response.Termination = record.TERMINATION_ABORT;
+
+  if (g.thinking != nil) {
+    g.endPlayer(!g.thinking_black, response.Result, response.Termination);
+    g.abandoned = true;
+  } else {
+    g.endGame(response.Result, response.Termination);
+  }
+  g.saveRecord(response.Result, response.Termination);
+
+  g.playing = false;
+  g.stopClock();
+  g.publishInfo();
+  g.hub.broadcast(EVENT_END, *response);
+}
+
+/*
+#checkSide
+手番の側を操作できるブラウザか確かめる
+・手番の席が確保されていればその席のトークン、なければ操作用のトークンを求める
+
+*引数
+cmd browserCommand: 操作(Token, Seat)
+
+*返り値
+error: 操作できない場合のerrForbidden
+*/
+func (g *Game) checkSide(cmd browserCommand) error {
+  var side int = int(g.Board.Counter%2);
+  if (g.seats[side] != "" && g.seatOf(cmd.Seat) != side) {
+    return fmt.Errorf("%w: the side to move is held by another browser", errForbidden);
+  }
+  if (g.seats[side] == "" && !g.authorized(cmd.Token)) {
+    return fmt.Errorf("%w: spectators cannot operate the game", errForbidden);
+  }
+  return nil;
+}
+
+/*
+#takeBackBrowser
+人間とエンジンの対局で、人間の直前の手まで取り消す(対局を所有するgoroutineのみが呼ぶ)
+・人間の手番に、エンジンの応手と人間の手の2手を取り消す
+・エンジンには取り消した後の履歴をundoで通知し、局面はtakebackイベントで送る
+
+*引数
+cmd browserCommand: 操作(Token, Seat)
+response *Response: 取り消した後の局面を設定するレスポンス
+
+*返り値
+error: 取り消せない場合のエラー
+*/
+func (g *Game) takeBackBrowser(cmd browserCommand, response *Response) error {
+  _, black_human := g.Black.(*HumanPlayer);
+  _, white_human := g.White.(*HumanPlayer);
+  if (black_human == white_human) {
+    return fmt.Errorf("takeback is only available between a human and an engine");
+  }
+  if (!g.playing || !g.isHumanTurn()) {
+    return fmt.Errorf("not a human's turn");
+  }
+  if err := g.checkSide(cmd); err != nil { return err; }
+  if (g.Board.Counter < 2) {
+    return fmt.Errorf("no move to take back");
+  }
+
+  black_err, white_err := g.takeBack(2);
+  // 通知に失敗したプレイヤーは次のgoで改めて検出される
+  if (black_err != nil) { printPlayerError(true, black_err); }
+  if (white_err != nil) { printPlayerError(false, white_err); }
+
+  response.BlackStones = g.Board.BlackStones;
+  response.WhiteStones = g.Board.WhiteStones;
+  response.Board = g.Board.BlackStones | g.Board.WhiteStones;
+  response.Counter = g.Board.Counter;
+  response.Result = 3;
+
+  g.startClock();
+  g.publishInfo();
+  g.hub.broadcast(EVENT_TAKEBACK, g.gameState());
+  return nil;
+}
+
+/*
+#claimSeat
+人間の側の席を確保し、席のトークンを発行する(対局を所有するgoroutineのみが呼ぶ)
+・確保した席の着手はそのトークンを示したブラウザからのみ受け付ける
+
+*引数
+cmd browserCommand: 操作(Color, Code, Token)
+
+*返り値
+string: 席のトークン
+error : 確保できない場合のエラー
+*/
+func (g *Game) claimSeat(cmd browserCommand) (string, error) {
+  if (!g.authorized(cmd.Token) && subtle.ConstantTimeCompare([]byte(cmd.Code), []byte(g.join)) != 1) {
+    return "", fmt.Errorf("%w: wrong join code", errForbidden);
+  }
+
+  var side int;
+  var player Player;
+  switch cmd.Color {
+  case "black": side, player = 0, g.Black;
+  case "white": side, player = 1, g.White;
+  default:
+    return "", fmt.Errorf("unknown color `%s`", cmd.Color);
+  }
+  if _, ok := player.(*HumanPlayer); !ok {
+    return "", fmt.Errorf("%s is not played by a human", cmd.Color);
+  }
+  if (g.seats[side] != "") {
+    return "", fmt.Errorf("%s is already taken", cmd.Color);
+  }
+
+  seat, err := newSessionToken();
+  if (err != nil) { return "", err; }
+  g.seats[side] = seat;
+  g.hub.retain(EVENT_SEATS, SeatsEvent{ Black: g.seats[0] != "", White: g.seats[1] != "" });
+  return seat, nil;
+}
+
+/*
+#seatOf
+席のトークンから確保した側を求める
+
+*引数
+seat string: 席のトークン
+
+*返り値
+int: 0は先手、1は後手、-1は該当なし
+*/
+func (g *Game) seatOf(seat string) int {
+  for side, s := range g.seats {
+    if (s != "" && subtle.ConstantTimeCompare([]byte(seat), []byte(s)) == 1) { return side; }
+  }
+  return -1;
+}
+
+/*
+#notifyMove
+着手、終局をイベントで送り、次の手番の経過時間を計り始める(対局を所有するgoroutineのみが呼ぶ)
+・途中から購読したブラウザが着手を取りこぼさないよう、局面を更新してから送る
+・更新と送信の間に購読したブラウザは同じ着手を重ねて受け取る(ブラウザは手数で読み捨てる)
+
+*引数
+response Response: 着手のレスポンス
+err error        : 手番のプレイヤーのエラー(石は落ちていない)
+*/
+func (g *Game) notifyMove(response Response, err error) {
+  var ended bool = response.Result != 3;
+  if (ended) {
+    g.playing = false;
+    g.stopClock();
+  } else {
+    g.startClock();
+  }
+  g.publishInfo();
+
+  if (err == nil) { g.hub.broadcast(EVENT_MOVE, response); }
+  if (ended) { g.hub.broadcast(EVENT_END, response); }
+}
+
+/*
+#isHumanTurn
+手番のプレイヤーがブラウザ上の人間か判定する
+
+*返り値
+bool: 人間の手番か
+*/
+func (g *Game) isHumanTurn() bool {
+  _, ok := g.currentPlayer().(*HumanPlayer);
+  return ok;
+}
+
+/*
+#playersResponse
+プレイヤーの情報のレスポンスを生成する
+
+*返り値
+Response: プレイヤーの名称、人間か否か
+*/
+func (g *Game) playersResponse() Response {
+  var response Response = Response{ BlackName: g.BlackName, WhiteName: g.WhiteName };
+  _, response.BlackHuman = g.Black.(*HumanPlayer);
+  _, response.WhiteHuman = g.White.(*HumanPlayer);
+  response.BlackEngine = g.Black.Identity();
+  response.WhiteEngine = g.White.Identity();
+  return response;
+}
+
+/*
+#startClock
+手番の経過時間を1秒ごとにイベントで送り始める
+・前の手番の計時は止める
+*/
+func (g *Game) startClock() {
+  g.stopClock();
+
+  var stop chan struct{} = make(chan struct{});
+  g.clock_stop = stop;
+
+  // 送信のみを行い、盤面には触れない
+  var hub *eventHub = g.hub;
+  var clock ClockEvent = ClockEvent{ Black: g.Board.Counter%2 == 0 };
+  var started time.Time = time.Now();
+  g.turn_started = started;
+  go func() {
+    var ticker *time.Ticker = time.NewTicker(time.Second);
+    defer ticker.Stop();
+    for {
+      select {
+      case <-stop:
+        return;
+      case now := <-ticker.C:
+        clock.Elapsed = now.Sub(started).Milliseconds();
+        hub.broadcast(EVENT_CLOCK, clock);
+      }
+    }
+  }();
+}
+
+/*
+#stopClock
+手番の経過時間の送信を止める
+*/
+func (g *Game) stopClock() {
+  g.turn_started = time.Time{};
+  if (g.clock_stop != nil) {
+    close(g.clock_stop);
+    g.clock_stop = nil;
+  }
+}
+
+// 操作の権限がない(観戦者、他の席のブラウザ)
+var errForbidden error = errors.New("forbidden");
+
+/*
+#authorized
+操作用のトークンが一致するか判定する
+
+*引数
+token string: ブラウザが示したトークン
+
+*返り値
+bool: 対局を操作できるか
+*/
+func (g *Game) authorized(token string) bool {
+  return subtle.ConstantTimeCompare([]byte(token), []byte(g.control)) == 1;
+}
+
+/*
+#publishInfo
+一覧用の情報、局面、棋譜、計時の写しをまとめて入れ替え、途中から購読するブラウザに送る局面を更新する(対局を所有するgoroutineのみが呼ぶ)
+・局面を変えるイベント、計時の開始と停止は、これで更新してから送る
+*/
+func (g *Game) publishInfo() {
+  var snapshot gameSnapshot = gameSnapshot{
+    info: GameInfo{ BlackName: g.BlackName, WhiteName: g.WhiteName, Counter: g.Board.Counter },
+    state: g.gameState(),
+    record: g.Record,
+    turn_started: g.turn_started,
+  };
+  // 棋譜は所有するgoroutineが書き換えるため、指し手を写す
+  snapshot.record.Moves = append([]record.Move{}, g.Record.Moves...);
+  g.published.Store(&snapshot);
+  g.hub.snapshot(EVENT_STATE, snapshot.state);
+}
+
+/*
+#gameState
+現在の局面、着手の履歴、結果を求める
+
+*返り値
+GameState: 局面
+*/
+func (g *Game) gameState() GameState {
+  var state GameState = GameState{
+    BlackName: g.BlackName,
+    WhiteName: g.WhiteName,
+    BlackStones: g.Board.BlackStones,
+    WhiteStones: g.Board.WhiteStones,
+    Moves: []int{},
+    Counter: g.Board.Counter,
+    Playing: g.playing,
+    Assist: g.assist,
+    Result: 3,
+  };
+  _, state.BlackHuman = g.Black.(*HumanPlayer);
+  _, state.WhiteHuman = g.White.(*HumanPlayer);
+  state.BlackEngine = g.Black.Identity();
+  state.WhiteEngine = g.White.Identity();
+  for _, move := range g.Board.Moves {
+    state.Moves = append(state.Moves, int(move));
+  }
+  // 終局した対局は棋譜に結果が記録されている
+  if (!g.playing && g.Record.Termination != "") {
+    state.Result = g.Record.Result;
+    state.Termination = g.Record.Termination;
+  }
+  return state;
 }
 
 /*
 #startGameBrowser
 ゲームの開始
+・一方が開始に失敗した場合は、その負けとして終了する
 */
 func (g *Game) startGameBrowser(response *Response) {
-  g.initializeBoard();
-  var start bool = g.sendStartCommand();
-  response.Start = start;
+  black_err, white_err := g.sendStartCommand();
+  g.Record.Assisted = g.assist;
+  response.Start = black_err == nil && white_err == nil;
   response.BlackName = g.BlackName;
   response.WhiteName = g.WhiteName;
-  response.BlackPort = g.BlackPort;
-  response.WhitePort = g.WhitePort;
+  _, response.BlackHuman = g.Black.(*HumanPlayer);
+  _, response.WhiteHuman = g.White.(*HumanPlayer);
+  response.BlackEngine = g.Black.Identity();
+  response.WhiteEngine = g.White.Identity();
+
+  response.Result = 3;
+  if (black_err != nil && white_err != nil) {
+    printPlayerError(true, black_err);
+    printPlayerError(false, white_err);
+    response.Result = 255;
+    return;
+  }
+  var termination string;
+  if (black_err != nil) {
+    response.Result, termination = forfeit(true, black_err);
+  } else if (white_err != nil) {
+    response.Result, termination = forfeit(false, white_err);
+  } else {
+    return;
+  }
+  response.Termination = termination;
+  g.endGame(response.Result, termination);
+  g.saveRecord(response.Result, termination);
 }
 
 /*
 #dropStoneBrowser
 石を盤に落とす
+・手番のプレイヤーが通信、プロトコルに失敗した場合は、その負けとして終了する
 */
-func (g *Game) dropStoneBrowser(response *Response, next_move uint8, valid bool) {
+func (g *Game) dropStoneBrowser(response *Response, next_move uint8, valid bool, err error) {
+  if (err != nil) {
+    response.BlackStones = g.Board.BlackStones;
+    response.WhiteStones = g.Board.WhiteStones;
+    response.Board = g.Board.BlackStones | g.Board.WhiteStones;
+    response.Counter = g.Board.Counter;
+
+    response.Result, response.Termination = forfeit(g.Board.Counter%2 == 0, err);
+    g.endGame(response.Result, response.Termination);
+    g.saveRecord(response.Result, response.Termination);
+    return;
+  }
+
   response.BlackStones = g.Board.BlackStones;
   response.WhiteStones = g.Board.WhiteStones;
 
@@ -106,7 +1200,10 @@ func (g *Game) dropStoneBrowser(response *Response, next_move uint8, valid bool)
   if (!valid) {
     // 相手の勝ちとしてゲームを終了
     response.Result = g.Board.Counter%2;
-    g.endGame(g.Board.Counter%2);
+    response.Termination = record.TERMINATION_ILLEGAL;
+    g.endGame(response.Result, record.TERMINATION_ILLEGAL);
+    g.saveRecord(response.Result, record.TERMINATION_ILLEGAL);
+    return;
   }
 
   var stones uint64
@@ -119,6 +1216,17 @@ func (g *Game) dropStoneBrowser(response *Response, next_move uint8, valid bool)
   // いずれかが勝利した場合
   if (board.CheckAlignment(stones)) {
     response.Result = (g.Board.Counter+1) % 2;
-    g.endGame(response.Result);
+    response.Termination = record.TERMINATION_ALIGNMENT;
+    g.endGame(response.Result, record.TERMINATION_ALIGNMENT);
+    g.saveRecord(response.Result, record.TERMINATION_ALIGNMENT);
+    return;
+  }
+
+  // すべて埋まった場合は引き分け
+  if (g.Board.Counter == 42) {
+    response.Result = 2;
+    response.Termination = record.TERMINATION_FULL;
+    g.endGame(response.Result, record.TERMINATION_FULL);
+    g.saveRecord(response.Result, record.TERMINATION_FULL);
   }
 }
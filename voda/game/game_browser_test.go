@@ -0,0 +1,604 @@
+package game
+
+import "net"
+import "sync"
+import "time"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+
+import "voda/engine"
+import "voda/record"
+import "voda/protocol"
+
+/*
+#game_browser_test
+ブラウザでの対局の並行性の検証
+・go test -raceで実行し、対局を所有するgoroutineと他のgoroutineの競合がないこと
+*/
+
+// 対局の終了を待つ上限
+const TEST_TIMEOUT time.Duration = 10 * time.Second;
+
+/*
+#blockingEngine
+goに対して解放されるまで応答しないエンジン
+・受け取ったコマンドをcommandsに送る
+*/
+type blockingEngine struct {
+  release chan struct{}    // goへの応答の解放
+  commands chan string     // 受け取ったコマンド
+}
+
+/*
+#newBlockingEngine
+goに応答しないエンジンを生成
+
+*返り値
+*blockingEngine: エンジン
+*/
+func newBlockingEngine() *blockingEngine {
+  return &blockingEngine{ release: make(chan struct{}), commands: make(chan string, 16) };
+}
+
+/*
+#play
+プレイヤー関数(goは解放まで待ち、ランダムに応答する)
+
+*引数
+param PlayerParam: ゲームからのパラメータ
+
+*返り値
+PlayerRet: ゲームへの応答
+*/
+func (e *blockingEngine) play(param PlayerParam) PlayerRet {
+  e.commands <- param.Command;
+  if (param.Command == protocol.CMD_GO) { <-e.release; }
+  return engine.Random(param);
+}
+
+/*
+#waitCommand
+エンジンが指定のコマンドを受け取るまで待つ
+
+*引数
+t *testing.T  : テスト
+e *blockingEngine: エンジン
+command string: 待つコマンド
+*/
+func waitCommand(t *testing.T, e *blockingEngine, command string) {
+  t.Helper();
+  var timeout <-chan time.Time = time.After(TEST_TIMEOUT);
+  for {
+    select {
+    case got := <-e.commands:
+      if (got == command) { return; }
+    case <-timeout:
+      t.Fatalf("engine did not receive `%s`", command);
+    }
+  }
+}
+
+/*
+#waitResult
+対局が終局するまで待つ
+
+*引数
+t *testing.T: テスト
+g *Game     : 対局
+
+*返り値
+*GameState: 終局した局面
+*/
+func waitResult(t *testing.T, g *Game) *GameState {
+  t.Helper();
+  var deadline time.Time = time.Now().Add(TEST_TIMEOUT);
+  for time.Now().Before(deadline) {
+    var state *GameState = &g.published.Load().state;
+    if (!state.Playing && state.Result != 3) { return state; }
+    time.Sleep(5 * time.Millisecond);
+  }
+  t.Fatalf("game did not finish");
+  return nil;
+}
+
+/*
+#waitDone
+対局を所有するgoroutineの終了を待つ
+
+*引数
+t *testing.T: テスト
+g *Game     : 対局
+*/
+func waitDone(t *testing.T, g *Game) {
+  t.Helper();
+  select {
+  case <-g.done:
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("owner goroutine did not finish");
+  }
+}
+
+/*
+#TestConcurrentGames
+エンジン同士の対局を同時に進め、その間に一覧、局面、棋譜、操作を並行して求めても競合しないこと
+*/
+func TestConcurrentGames(t *testing.T) {
+  var s *Server = NewServer();
+  var created []CreatedGame;
+  for i := 0; i < 8; i++ {
+    c, err := s.CreateGameBySpec(PLAYER_ENGINE + "random", PLAYER_ENGINE + "g0F");
+    if (err != nil) { t.Fatalf("CreateGameBySpec: %v", err); }
+    created = append(created, c);
+  }
+
+  var wg sync.WaitGroup;
+  var stop chan struct{} = make(chan struct{});
+  // 観戦者として一覧、局面、棋譜を読み続ける
+  for i := 0; i < 4; i++ {
+    wg.Add(1);
+    go func() {
+      defer wg.Done();
+      for {
+        select {
+        case <-stop:
+          return;
+        default:
+        }
+        s.Games();
+        for _, c := range created {
+          g, _ := s.Game(c.ID);
+          g.Position(1);
+          g.apiGame(c.ID);
+          g.sendCommand(browserCommand{ Command: "assist", Token: c.Token, Assist: true });
+        }
+      }
+    }();
+  }
+
+  for _, c := range created {
+    wg.Add(1);
+    go func(c CreatedGame) {
+      defer wg.Done();
+      g, _ := s.Game(c.ID);
+      if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err != nil {
+        t.Errorf("start: %v", err);
+      }
+    }(c);
+  }
+
+  for _, c := range created {
+    g, _ := s.Game(c.ID);
+    var state *GameState = waitResult(t, g);
+    if (state.Termination != record.TERMINATION_ALIGNMENT && state.Termination != record.TERMINATION_FULL) {
+      t.Errorf("game %s: termination %q", c.ID, state.Termination);
+    }
+  }
+  close(stop);
+  wg.Wait();
+
+  for _, c := range created {
+    g, _ := s.Game(c.ID);
+    if err := s.RemoveGame(c.ID); err != nil { t.Fatalf("RemoveGame: %v", err); }
+    waitDone(t, g);
+  }
+}
+
+/*
+#TestAbortWhileThinking
+思考中のエンジンを待たずに中断でき、応答の後にエンジンへ終了が通知されること
+・応答を待つ間の開始は受け付けないこと
+*/
+func TestAbortWhileThinking(t *testing.T) {
+  var s *Server = NewServer();
+  var e *blockingEngine = newBlockingEngine();
+  c, err := s.CreateGame(NewFuncPlayer(e.play), NewHumanPlayer());
+  if (err != nil) { t.Fatalf("CreateGame: %v", err); }
+  g, _ := s.Game(c.ID);
+
+  if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err != nil {
+    t.Fatalf("start: %v", err);
+  }
+  waitCommand(t, e, protocol.CMD_GO);
+
+  // 思考中も操作を受け付ける
+  var replied chan error = make(chan error, 1);
+  go func() {
+    _, err := g.sendCommand(browserCommand{ Command: "abort", Token: c.Token });
+    replied <- err;
+  }();
+  select {
+  case err := <-replied:
+    if (err != nil) { t.Fatalf("abort: %v", err); }
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("abort blocked while the engine was thinking");
+  }
+  if (g.published.Load().state.Playing) { t.Errorf("game is still playing after abort"); }
+
+  if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err == nil {
+    t.Errorf("start while the engine is thinking: got nil, want error");
+  }
+
+  // 応答は捨てられ、終了が通知される
+  close(e.release);
+  waitCommand(t, e, protocol.CMD_END);
+  if (len(g.published.Load().state.Moves) != 0) { t.Errorf("move after abort was applied"); }
+
+  if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err != nil {
+    t.Errorf("start after the engine replied: %v", err);
+  }
+  s.RemoveGame(c.ID);
+  waitDone(t, g);
+}
+
+/*
+#TestQuitWhileThinking
+思考中のエンジンを待たずにquit、RemoveGameで対局を終了できること
+・quitで終了した対局はRemoveGameなしで一覧、上限から除かれること
+*/
+func TestQuitWhileThinking(t *testing.T) {
+  var s *Server = NewServer();
+  var engines []*blockingEngine;
+  var games []*Game;
+  var tokens []string;
+  var ids []string;
+  for i := 0; i < 2; i++ {
+    var e *blockingEngine = newBlockingEngine();
+    c, err := s.CreateGame(NewFuncPlayer(e.play), NewHumanPlayer());
+    if (err != nil) { t.Fatalf("CreateGame: %v", err); }
+    g, _ := s.Game(c.ID);
+    if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err != nil {
+      t.Fatalf("start: %v", err);
+    }
+    waitCommand(t, e, protocol.CMD_GO);
+    engines = append(engines, e);
+    games = append(games, g);
+    tokens = append(tokens, c.Token);
+    ids = append(ids, c.ID);
+  }
+
+  var quitted chan error = make(chan error, 1);
+  go func() {
+    _, err := games[0].sendCommand(browserCommand{ Command: "quit", Token: tokens[0] });
+    quitted <- err;
+  }();
+  select {
+  case err := <-quitted:
+    if (err != nil) { t.Fatalf("quit: %v", err); }
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("quit blocked while the engine was thinking");
+  }
+  waitDone(t, games[0]);
+
+  // quitした対局は一覧から除かれ、枠が空く
+  var deadline time.Time = time.Now().Add(TEST_TIMEOUT);
+  for len(s.Games()) != 1 && time.Now().Before(deadline) { time.Sleep(5 * time.Millisecond); }
+  if (len(s.Games()) != 1) { t.Fatalf("quit game still listed: %d games", len(s.Games())); }
+  if _, ok := s.Game(ids[0]); ok { t.Errorf("quit game `%s` still registered", ids[0]); }
+  s.MaxGames = 2;
+  if err := s.reserveGame(); err != nil {
+    t.Errorf("reserveGame after quit: %v", err);
+  } else {
+    s.releaseGame();
+  }
+
+  if err := s.RemoveGame(ids[1]); err != nil { t.Fatalf("RemoveGame: %v", err); }
+  waitDone(t, games[1]);
+
+  for _, e := range engines { close(e.release); }
+}
+
+/*
+#TestConcurrentCommands
+人間同士の対局に席の確保、補助、着手、中断を並行して送っても競合せず、着手が順に適用されること
+*/
+func TestConcurrentCommands(t *testing.T) {
+  var s *Server = NewServer();
+  c, err := s.CreateGameBySpec(PLAYER_HUMAN, PLAYER_HUMAN);
+  if (err != nil) { t.Fatalf("CreateGameBySpec: %v", err); }
+  g, _ := s.Game(c.ID);
+  if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err != nil {
+    t.Fatalf("start: %v", err);
+  }
+
+  var wg sync.WaitGroup;
+  for i := 0; i < 8; i++ {
+    wg.Add(1);
+    go func(i int) {
+      defer wg.Done();
+      g.sendCommand(browserCommand{ Command: "claim", Code: c.Code, Color: []string{ "black", "white" }[i%2] });
+      g.sendCommand(browserCommand{ Command: "assist", Token: c.Token, Assist: i%2 == 0 });
+      g.sendCommand(browserCommand{ Command: "drop", Token: c.Token, Col: uint8(i%7) });
+      g.Position(0);
+      s.Games();
+    }(i);
+  }
+  wg.Wait();
+
+  // 席を確保したブラウザ以外の着手は受け付けないため、手数は確保の結果による
+  var state *GameState = &g.published.Load().state;
+  if (int(state.Counter) != len(state.Moves)) {
+    t.Errorf("counter %d, moves %v", state.Counter, state.Moves);
+  }
+  g.sendCommand(browserCommand{ Command: "abort", Token: c.Token });
+  s.RemoveGame(c.ID);
+  waitDone(t, g);
+}
+
+/*
+#TestSocketPlayerCancel
+応答を待っているソケットのプレイヤーを打ち切ると、goが切断として戻り、Quitが終わること
+*/
+func TestSocketPlayerCancel(t *testing.T) {
+  game_conn, player_conn := net.Pipe();
+  var p *SocketPlayer = newSocketPlayer("test", time.Minute, "");
+  p.wg.Add(1);
+  go p.serve(newSocketConn(game_conn), nil);
+
+  // nameにのみ応答し、goには応答しないプレイヤー
+  go func() {
+    var reader *protocol.Reader = protocol.NewReader(player_conn);
+    var writer *protocol.Writer = protocol.NewWriter(player_conn);
+    for {
+      msg, err := reader.ReadMessage();
+      if (err != nil) { return; }
+      param, _ := protocol.Codec{}.DecodeParam(msg);
+      if (param.Command == protocol.CMD_NAME) {
+        ret, _ := protocol.Codec{}.EncodeRet(PlayerRet{ Command: protocol.CMD_SETNAME, Name: "test", Version: protocol.VERSION, Capabilities: []string{ protocol.CAP_SESSION } });
+        writer.WriteMessage(ret);
+      }
+    }
+  }();
+  if _, err := p.Name(); err != nil { t.Fatalf("Name: %v", err); }
+
+  var returned chan error = make(chan error, 1);
+  go func() {
+    _, err := p.Go(PlayerParam{ ValidMoves: []uint8{ 0 } });
+    returned <- err;
+  }();
+  time.Sleep(50 * time.Millisecond);
+  p.cancel();
+
+  select {
+  case err := <-returned:
+    if (terminationOf(err) != record.TERMINATION_DISCONNECT) { t.Errorf("Go after cancel: got %v, want disconnect", err); }
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("Go did not return after cancel");
+  }
+  p.Quit();
+  player_conn.Close();
+}
+
+/*
+#TestSocketPlayerQuitTwice
+Quitを繰り返し、並行して呼んでもブロックせず、終了後のコマンドは切断となること
+*/
+func TestSocketPlayerQuitTwice(t *testing.T) {
+  game_conn, player_conn := net.Pipe();
+  defer player_conn.Close();
+  var p *SocketPlayer = newSocketPlayer("test", 0, "");
+  p.wg.Add(1);
+  go p.serve(newSocketConn(game_conn), nil);
+  // quitを読み捨てる
+  go protocol.NewReader(player_conn).ReadMessage();
+
+  var finished chan struct{} = make(chan struct{});
+  go func() {
+    var wg sync.WaitGroup;
+    for i := 0; i < 3; i++ {
+      wg.Add(1);
+      go func() { defer wg.Done(); p.Quit(); }();
+    }
+    wg.Wait();
+    p.Quit();
+    if _, err := p.Go(PlayerParam{ ValidMoves: []uint8{ 0 } }); terminationOf(err) != record.TERMINATION_DISCONNECT {
+      t.Errorf("Go after Quit: got %v, want disconnect", err);
+    }
+    close(finished);
+  }();
+
+  select {
+  case <-finished:
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("Quit blocked");
+  }
+}
+
+/*
+#TestHintAuthorization
+ヒントは操作用のトークンか参加用のコードを示した場合のみ返し、同じ局面では探索し直さないこと
+*/
+func TestHintAuthorization(t *testing.T) {
+  var s *Server = NewServer();
+  c, err := s.CreateGameBySpec(PLAYER_HUMAN, PLAYER_HUMAN);
+  if (err != nil) { t.Fatalf("CreateGameBySpec: %v", err); }
+  g, _ := s.Game(c.ID);
+  g.sendCommand(browserCommand{ Command: "assist", Token: c.Token, Assist: true });
+  g.sendCommand(browserCommand{ Command: "start", Token: c.Token });
+
+  var cases []struct { query string; status int } = []struct { query string; status int }{
+    { "", http.StatusForbidden },
+    { "?token=wrong&code=wrong", http.StatusForbidden },
+    { "?token=" + c.Token, http.StatusOK },
+    { "?code=" + c.Code, http.StatusOK },
+  };
+  for _, tc := range cases {
+    var rec *httptest.ResponseRecorder = httptest.NewRecorder();
+    s.gameRouter(rec, httptest.NewRequest(http.MethodGet, "/games/" + c.ID + "/hint" + tc.query, nil));
+    if (rec.Code != tc.status) { t.Errorf("hint%s: got %d, want %d", tc.query, rec.Code, tc.status); }
+  }
+
+  var cached *hintCache = g.hint_cache;
+  if (cached == nil) { t.Fatalf("hint was not cached"); }
+  var rec *httptest.ResponseRecorder = httptest.NewRecorder();
+  s.gameRouter(rec, httptest.NewRequest(http.MethodGet, "/games/" + c.ID + "/hint?code=" + c.Code, nil));
+  if (g.hint_cache != cached) { t.Errorf("hint was searched again for the same position"); }
+
+  s.RemoveGame(c.ID);
+  waitDone(t, g);
+}
+
+/*
+#TestGameLimit
+上限を超える対局の生成は503とし、対局を取り除けば再び生成できること
+*/
+func TestGameLimit(t *testing.T) {
+  var s *Server = NewServer();
+  s.MaxGames = 2;
+  var body string = `{"Black":"human","White":"human"}`;
+  post := func(handler http.HandlerFunc, target string) int {
+    var r *http.Request = httptest.NewRequest(http.MethodPost, target, strings.NewReader(body));
+    r.RemoteAddr = "127.0.0.1:1234"; // 生成はローカルホストから
+    var rec *httptest.ResponseRecorder = httptest.NewRecorder();
+    handler(rec, r);
+    return rec.Code;
+  };
+
+  var cases []struct { handler http.HandlerFunc; target string; status int } = []struct { handler http.HandlerFunc; target string; status int }{
+    { s.gamesHandler, "/games", http.StatusCreated },
+    { s.apiGamesHandler, "/api/games", http.StatusCreated },
+    { s.gamesHandler, "/games", http.StatusServiceUnavailable },
+    { s.apiGamesHandler, "/api/games", http.StatusServiceUnavailable },
+  };
+  for _, tc := range cases {
+    if got := post(tc.handler, tc.target); got != tc.status {
+      t.Errorf("POST %s: got %d, want %d", tc.target, got, tc.status);
+    }
+  }
+
+  // 取り除いた対局は数えない
+  var ids []string = s.gameIDs();
+  g, _ := s.Game(ids[0]);
+  s.RemoveGame(ids[0]);
+  waitDone(t, g);
+  if got := post(s.apiGamesHandler, "/api/games"); got != http.StatusCreated {
+    t.Errorf("POST after RemoveGame: got %d, want %d", got, http.StatusCreated);
+  }
+
+  for _, id := range s.gameIDs() {
+    g, _ := s.Game(id);
+    s.RemoveGame(id);
+    waitDone(t, g);
+  }
+}
+
+/*
+#TestCreateRestrictions
+対局の生成はローカルホストか生成用のトークンを示した場合のみ受け付けること
+*/
+func TestCreateRestrictions(t *testing.T) {
+  var s *Server = NewServer();
+  var body string = `{"Black":"human","White":"human"}`;
+  post := func(handler http.HandlerFunc, target string, remote string) int {
+    var r *http.Request = httptest.NewRequest(http.MethodPost, target, strings.NewReader(body));
+    r.RemoteAddr = remote;
+    var rec *httptest.ResponseRecorder = httptest.NewRecorder();
+    handler(rec, r);
+    return rec.Code;
+  };
+
+  var cases []struct { handler http.HandlerFunc; target string; remote string; status int } = []struct { handler http.HandlerFunc; target string; remote string; status int }{
+    { s.gamesHandler, "/games", "192.0.2.1:1234", http.StatusForbidden },
+    { s.apiGamesHandler, "/api/games", "192.0.2.1:1234", http.StatusForbidden },
+    { s.gamesHandler, "/games", "127.0.0.1:1234", http.StatusCreated },
+    { s.apiGamesHandler, "/api/games", "[::1]:1234", http.StatusCreated },
+  };
+  for _, tc := range cases {
+    if got := post(tc.handler, tc.target, tc.remote); got != tc.status {
+      t.Errorf("POST %s from %s: got %d, want %d", tc.target, tc.remote, got, tc.status);
+    }
+  }
+
+  // 生成用のトークンを設定した場合はローカルホストでもトークンを要する
+  s.CreateToken = "secret";
+  if got := post(s.gamesHandler, "/games", "127.0.0.1:1234"); got != http.StatusForbidden {
+    t.Errorf("POST without token: got %d, want %d", got, http.StatusForbidden);
+  }
+  if got := post(s.apiGamesHandler, "/api/games?create=secret", "192.0.2.1:1234"); got != http.StatusCreated {
+    t.Errorf("POST with token: got %d, want %d", got, http.StatusCreated);
+  }
+
+  for _, id := range s.gameIDs() {
+    g, _ := s.Game(id);
+    s.RemoveGame(id);
+    waitDone(t, g);
+  }
+}
+
+/*
+#TestRejectedCommands
+対局中のstart、置けない列へのdropは受け付けず、対局は続くこと
+*/
+func TestRejectedCommands(t *testing.T) {
+  var s *Server = NewServer();
+  c, err := s.CreateGameBySpec(PLAYER_HUMAN, PLAYER_HUMAN);
+  if (err != nil) { t.Fatalf("CreateGameBySpec: %v", err); }
+  g, _ := s.Game(c.ID);
+  if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err != nil {
+    t.Fatalf("start: %v", err);
+  }
+  if _, err := g.sendCommand(browserCommand{ Command: "drop", Token: c.Token, Col: 3 }); err != nil {
+    t.Fatalf("drop: %v", err);
+  }
+  if _, err := g.sendCommand(browserCommand{ Command: "start", Token: c.Token }); err == nil {
+    t.Errorf("start during a game: got nil, want error");
+  }
+
+  // 3列目を埋める
+  for i := 0; i < 5; i++ {
+    if _, err := g.sendCommand(browserCommand{ Command: "drop", Token: c.Token, Col: 3 }); err != nil {
+      t.Fatalf("drop %d: %v", i, err);
+    }
+  }
+  for _, col := range []uint8{ 3, 7, 255 } {
+    if _, err := g.sendCommand(browserCommand{ Command: "drop", Token: c.Token, Col: col }); err == nil {
+      t.Errorf("drop into column %d: got nil, want error", col);
+    }
+  }
+
+  var state *GameState = &g.published.Load().state;
+  if (!state.Playing || state.Counter != 6 || state.Result != 3) {
+    t.Errorf("after rejected commands: playing %v, counter %d, result %d", state.Playing, state.Counter, state.Result);
+  }
+  g.sendCommand(browserCommand{ Command: "abort", Token: c.Token });
+  s.RemoveGame(c.ID);
+  waitDone(t, g);
+}
+
+/*
+#TestAPIClock
+APIの消費時間は先後それぞれの合計で、対局中は手番の経過時間を含むこと
+*/
+func TestAPIClock(t *testing.T) {
+  var s *Server = NewServer();
+  c, err := s.CreateGameBySpec(PLAYER_HUMAN, PLAYER_HUMAN);
+  if (err != nil) { t.Fatalf("CreateGameBySpec: %v", err); }
+  g, _ := s.Game(c.ID);
+  if (g.apiGame(c.ID).Clock != nil) { t.Errorf("clock before start: got %+v, want nil", g.apiGame(c.ID).Clock); }
+
+  g.sendCommand(browserCommand{ Command: "start", Token: c.Token });
+  for _, col := range []uint8{ 3, 4 } {
+    time.Sleep(30 * time.Millisecond);
+    if _, err := g.sendCommand(browserCommand{ Command: "drop", Token: c.Token, Col: col }); err != nil {
+      t.Fatalf("drop: %v", err);
+    }
+  }
+  time.Sleep(10 * time.Millisecond);
+
+  var game APIGame = g.apiGame(c.ID);
+  if (game.Clock == nil || game.Clock.Side != "black" || game.Clock.ElapsedMs < 10) {
+    t.Fatalf("clock while playing: got %+v", game.Clock);
+  }
+  if (game.Clock.BlackMs < 30 + game.Clock.ElapsedMs || game.Clock.WhiteMs < 30) {
+    t.Errorf("clock totals: got %+v", game.Clock);
+  }
+
+  g.sendCommand(browserCommand{ Command: "abort", Token: c.Token });
+  game = g.apiGame(c.ID);
+  if (game.Clock == nil || game.Clock.Side != "" || game.Clock.ElapsedMs != 0 || game.Clock.WhiteMs < 30) {
+    t.Errorf("clock after abort: got %+v", game.Clock);
+  }
+  s.RemoveGame(c.ID);
+  waitDone(t, g);
+}
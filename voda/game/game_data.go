@@ -1,25 +1,71 @@
 package game
 
+import "time"
 import "sync"
+import "sync/atomic"
+
+import "voda/engine"
+import "voda/record"
+import "voda/protocol"
 
 // ゲームの情報
 // 盤面、プレイヤーを保持
 type Game struct {
   Board BoardData         // 盤面情報
 
-  BlackPort uint // 先手のポート
-  WhitePort uint // 後手のポート
+  Black Player // 先手のプレイヤー
+  White Player // 後手のプレイヤー
 
-  BlackParamChannel chan PlayerParam  // 先手パラメータ送信用チャネル
-  WhiteParamChannel chan PlayerParam  // 後手パラメータ送信用チャネル
+  BlackName string        // 先手プレイヤー名
+  WhiteName string        // 後手プレイヤー名
 
-  BlackRetChannel chan PlayerRet  // 先手返り値受信用チャネル
-  WhiteRetChannel chan PlayerRet  // 後手返り値受信用チャネル
+  Record record.Record    // 対局中の棋譜
+  RecordPath string       // 棋譜の保存先(空の場合は保存しない)
+  ArchivePath string      // 保管庫(空の場合は保存しない)
+
+  // ブラウザでの対局(game_browser.go)
+  // 盤面、プレイヤーは対局を所有するgoroutineのみが操作する
+  control string                // 操作用のトークン(生成後は変更しない)
+  join string                   // 参加用のコード(生成後は変更しない)
+  seats [2]string               // 確保された席のトークン(先手、後手、空は未確保)
+  commands chan browserCommand  // ブラウザからの操作
+  done chan struct{}            // 所有するgoroutineの終了
+  published atomic.Pointer[gameSnapshot] // 公開した対局の写し(所有するgoroutineが入れ替える)
+  turn_started time.Time        // 手番の開始時刻(対局中でなければゼロ値)
+  hub *eventHub                 // ブラウザへのイベントの配信
+  playing bool                  // 対局中か
+  assist bool                   // 補助(ヒント、脅威の表示)を有効にしているか
+  clock_stop chan struct{}      // 手番の計時の停止
+  removed chan struct{}         // 対局の取り除き(RemoveGame、quitと同様に終了する)
+  thinking chan thought         // 思考中のエンジンの結果(思考中でなければnil)
+  thinker Player                // 思考中のエンジン
+  thinking_black bool           // 思考中のエンジンが先手か
+  abandoned bool                // 中断した対局の思考を待っているか(結果は捨てる)
+  hint_mu sync.Mutex            // ヒントの探索の排他(hintHandlerが用いる)
+  hint_cache *hintCache         // 直前に求めたヒント(hint_muで保護)
+}
 
-  WaitGroup *sync.WaitGroup
+// 対局を所有するgoroutineが公開する対局の写し
+// 一覧用の情報、局面、棋譜、計時を1つにまとめて入れ替え、食い違った組み合わせを読ませない
+type gameSnapshot struct {
+  info GameInfo         // 一覧用の情報
+  state GameState       // 局面と着手の履歴
+  record record.Record  // 棋譜(指し手は写したもの)
+  turn_started time.Time // 手番の開始時刻(対局中でなければゼロ値)
+}
 
-  BlackName string        // 先手プレイヤー名
-  WhiteName string        // 後手プレイヤー名
+// 局面ごとに求めたヒント
+type hintCache struct {
+  black uint64 // 先手の石
+  white uint64 // 後手の石
+  hint Hint    // ヒント
+}
+
+// エンジンの思考の結果(goの応答)
+type thought struct {
+  ret PlayerRet          // プレイヤーの応答
+  err error              // 通信、プロトコルの失敗
+  elapsed time.Duration  // 消費時間
 }
 
 // コネクトフォーのゲーム情報を保持
@@ -30,29 +76,109 @@ type BoardData struct {
   Counter uint8       // 手数
 }
 
-// プレイヤーに与える引数
-type PlayerParam struct {
+// プレイヤーに与える引数(voda/protocolを参照)
+type PlayerParam = protocol.Param
+
+// プレイヤーからの返り値(voda/protocolを参照)
+type PlayerRet = protocol.Ret
+
+// ブラウザからの操作
+type browserCommand struct {
   Command string
+  Col uint8
+  Token string // 操作用のトークン
+  Seat string  // 席のトークン
+  Color string // 確保する席(black, white)
+  Code string  // 参加用のコード
+  Assist bool  // 補助を有効にするか(assist)
+  reply chan browserReply // 処理結果の返信先
+}
+
+// ブラウザからの操作の処理結果
+type browserReply struct {
+  response Response
+  err error // 操作を受け付けられない場合のエラー
+}
 
-  Turn bool // 先後
+// 途中から購読したブラウザに送る局面
+type GameState struct {
+  BlackName string // 先手の名称
+  WhiteName string // 後手の名称
+  BlackHuman bool  // 先手がブラウザ上の人間か
+  WhiteHuman bool  // 後手がブラウザ上の人間か
+  BlackEngine string // 先手の種類と識別(Player.Identity)
+  WhiteEngine string // 後手の種類と識別(Player.Identity)
+
+  BlackStones uint64 // 先手の石
+  WhiteStones uint64 // 後手の石
+  Moves []int        // 着手の履歴(列)
+  Counter uint8      // 手数
+  Playing bool       // 対局中か
+  Assist bool        // 補助(ヒント、脅威の表示)が有効か
+  Result uint8       // 結果(対局中、開始前は3)
+  Termination string // 終局理由
+}
 
-  Stones uint64      // 石の配置
-  OppStones uint64   // 相手方の石の配置
-  Moves []uint8      // 操作履歴
-  ValidMoves []uint8 // 合法手リスト
+// 着手の履歴中の局面(/games/{id}/position)
+type Position struct {
+  Ply int            // 手数(0は初期局面)
+  Total int          // 履歴の手数
+  BlackStones uint64 // 先手の石
+  WhiteStones uint64 // 後手の石
+  Moves []int        // その局面までの着手(列)
+  LastMove int       // 直前の着手(初期局面は-1)
+}
 
-  Result uint8// 結果(0:win, 1:lose, 2:draw)
+// 解析モードの局面(POST /analysis)
+type AnalysisRequest struct {
+  BlackStones uint64 // 先手の石
+  WhiteStones uint64 // 後手の石
+  Depth int          // 探索の深さ(0の場合は既定の深さ)
 }
 
-// プレイヤーからの返り値
-type PlayerRet struct {
-  Command string 
+// 解析モードの結果
+type Analysis struct {
+  ToMove string                     // 手番(black, white)
+  Depth int                         // 探索の深さ
+  Best int                          // 最善の列
+  Columns []engine.ColumnAnalysis   // 列ごとの評価値、読み筋、決着までの手数
+}
 
-  Name string // プレイヤー名
+// 補助の切り替えのイベント
+type AssistEvent struct {
+  Enabled bool // 補助(ヒント、脅威の表示)が有効か
+}
 
-  Ready bool //開始の確認
+// 補助を有効にした対局のヒント(/games/{id}/hint)
+type Hint struct {
+  ToMove string       // 手番(black, white)
+  Col int             // 推奨する列
+  Score int           // 評価値(手番の側から見た値)
+  BlackThreats uint64 // 先手が置けば4つ揃うマス
+  WhiteThreats uint64 // 後手が置けば4つ揃うマス
+}
+
+// エンジンの思考の状況のイベント(プレイヤーのinfo)
+type InfoEvent struct {
+  Black bool        // 先手のエンジンか
+  Ply int           // 思考中の手数(1から)
+  Depth int         // 探索の深さ(0は報告なし)
+  Score *int        // 評価値(手番の側から見た値、nullは報告なし)
+  Nodes int64       // 探索した局面数(0は報告なし)
+  PV []int          // 読み筋
+  Rates []float64   // 列ごとの勝率(0~1、負の値は未評価・置けない列)
+}
 
-  Move uint8 // 操作
+// 席の確保の状況のイベント
+type SeatsEvent struct {
+  Black bool // 先手の席が確保されているか
+  White bool // 後手の席が確保されているか
+}
+
+// 手番の経過時間のイベント
+type ClockEvent struct {
+  Black bool    // 先手の手番か
+  Elapsed int64 // 手番の経過時間(ミリ秒)
 }
 
 // クライアントへのレスポンス
@@ -62,8 +188,10 @@ type Response struct {
 
   BlackName string // 先手の名称
   WhiteName string // 後手の名称
-  BlackPort uint// 先手の名称
-  WhitePort uint// 後手の名称
+  BlackHuman bool // 先手がブラウザ上の人間か
+  WhiteHuman bool // 後手がブラウザ上の人間か
+  BlackEngine string // 先手の種類と識別(Player.Identity)
+  WhiteEngine string // 後手の種類と識別(Player.Identity)
 
   BlackStones uint64  // 先手の石
   WhiteStones uint64  // 後手の石
@@ -71,7 +199,10 @@ type Response struct {
   Counter uint8       // 手数
   Pos uint8
   Result uint8
+  Termination string // 終局理由(対局中は空文字列)
 
   NextMove uint8
   Valid bool
+
+  Seat string // 確保した席のトークン(claim)
 }
@@ -0,0 +1,391 @@
+package game
+
+import "net"
+import "fmt"
+import "sync"
+import "time"
+
+import "voda/protocol"
+
+/*
+#Lobby
+1つのポートで複数のプレイヤーの接続を受け付け、対局の組み合わせを待つプレイヤーを管理する
+・接続したプレイヤーはname/setnameで名前、トークン、希望する対局を伝える
+・Pairで希望が合うプレイヤーを2人ずつ取り出す
+・対局を終えたプレイヤー(Quit)は接続したままロビーに戻る
+・トークンを付けて接続したプレイヤーは、切断されたプレイヤーの再接続として扱う
+・待機中に切断され、再接続しなかったプレイヤーは組み合わせる前に取り除く
+*/
+type Lobby struct {
+  ln net.Listener     // 待ち受けのリスナ
+  port uint           // 待ち受けのポート番号
+  grace time.Duration // 切断後、再接続を待つ猶予時間
+
+  mu sync.Mutex
+  cond *sync.Cond                     // 待機中のプレイヤーの増加、ロビーの終了の通知
+  waiting []*LobbyPlayer              // 組み合わせを待つプレイヤー(接続順)
+  sessions map[string]*LobbyPlayer    // 接続中のプレイヤー(セッショントークン毎)
+  closed bool                         // ロビーを終了したか
+}
+
+/*
+#LobbyPlayer
+ロビーを介して接続したプレイヤー
+・名前はロビーが受け付けた時点で確定している
+・Quitでは通信を終了せず、ロビーに戻る
+*/
+type LobbyPlayer struct {
+  *SocketPlayer
+  lobby *Lobby
+  name string // プレイヤー名
+  game string // 希望する対局(anyはいずれでもよい)
+}
+
+// いずれの対局でもよい
+const LOBBY_ANY_GAME string = "any";
+
+// 接続直後のname/setnameを待つ時間
+const LOBBY_HANDSHAKE_TIMEOUT time.Duration = 10 * time.Second;
+
+/*
+#NewLobby
+ロビーを生成し、接続の待ち受けを開始する
+
+*引数
+port uint          : 待ち受けのポート番号
+grace time.Duration: 切断後、再接続を待つ猶予時間(0は再接続を待たない)
+
+*返り値
+*Lobby: ロビー
+error : 待ち受けを開始できない場合のエラー
+*/
+func NewLobby(port uint, grace time.Duration) (*Lobby, error) {
+  ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port));
+  if (err != nil) { return nil, err; }
+
+  var l *Lobby = &Lobby{
+    ln: ln,
+    port: port,
+    grace: grace,
+    sessions: map[string]*LobbyPlayer{},
+  };
+  l.cond = sync.NewCond(&l.mu);
+
+  go l.acceptPlayers();
+  return l, nil;
+}
+
+/*
+#acceptPlayers
+ロビーが終了するまで接続を受け付ける
+・接続ごとに並行してname/setnameをやり取りする
+*/
+func (l *Lobby) acceptPlayers() {
+  for {
+    conn, err := l.ln.Accept();
+    if (err != nil) {
+      l.mu.Lock();
+      var closed bool = l.closed;
+      l.mu.Unlock();
+      if (closed) { return; }
+      fmt.Println(fmt.Sprintf("lobby(%d): %s", l.port, err));
+      continue;
+    }
+    go l.identify(conn);
+  }
+}
+
+/*
+#identify
+接続したプレイヤーの名前、トークン、希望する対局を確認する
+・トークンが接続中のプレイヤーと一致する場合は再接続として引き渡す
+・それ以外は新たなプレイヤーとして組み合わせを待たせる
+
+*引数
+conn net.Conn: 接続
+*/
+func (l *Lobby) identify(conn net.Conn) {
+  session, err := newSessionToken();
+  if (err != nil) { conn.Close(); return; }
+
+  var sc *socketConn = newSocketConn(conn);
+  var param PlayerParam = nameParam();
+  param.Session = session;
+
+  // 応答しない接続はいつまでも待たない
+  conn.SetDeadline(time.Now().Add(LOBBY_HANDSHAKE_TIMEOUT));
+  var label string = fmt.Sprintf("%d:%s", l.port, conn.RemoteAddr());
+  ret, err := exchangeMessage(param, sc.reader, sc.writer, protocol.Codec{}, label);
+  conn.SetDeadline(time.Time{});
+  if (err != nil) {
+    fmt.Println(fmt.Sprintf("lobby(%d): %s", l.port, err));
+    conn.Close();
+    return;
+  }
+
+  // 再接続
+  if (ret.Session != "") {
+    l.resume(ret.Session, sc);
+    return;
+  }
+
+  var game string = ret.Game;
+  if (game == "") { game = LOBBY_ANY_GAME; }
+
+  var p *LobbyPlayer = &LobbyPlayer{
+    SocketPlayer: newSocketPlayer(fmt.Sprintf("%d:%s", l.port, ret.Name), l.grace, session),
+    lobby: l,
+    name: ret.Name,
+    game: game,
+  };
+  p.negotiate(ret);
+  p.wg.Add(1);
+  go p.serve(sc, nil);
+
+  l.mu.Lock();
+  defer l.mu.Unlock();
+  if (l.closed) {
+    go p.SocketPlayer.Quit();
+    return;
+  }
+  l.sessions[session] = p;
+  l.waiting = append(l.waiting, p);
+  fmt.Println(fmt.Sprintf("lobby(%d): %s joined (game: %s)", l.port, p.name, p.game));
+  l.cond.Broadcast();
+  go l.watch(p);
+}
+
+/*
+#watch
+プレイヤーの通信の失敗を待ち、待機中であればロビーから取り除いて終了させる
+・対局中に失敗した場合は、対局を終えてロビーに戻る時(release)に取り除く
+
+*引数
+p *LobbyPlayer: プレイヤー
+*/
+func (l *Lobby) watch(p *LobbyPlayer) {
+  select {
+  case <-p.failed:
+  case <-p.done:
+    return;
+  }
+
+  l.mu.Lock();
+  var waiting bool = l.removeWaiting(p);
+  if (waiting) { delete(l.sessions, p.session); }
+  l.mu.Unlock();
+  if (waiting) {
+    fmt.Println(fmt.Sprintf("lobby(%d): %s left", l.port, p.name));
+    p.SocketPlayer.Quit();
+  }
+}
+
+/*
+#resume
+再接続をトークンが一致するプレイヤーに引き渡す
+・一致するプレイヤーがいない、LOBBY_HANDSHAKE_TIMEOUTの間に再接続を待たなかった場合は切断する
+
+*引数
+session string: 提示されたトークン
+sc *socketConn: 再接続
+*/
+func (l *Lobby) resume(session string, sc *socketConn) {
+  l.mu.Lock();
+  var p *LobbyPlayer = l.sessions[session];
+  l.mu.Unlock();
+
+  if (p != nil) {
+    // 切断の検知を待つ(受け取られない接続を残さない)
+    select {
+    case p.resumed <- sc:
+      return;
+    case <-p.done:
+    case <-time.After(LOBBY_HANDSHAKE_TIMEOUT):
+    }
+  }
+  fmt.Println(fmt.Sprintf("lobby(%d): session rejected", l.port));
+  sc.conn.Close();
+}
+
+/*
+#Pair
+希望が合う2人のプレイヤーが揃うまで待ち、組み合わせる
+・接続順に、対局名またはanyを希望するプレイヤーを選ぶ
+
+*引数
+game string: 対局名
+
+*返り値
+*LobbyPlayer: 先手のプレイヤー
+*LobbyPlayer: 後手のプレイヤー
+error       : ロビーが終了した場合のエラー
+*/
+func (l *Lobby) Pair(game string) (*LobbyPlayer, *LobbyPlayer, error) {
+  l.mu.Lock();
+  defer l.mu.Unlock();
+
+  for {
+    if (l.closed) { return nil, nil, fmt.Errorf("lobby closed"); }
+
+    if (l.countWaiting(game) >= 2) {
+      return l.takeWaiting(game), l.takeWaiting(game), nil;
+    }
+
+    l.cond.Wait();
+  }
+}
+
+/*
+#Take
+希望が合う待機中のプレイヤーを1人取り出す
+・待機中のプレイヤーがいなければ待たずに戻る
+
+*引数
+game string: 対局名
+
+*返り値
+*LobbyPlayer: プレイヤー
+bool        : 取り出せたか
+*/
+func (l *Lobby) Take(game string) (*LobbyPlayer, bool) {
+  l.mu.Lock();
+  defer l.mu.Unlock();
+
+  if (l.closed || l.countWaiting(game) == 0) { return nil, false; }
+  return l.takeWaiting(game), true;
+}
+
+/*
+#countWaiting
+希望が合う待機中のプレイヤーを数える(要ロック)
+
+*引数
+game string: 対局名
+
+*返り値
+int: 人数
+*/
+func (l *Lobby) countWaiting(game string) int {
+  var count int = 0;
+  for _, p := range l.waiting {
+    if (p.accepts(game)) { count++; }
+  }
+  return count;
+}
+
+/*
+#takeWaiting
+希望が合う最も早く接続したプレイヤーを取り出す(要ロック)
+
+*引数
+game string: 対局名
+
+*返り値
+*LobbyPlayer: プレイヤー(いなければnil)
+*/
+func (l *Lobby) takeWaiting(game string) *LobbyPlayer {
+  for i, p := range l.waiting {
+    if (p.accepts(game)) {
+      l.waiting = append(l.waiting[:i], l.waiting[i+1:]...);
+      return p;
+    }
+  }
+  return nil;
+}
+
+/*
+#removeWaiting
+プレイヤーを待機中のプレイヤーから取り除く(要ロック)
+
+*引数
+p *LobbyPlayer: プレイヤー
+
+*返り値
+bool: 待機中だったか
+*/
+func (l *Lobby) removeWaiting(p *LobbyPlayer) bool {
+  for i, w := range l.waiting {
+    if (w == p) {
+      l.waiting = append(l.waiting[:i], l.waiting[i+1:]...);
+      return true;
+    }
+  }
+  return false;
+}
+
+/*
+#release
+対局を終えたプレイヤーをロビーに戻す
+・通信に失敗したプレイヤーは終了させる
+・失敗の判定はロックの中で行い、watchと取り除く側が重ならないようにする
+
+*引数
+p *LobbyPlayer: プレイヤー
+*/
+func (l *Lobby) release(p *LobbyPlayer) {
+  l.mu.Lock();
+  select {
+  case <-p.failed:
+    delete(l.sessions, p.session);
+    l.mu.Unlock();
+    p.SocketPlayer.Quit();
+    return;
+  default:
+  }
+  defer l.mu.Unlock();
+  if (l.closed) {
+    go p.SocketPlayer.Quit();
+    return;
+  }
+  l.waiting = append(l.waiting, p);
+  l.cond.Broadcast();
+}
+
+/*
+#Close
+ロビーを終了し、待機中のプレイヤーを終了させる
+・対局中のプレイヤーは対局を終えてロビーに戻る時に終了させる
+*/
+func (l *Lobby) Close() {
+  l.mu.Lock();
+  l.closed = true;
+  var waiting []*LobbyPlayer = l.waiting;
+  l.waiting = nil;
+  l.cond.Broadcast();
+  l.mu.Unlock();
+
+  l.ln.Close();
+  for _, p := range waiting {
+    p.SocketPlayer.Quit();
+  }
+}
+
+/*
+#accepts
+対局名が希望に合うか判定する
+
+*引数
+game string: 対局名
+
+*返り値
+bool: 希望に合うか
+*/
+func (p *LobbyPlayer) accepts(game string) bool {
+  return p.game == LOBBY_ANY_GAME || p.game == game;
+}
+
+func (p *LobbyPlayer) Name() (string, error) {
+  return p.name, nil;
+}
+
+func (p *LobbyPlayer) Identity() string {
+  return PLAYER_LOBBY + ":" + p.name;
+}
+
+/*
+#Quit
+通信を終了せず、ロビーに戻る
+*/
+func (p *LobbyPlayer) Quit() {
+  p.lobby.release(p);
+}
@@ -0,0 +1,82 @@
+package game
+
+import "net"
+import "time"
+import "bufio"
+import "testing"
+
+/*
+#lobby_test
+ロビーの検証
+・待機中に切断されたプレイヤーが組み合わせる前に取り除かれること
+*/
+
+/*
+#joinLobby
+ロビーに接続し、name/setnameをやり取りする
+
+*引数
+t *testing.T: テスト
+l *Lobby    : ロビー
+name string : プレイヤー名
+
+*返り値
+net.Conn: 接続
+*/
+func joinLobby(t *testing.T, l *Lobby, name string) net.Conn {
+  t.Helper();
+  conn, err := net.Dial("tcp", l.ln.Addr().String());
+  if (err != nil) { t.Fatalf("Dial: %v", err); }
+  if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil { t.Fatalf("read name: %v", err); }
+  if _, err := conn.Write([]byte("setname " + name + "\n")); err != nil { t.Fatalf("write setname: %v", err); }
+  return conn;
+}
+
+/*
+#waitWaiting
+待機中のプレイヤーが指定の人数になるまで待つ
+
+*引数
+t *testing.T: テスト
+l *Lobby    : ロビー
+count int   : 人数
+*/
+func waitWaiting(t *testing.T, l *Lobby, count int) {
+  t.Helper();
+  var deadline time.Time = time.Now().Add(TEST_TIMEOUT);
+  for {
+    l.mu.Lock();
+    var waiting int = len(l.waiting);
+    l.mu.Unlock();
+    if (waiting == count) { return; }
+    if (time.Now().After(deadline)) { t.Fatalf("waiting players: got %d, want %d", waiting, count); }
+    time.Sleep(10 * time.Millisecond);
+  }
+}
+
+/*
+#TestLobbyDropsDeadPlayer
+待機中に切断したプレイヤーは取り除かれ、接続中のプレイヤーのみ取り出せること
+*/
+func TestLobbyDropsDeadPlayer(t *testing.T) {
+  l, err := NewLobby(0, 0);
+  if (err != nil) { t.Fatalf("NewLobby: %v", err); }
+  defer l.Close();
+
+  var dead net.Conn = joinLobby(t, l, "dead");
+  waitWaiting(t, l, 1);
+  var alive net.Conn = joinLobby(t, l, "alive");
+  defer alive.Close();
+  waitWaiting(t, l, 2);
+
+  dead.Close();
+  waitWaiting(t, l, 1);
+  l.mu.Lock();
+  var sessions int = len(l.sessions);
+  l.mu.Unlock();
+  if (sessions != 1) { t.Errorf("sessions: got %d, want 1", sessions); }
+
+  p, ok := l.Take(LOBBY_ANY_GAME);
+  if (!ok || p.name != "alive") { t.Fatalf("Take: got %v, %v, want alive", p, ok); }
+  p.SocketPlayer.Quit();
+}
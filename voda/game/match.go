@@ -0,0 +1,185 @@
+package game
+
+import "fmt"
+
+import "voda/record"
+
+/*
+#match
+エンジン同士の連続対局
+・プレイヤーとの接続は対局間で維持する
+・2局を1ペアとし、ペアの中で先後を入れ替える
+・プレイヤー1を新エンジンとして集計する
+・開局集が与えられた場合、各開局をペアの2局で用いる
+・SPRTを有効にした場合、判定が出た時点で終了する
+・ペンタノミアル(SPRTの統計)はペアの揃った対局のみを数える(奇数局目で終わった場合、最後の1局は勝敗のみに数える)
+*/
+
+// 連続対局の設定
+type MatchConfig struct {
+  Games uint // 対局数(SPRT有効時は上限、0で無制限、SPRT有効時は偶数)
+
+  UseSPRT bool // SPRTによる打ち切りを行うか
+  SPRT SPRT    // SPRTの設定
+
+  Openings [][]uint8 // 開局集(空の場合は初期盤面から)
+
+  ShowBoard bool  // 盤面の出力
+  ShowResult bool // 各局の結果の出力
+}
+
+// 連続対局の結果(プレイヤー1から見た値)
+type MatchResult struct {
+  Wins uint   // 勝ち数
+  Losses uint // 負け数
+  Draws uint  // 引き分け数
+
+  SPRT SPRT    // SPRTの統計
+  Status uint8 // SPRTの判定
+  Aborted bool // 異常終了したか
+}
+
+/*
+#StartMatch
+2つのプレイヤーを接続し、連続対局を行う
+
+*引数
+player1 Player: プレイヤー1(新エンジン)
+player2 Player: プレイヤー2
+config MatchConfig: 連続対局の設定
+
+*返り値
+MatchResult: 連続対局の結果
+*/
+func (g *Game) StartMatch(player1 Player, player2 Player, config MatchConfig) MatchResult {
+  var match MatchResult;
+  match.SPRT = config.SPRT;
+
+  // プレイヤー1を先手として接続
+  g.initializeGame(player1, player2);
+
+  var pair_score uint8; // ペアの得点(2倍値)
+  for i:=uint(0); config.Games==0 || i<config.Games; i++ {
+    // 奇数局目はプレイヤー1が後手
+    var swapped bool = i%2 == 1;
+    if (swapped) { g.swapPlayers(); }
+
+    // ペアごとに開局を順に用いる
+    var opening []uint8 = []uint8{};
+    if (len(config.Openings) != 0) {
+      opening = config.Openings[(i/2) % uint(len(config.Openings))];
+    }
+
+    var result uint8 = g.playGame(opening, config.ShowBoard);
+
+    if (swapped) { g.swapPlayers(); }
+
+    if (result == 255) {
+      match.Aborted = true;
+      break;
+    }
+
+    // プレイヤー1から見た得点(2倍値)
+    var score uint8 = 1;
+    if (result == 2) {
+      match.Draws++;
+    } else if ((result == 0) != swapped) {
+      match.Wins++;
+      score = 2;
+    } else {
+      match.Losses++;
+      score = 0;
+    }
+
+    if (config.ShowResult) {
+      fmt.Println(fmt.Sprintf("Game %d: %s", i+1, scoreStr(score)));
+    }
+
+    // 切断されたプレイヤーとは対局を続けられないため中断する
+    if (g.Record.Termination == record.TERMINATION_DISCONNECT) {
+      match.Aborted = true;
+      break;
+    }
+
+    if (!swapped) {
+      pair_score = score;
+      continue;
+    }
+
+    // ペアが揃った
+    pair_score += score;
+    match.SPRT.AddPair(pair_score);
+
+    if (!config.UseSPRT) { continue; }
+
+    // LLRを出力し、判定が出ていれば終了
+    fmt.Println(match.SPRT.String());
+    match.Status = match.SPRT.Status();
+    if (match.Status != SPRT_CONTINUE) { break; }
+  }
+
+  printMatchResult(match, config.UseSPRT);
+
+  // プレイヤーを終了させる
+  g.quitPlayer();
+
+  return match;
+}
+
+/*
+#swapPlayers
+先後のプレイヤーを入れ替える
+*/
+func (g *Game) swapPlayers() {
+  (*g).Black, (*g).White = g.White, g.Black;
+  (*g).BlackName, (*g).WhiteName = g.WhiteName, g.BlackName;
+}
+
+/*
+#scoreStr
+得点(2倍値)を文字列にする
+
+*引数
+score uint8: 得点(0: 負け, 1: 引き分け, 2: 勝ち)
+
+*返り値
+string: 結果の文字列
+*/
+func scoreStr(score uint8) string {
+  switch score {
+  case 0: return "lose";
+  case 1: return "draw";
+  case 2: return "win";
+  default: return "";
+  }
+}
+
+/*
+#printMatchResult
+連続対局の結果を出力
+
+*引数
+match MatchResult: 連続対局の結果
+use_sprt bool    : SPRTの判定を出力するか
+*/
+func printMatchResult(match MatchResult, use_sprt bool) {
+  fmt.Println(fmt.Sprintf(
+    "Win: %d, Lose: %d, Draw: %d, Penta: %v",
+    match.Wins, match.Losses, match.Draws, match.SPRT.Pentanomial,
+  ));
+
+  if (match.Aborted) {
+    fmt.Println("Aborted");
+  }
+
+  if (!use_sprt) { return; }
+
+  switch match.Status {
+  case SPRT_ACCEPT_H1:
+    fmt.Println("SPRT: H1 accepted");
+  case SPRT_ACCEPT_H0:
+    fmt.Println("SPRT: H0 accepted");
+  default:
+    fmt.Println("SPRT: inconclusive");
+  }
+}
@@ -0,0 +1,108 @@
+package game
+
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+
+import "voda/board"
+
+/*
+#opening
+開局集(オープニング)
+・1行に1つ、初手からの列番号(0~6)を並べて記述する(ex. 3324)
+・列番号の間の空白は無視する
+・#以降はコメント、空行は無視する
+*/
+
+/*
+#LoadOpenings
+ファイルから開局集を読み込む
+
+*引数
+path string: 開局集のファイル
+
+*返り値
+[][]uint8: 開局の手順のリスト
+error    : 読み込み、解釈のエラー
+*/
+func LoadOpenings(path string) ([][]uint8, error) {
+  file, err := os.Open(path);
+  if (err != nil) { return nil, err; }
+  defer file.Close();
+
+  var openings [][]uint8;
+  var scanner *bufio.Scanner = bufio.NewScanner(file);
+  var line_no int = 0;
+  for scanner.Scan() {
+    line_no++;
+
+    // コメント、空白を除去
+    var line string = scanner.Text();
+    if (strings.Contains(line, "#")) { line = line[:strings.Index(line, "#")]; }
+    line = strings.Join(strings.Fields(line), "");
+    if (line == "") { continue; }
+
+    opening, err := parseOpening(line);
+    if (err != nil) {
+      return nil, fmt.Errorf("%s:%d: %w", path, line_no, err);
+    }
+    openings = append(openings, opening);
+  }
+  if err := scanner.Err(); err != nil { return nil, err; }
+
+  if (len(openings) == 0) {
+    return nil, fmt.Errorf("%s: no openings", path);
+  }
+
+  return openings, nil;
+}
+
+/*
+#parseOpening
+列番号の文字列を手順に変換し、その正当性を検証する
+・全ての手が合法手であること
+・途中で勝敗が決していないこと
+
+*引数
+line string: 列番号を並べた文字列
+
+*返り値
+[]uint8: 手順
+error  : 不正な手順の場合のエラー
+*/
+func parseOpening(line string) ([]uint8, error) {
+  var moves []uint8;
+  var black_stones uint64 = 0;
+  var white_stones uint64 = 0;
+
+  for i, c := range line {
+    if (c < '0' || c > '6') {
+      return nil, fmt.Errorf("invalid column `%c`", c);
+    }
+    var move uint8 = uint8(c - '0');
+
+    if (!board.CanMove(black_stones, white_stones, move)) {
+      return nil, fmt.Errorf("illegal move %d at ply %d", move, i+1);
+    }
+
+    if (i%2 == 0) {
+      black_stones = board.MakeMove(black_stones, white_stones, move);
+    } else {
+      white_stones = board.MakeMove(white_stones, black_stones, move);
+    }
+
+    if (board.CheckAlignment(black_stones) || board.CheckAlignment(white_stones)) {
+      return nil, fmt.Errorf("game is over at ply %d", i+1);
+    }
+
+    moves = append(moves, move);
+  }
+
+  // 全て埋まった開局は対局にならない
+  if (len(moves) >= 42) {
+    return nil, fmt.Errorf("board is full");
+  }
+
+  return moves, nil;
+}
@@ -0,0 +1,328 @@
+package game
+
+import "fmt"
+
+import "voda/engine"
+import "voda/protocol"
+
+/*
+#Player
+ゲームから見たプレイヤー
+・接続方法(ソケット、プロセス内の関数、ブラウザ上の人間)によらず同じ操作で扱う
+・各メソッドはプロトコルのコマンド(name, start, go, end, undo, quit)に対応する
+・通信、プロトコルの失敗は*PlayerErrorとして返す
+*/
+type Player interface {
+  // プレイヤー名を取得する(name)
+  Name() (string, error)
+  // 対局の開始を通知し、準備ができるのを待つ(start)
+  Start(turn bool) error
+  // 次の手を要求する(go)
+  // 応答までに受け取ったinfoはparam.Infoに渡す(info機能に対応するプレイヤーのみ)
+  Go(param PlayerParam) (PlayerRet, error)
+  // 対局の終了を通知する(end)
+  // 終局理由は対応するプレイヤーにのみ伝える
+  End(result uint8, reason string) error
+  // 手の取り消しを通知する(undo)
+  // undo機能に対応しないプレイヤーには送らない(goが局面を伝えるため)
+  Undo(count int, moves []uint8) error
+  // プレイヤーを終了させる(quit)
+  Quit()
+  // プレイヤーの種類と識別を返す(human, engine:ID, process:ID, socket:ポート, lobby:名前)
+  Identity() string
+}
+
+/*
+#canceler
+思考中のgoを打ち切れるプレイヤー(子プロセス、ソケット)
+・goを呼んでいるgoroutineとは別のgoroutineから呼ぶ(goは通信の失敗として戻る)
+・打ち切った後は起動し直し、再接続を行わず、以後のコマンドにも失敗する(Quitで終了させるのみ)
+・プロセス内の関数、人間のプレイヤーは打ち切れない
+*/
+type canceler interface {
+  cancel()
+}
+
+/*
+#negotiation
+nameの応答で確認したプレイヤーのバージョンと機能
+・ゲームとプレイヤーの双方が対応する機能のみを保持する
+*/
+type negotiation struct {
+  version int     // プレイヤーのプロトコルバージョン
+  caps []string   // 双方が対応する機能
+}
+
+/*
+#nameParam
+バージョンと機能を通知するnameコマンドのパラメータを生成
+
+*返り値
+PlayerParam: nameコマンドのパラメータ
+*/
+func nameParam() PlayerParam {
+  return PlayerParam {
+    Command: protocol.CMD_NAME,
+    Version: protocol.VERSION,
+    Capabilities: protocol.CAPABILITIES,
+  };
+}
+
+/*
+#negotiate
+nameの応答からバージョン、機能を設定する
+・バージョンを返さないプレイヤーはバージョン1、機能なしとする
+
+*引数
+ret PlayerRet: nameの応答
+*/
+func (n *negotiation) negotiate(ret PlayerRet) {
+  (*n).version = ret.Version;
+  if (n.version == 0) { (*n).version = 1; }
+  (*n).caps = protocol.Negotiate(protocol.CAPABILITIES, ret.Capabilities);
+}
+
+/*
+#supports
+双方が機能に対応しているか判定する
+
+*引数
+cap string: 機能
+
+*返り値
+bool: 対応しているか
+*/
+func (n *negotiation) supports(cap string) bool {
+  return protocol.HasCapability(n.caps, cap);
+}
+
+/*
+#codec
+双方が対応する機能に応じたメッセージの形式を返す
+・nameの応答を受け取るまではテキスト形式となる
+・VERSION_EMPTY_COLSより前のプレイヤーには空の一覧を以前の形式で送る
+
+*返り値
+protocol.Codec: メッセージの形式
+*/
+func (n *negotiation) codec() protocol.Codec {
+  var codec protocol.Codec = protocol.NewCodec(n.caps);
+  codec.Legacy = n.version < protocol.VERSION_EMPTY_COLS;
+  return codec;
+}
+
+/*
+#endParam
+対応する機能に応じたendコマンドのパラメータを生成
+
+*引数
+result uint8 : 結果
+reason string: 終局理由
+
+*返り値
+PlayerParam: endコマンドのパラメータ
+*/
+func (n *negotiation) endParam(result uint8, reason string) PlayerParam {
+  var param PlayerParam = PlayerParam{ Command: protocol.CMD_END, Result: result };
+  if (n.supports(protocol.CAP_REASON)) { param.Reason = reason; }
+  return param;
+}
+
+/*
+#goParam
+対応する機能に応じたgoコマンドのパラメータを生成
+・info機能に対応しない場合は、infoを受け付けない(応答がinfoならプロトコル違反となる)
+・info機能に対応する場合は、受け渡し先がなくてもinfoを受け付け、読み捨てる
+
+*引数
+param PlayerParam: 局面を設定したパラメータ
+
+*返り値
+PlayerParam: goコマンドのパラメータ
+*/
+func (n *negotiation) goParam(param PlayerParam) PlayerParam {
+  param.Command = protocol.CMD_GO;
+  if (!n.supports(protocol.CAP_INFO)) {
+    param.Info = nil;
+  } else if (param.Info == nil) {
+    param.Info = func(PlayerRet) {};
+  }
+  return param;
+}
+
+/*
+#undoParam
+undoコマンドのパラメータを生成
+
+*引数
+count int     : 取り消した手数
+moves []uint8 : 取り消した後の操作履歴
+
+*返り値
+PlayerParam: undoコマンドのパラメータ
+*/
+func undoParam(count int, moves []uint8) PlayerParam {
+  return PlayerParam{ Command: protocol.CMD_UNDO, Undone: count, Moves: moves };
+}
+
+/*
+#FuncPlayer
+プロセス内の関数によるプレイヤー
+・プレイヤーモジュールと同じ func(PlayerParam) PlayerRet の形の関数をそのまま呼び出す
+・通信を行わない
+*/
+type FuncPlayer struct {
+  negotiation
+  player func(PlayerParam) PlayerRet // プレイヤー関数
+  id string                          // プロセス内のエンジンの指定名(空の場合は任意の関数)
+}
+
+/*
+#NewFuncPlayer
+関数によるプレイヤーを生成
+
+*引数
+player func(PlayerParam) PlayerRet: プレイヤー関数
+
+*返り値
+*FuncPlayer: プレイヤー
+*/
+func NewFuncPlayer(player func(PlayerParam) PlayerRet) *FuncPlayer {
+  return &FuncPlayer{ player: player };
+}
+
+/*
+#NewEnginePlayer
+プロセス内のエンジン(voda/engine)によるプレイヤーを生成
+
+*引数
+id string: エンジンの指定名
+
+*返り値
+*FuncPlayer: プレイヤー
+error      : エンジンがない場合のエラー
+*/
+func NewEnginePlayer(id string) (*FuncPlayer, error) {
+  b, ok := engine.Find(id);
+  if (!ok) { return nil, fmt.Errorf("unknown engine `%s`", id); }
+  return &FuncPlayer{ player: b.Play, id: id }, nil;
+}
+
+/*
+#call
+プレイヤー関数を呼び出し、応答を検証する
+
+*引数
+param PlayerParam: パラメータ
+
+*返り値
+PlayerRet: プレイヤーの応答
+error    : 想定外の応答の場合の*PlayerError
+*/
+func (p *FuncPlayer) call(param PlayerParam) (PlayerRet, error) {
+  var ret PlayerRet = p.player(param);
+  return ret, checkRet(param.Command, ret);
+}
+
+func (p *FuncPlayer) Name() (string, error) {
+  ret, err := p.call(nameParam());
+  if (err != nil) { return "", err; }
+  p.negotiate(ret);
+  return ret.Name, nil;
+}
+
+func (p *FuncPlayer) Start(turn bool) error {
+  _, err := p.call(PlayerParam{ Command: "start", Turn: turn });
+  return err;
+}
+
+func (p *FuncPlayer) Go(param PlayerParam) (PlayerRet, error) {
+  // プレイヤー関数はinfoをparam.Infoで直接渡す
+  return p.call(p.goParam(param));
+}
+
+func (p *FuncPlayer) End(result uint8, reason string) error {
+  _, err := p.call(p.endParam(result, reason));
+  return err;
+}
+
+func (p *FuncPlayer) Undo(count int, moves []uint8) error {
+  if (!p.supports(protocol.CAP_UNDO)) { return nil; }
+  _, err := p.call(undoParam(count, moves));
+  return err;
+}
+
+func (p *FuncPlayer) Quit() {
+  // プレイヤー関数はquitに応答しない
+}
+
+func (p *FuncPlayer) Identity() string {
+  if (p.id == "") { return "function"; }
+  return "engine:" + p.id;
+}
+
+/*
+#HumanPlayer
+ブラウザ上の人間のプレイヤー
+・ブラウザから受け取った列をSubmitで渡し、Goがそれを返す
+*/
+type HumanPlayer struct {
+  moves chan uint8 // ブラウザから受け取った列
+}
+
+/*
+#NewHumanPlayer
+人間のプレイヤーを生成
+
+*返り値
+*HumanPlayer: プレイヤー
+*/
+func NewHumanPlayer() *HumanPlayer {
+  return &HumanPlayer{ moves: make(chan uint8, 1) };
+}
+
+/*
+#Submit
+ブラウザから受け取った列を渡す
+・既に未処理の列がある場合は無視する
+
+*引数
+col uint8: 列
+*/
+func (p *HumanPlayer) Submit(col uint8) {
+  select {
+  case p.moves <- col:
+  default:
+  }
+}
+
+func (p *HumanPlayer) Name() (string, error) {
+  return "Human", nil;
+}
+
+func (p *HumanPlayer) Start(turn bool) error {
+  // 前の対局で未処理の列を捨てる
+  select {
+  case <-p.moves:
+  default:
+  }
+  return nil;
+}
+
+func (p *HumanPlayer) Go(param PlayerParam) (PlayerRet, error) {
+  return PlayerRet{ Command: "move", Move: <-p.moves }, nil;
+}
+
+func (p *HumanPlayer) End(result uint8, reason string) error {
+  return nil;
+}
+
+func (p *HumanPlayer) Undo(count int, moves []uint8) error {
+  return nil;
+}
+
+func (p *HumanPlayer) Quit() {}
+
+func (p *HumanPlayer) Identity() string {
+  return PLAYER_HUMAN;
+}
@@ -0,0 +1,419 @@
+package game
+
+import "os"
+import "io"
+import "fmt"
+import "sync"
+import "errors"
+import "time"
+import "strings"
+import "os/exec"
+import "path/filepath"
+
+import "voda/record"
+import "voda/protocol"
+
+/*
+#ProcessPlayer
+子プロセスとして起動したプレイヤー
+・コマンド(name, start, go, end, quit)は標準入出力を介して1行ずつやり取りする
+・子プロセスの標準エラー出力はログファイルに追記する
+・quitで子プロセスを終了させ、終了しない場合はプロセスグループごと強制終了する
+・猶予時間が指定された場合、通信に失敗した子プロセスを起動し直し、応答を得られなかったコマンドを送り直す
+  (対局中であればnameの後にstartも送り直す、局面は送り直すgo、undoが伝える)
+・制限時間が指定された場合、時間内に応答しない子プロセスは強制終了し、切断として扱う
+・標準出力は子プロセスごとのgoroutineが終わりまで読み、読み終えてから子プロセスを回収する
+*/
+type ProcessPlayer struct {
+  negotiation
+  args []string        // 起動するコマンド
+  id string            // サーバが起動するエンジンの指定名(空の場合はコマンドで識別する)
+  grace time.Duration  // 起動し直したプロセスがnameに応答するまでの猶予時間(0は起動し直さない)
+  timeout time.Duration // 各コマンドに応答するまでの制限時間(0は制限しない)
+  cmd *exec.Cmd      // 子プロセス
+  stdin io.WriteCloser // 子プロセスの標準入力
+  writer *protocol.Writer // 子プロセスの標準入力への書き込み
+  output *processOutput   // 子プロセスの標準出力
+  log *os.File       // 標準エラー出力のログ
+
+  exited chan struct{} // 子プロセスの終了通知
+  playing bool         // startを送ってからendを送るまでか(起動し直した場合にstartを送り直す)
+  turn bool            // 対局中の先後
+
+  mu sync.Mutex    // cmd、cancelledの保護(cancelは別のgoroutineから呼ばれる)
+  cancelled bool   // 思考を打ち切ったか(以後は起動し直さない)
+}
+
+// 子プロセスの標準出力から読んだ1行
+type processLine struct {
+  line string // メッセージ
+  err error   // 読み直せる読み込みのエラー(不正な行、長すぎる行)
+}
+
+// 子プロセスの標準出力(子プロセスごと)
+type processOutput struct {
+  lines chan processLine // 読んだ行(読み終えると閉じる)
+  err error              // 読み終えた理由(linesが閉じた後にのみ参照する)
+  abandon chan struct{}  // 子プロセスを使わなくなったことの通知(以後の行は読み捨てる)
+  abandon_once sync.Once // abandonを一度だけ閉じる
+}
+
+/*
+#discard
+子プロセスを使わなくなったことを通知し、以後の行を読み捨てさせる
+・切断、quit、起動し直しのいずれから呼ばれてもよい
+*/
+func (o *processOutput) discard() {
+  o.abandon_once.Do(func() { close(o.abandon); });
+}
+
+// quit後、強制終了するまでの猶予
+const PROCESS_QUIT_TIMEOUT time.Duration = 2 * time.Second;
+
+/*
+#NewProcessPlayer
+プレイヤーのプログラムを子プロセスとして起動する
+
+*引数
+command string     : 起動するコマンド(空白区切り, ex. "go run . --player g0F")
+log_path string    : 標準エラー出力のログファイル(空の場合は破棄)
+grace time.Duration: 通信に失敗した場合に起動し直し、nameの応答を待つ猶予時間(0は起動し直さない)
+timeout time.Duration: 各コマンドに応答するまでの制限時間(0は制限しない)
+
+*返り値
+*ProcessPlayer: プレイヤー
+error         : 起動のエラー
+*/
+func NewProcessPlayer(command string, log_path string, grace time.Duration, timeout time.Duration) (*ProcessPlayer, error) {
+  var args []string = strings.Fields(command);
+  if (len(args) == 0) {
+    return nil, fmt.Errorf("empty command");
+  }
+
+  var p *ProcessPlayer = &ProcessPlayer{ args: args, grace: grace, timeout: timeout };
+
+  // 標準エラー出力をログへ(起動し直した場合も同じファイルに追記する)
+  if (log_path != "") {
+    if err := os.MkdirAll(filepath.Dir(log_path), 0755); err != nil { return nil, err; }
+    log, err := os.OpenFile(log_path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644);
+    if (err != nil) { return nil, err; }
+    p.log = log;
+  }
+
+  if err := p.launch(); err != nil {
+    p.closeLog();
+    return nil, err;
+  }
+  return p, nil;
+}
+
+/*
+#launch
+子プロセスを起動する
+
+*返り値
+error: 起動のエラー
+*/
+func (p *ProcessPlayer) launch() error {
+  p.mu.Lock();
+  defer p.mu.Unlock();
+  if (p.cancelled) { return fmt.Errorf("cancelled"); }
+
+  var cmd *exec.Cmd = exec.Command(p.args[0], p.args[1:]...);
+  // go runなどが起動する孫プロセスもまとめて終了できるよう、プロセスグループを分ける
+  setProcessGroup(cmd);
+  if (p.log != nil) { cmd.Stderr = p.log; }
+
+  stdin, err := cmd.StdinPipe();
+  if (err != nil) { return err; }
+  stdout, err := cmd.StdoutPipe();
+  if (err != nil) { return err; }
+
+  if err := cmd.Start(); err != nil {
+    return err;
+  }
+  p.cmd = cmd;
+  p.stdin = stdin;
+  p.writer = protocol.NewWriter(stdin);
+
+  var output *processOutput = &processOutput{ lines: make(chan processLine), abandon: make(chan struct{}) };
+  var exited chan struct{} = make(chan struct{});
+  p.output = output;
+  p.exited = exited;
+  go readProcess(cmd, protocol.NewReader(stdout), output, exited);
+
+  return nil;
+}
+
+/*
+#readProcess
+子プロセスの標準出力を終わりまで読み、読み終えてから子プロセスを回収する
+・読んだ行は応答を待つexchangeに渡し、discardの後は読み捨てる
+・不正な行、長すぎる行の後も読み続ける(次の行から読み直せる)
+・読み終えた理由を記録してlinesを閉じる(応答を待つ者がいなくても回収できる)
+
+*引数
+cmd *exec.Cmd          : 子プロセス
+reader *protocol.Reader: 標準出力からの読み込み
+output *processOutput  : 読んだ行の受け渡し先
+exited chan struct{}   : 回収を通知するチャネル(回収後に閉じる)
+*/
+func readProcess(cmd *exec.Cmd, reader *protocol.Reader, output *processOutput, exited chan struct{}) {
+  for {
+    line, err := reader.ReadMessage();
+    if (err != nil && err != protocol.ErrLineTooLong && !errors.As(err, new(*protocol.ParseError))) {
+      output.err = err;
+      break;
+    }
+    select {
+    case output.lines <- processLine{ line: line, err: err }:
+    case <-output.abandon:
+    }
+  }
+  close(output.lines);
+  // 標準出力を読み終える前にWaitを呼んではならない(os/exec)
+  cmd.Wait();
+  close(exited);
+}
+
+/*
+#relaunch
+通信に失敗した子プロセスを強制終了して起動し直し、nameで再び接続を確認する
+・対局中であれば、同じ先後でstartを送り直す(応答を得られなかったコマンドがstartの場合を除く)
+・猶予時間内にname、startへ応答しない場合は失敗とする
+
+*引数
+command string: 応答を得られなかったコマンド
+err error     : 通信の失敗
+
+*返り値
+error: 起動し直せない場合の*PlayerError
+*/
+func (p *ProcessPlayer) relaunch(command string, err error) error {
+  fmt.Fprintln(os.Stderr, fmt.Sprintf("restart(%s): %s", p.args[0], err));
+  p.output.discard();
+  p.stdin.Close();
+  p.kill();
+  <-p.exited;
+
+  if launch_err := p.launch(); launch_err != nil {
+    return disconnectError(command, fmt.Errorf("restart failed: %w", launch_err));
+  }
+
+  // 猶予時間内にname、startへ応答しなければ強制終了する
+  var done chan error = make(chan error, 1);
+  go func() {
+    _, name_err := p.exchange(nameParam());
+    if (name_err == nil && p.playing && command != protocol.CMD_START) {
+      _, name_err = p.exchange(PlayerParam{ Command: protocol.CMD_START, Turn: p.turn });
+    }
+    done <- name_err;
+  }();
+  select {
+  case name_err := <-done:
+    if (name_err != nil) { return disconnectError(command, fmt.Errorf("restart failed: %w", causeOf(name_err))); }
+  case <-time.After(p.grace):
+    p.kill();
+    <-done;
+    return disconnectError(command, fmt.Errorf("not restarted within %s: %w", p.grace, causeOf(err)));
+  }
+  return nil;
+}
+
+/*
+#send
+子プロセスにメッセージを送信し、応答を受け取る
+
+*引数
+param PlayerParam: 送信するパラメータ
+
+*返り値
+PlayerRet: プレイヤーの応答
+error    : 通信、プロトコルの失敗の*PlayerError
+*/
+func (p *ProcessPlayer) send(param PlayerParam) (PlayerRet, error) {
+  ret, err := p.exchange(param);
+  // 通信に失敗した場合、起動し直して送り直す(起動直後のnameは除く)
+  if (p.grace == 0 || param.Command == protocol.CMD_NAME || terminationOf(err) != record.TERMINATION_DISCONNECT) {
+    return ret, err;
+  }
+  // 打ち切った場合は起動し直さない
+  if (p.isCancelled()) { return ret, err; }
+  if err := p.relaunch(param.Command, err); err != nil {
+    return PlayerRet{}, err;
+  }
+  return p.exchange(param);
+}
+
+/*
+#exchange
+子プロセスにメッセージを1つ送信し、応答を受け取る
+・切断した子プロセスの残りの出力は読み捨てる(応答を待つ者がいなくても回収できる)
+
+*引数
+param PlayerParam: 送信するパラメータ
+
+*返り値
+PlayerRet: プレイヤーの応答
+error    : 通信、プロトコルの失敗の*PlayerError
+*/
+func (p *ProcessPlayer) exchange(param PlayerParam) (PlayerRet, error) {
+  ret, err := p.exchangeOnce(param);
+  if (terminationOf(err) == record.TERMINATION_DISCONNECT) { p.output.discard(); }
+  return ret, err;
+}
+
+/*
+#exchangeOnce
+子プロセスにメッセージを1つ送信し、制限時間内の応答を受け取る
+・制限時間内に応答しない場合は子プロセスを強制終了し、切断とする
+
+*引数
+param PlayerParam: 送信するパラメータ
+
+*返り値
+PlayerRet: プレイヤーの応答
+error    : 通信、プロトコルの失敗の*PlayerError
+*/
+func (p *ProcessPlayer) exchangeOnce(param PlayerParam) (PlayerRet, error) {
+  var codec protocol.Codec = p.codec();
+  msg, err := codec.EncodeParam(param);
+  if (err != nil) { return PlayerRet{}, protocolError(param.Command, err); }
+
+  if err := p.writer.WriteMessage(msg); err != nil {
+    return PlayerRet{}, disconnectError(param.Command, err);
+  }
+
+  // 制限しない場合はnilのチャネルとし、待ち続ける
+  var timeout <-chan time.Time;
+  if (p.timeout != 0) {
+    var timer *time.Timer = time.NewTimer(p.timeout);
+    defer timer.Stop();
+    timeout = timer.C;
+  }
+
+  for {
+    var read processLine;
+    var ok bool;
+    select {
+    case read, ok = <-p.output.lines:
+      if (!ok) { return PlayerRet{}, disconnectError(param.Command, p.output.err); }
+    case <-timeout:
+      p.kill();
+      return PlayerRet{}, disconnectError(param.Command, fmt.Errorf("no response within %s", p.timeout));
+    }
+    if (read.err != nil) { return PlayerRet{}, protocolError(param.Command, read.err); }
+
+    ret, err := codec.DecodeRet(read.line);
+    if (err != nil) { return ret, protocolError(param.Command, err); }
+    // goへのinfoは受け渡し先に渡し、応答を待ち続ける
+    if (isInfo(param, ret)) {
+      param.Info(ret);
+      continue;
+    }
+    return ret, checkRet(param.Command, ret);
+  }
+}
+
+func (p *ProcessPlayer) Name() (string, error) {
+  ret, err := p.send(nameParam());
+  if (err != nil) { return "", err; }
+  p.negotiate(ret);
+  return ret.Name, nil;
+}
+
+func (p *ProcessPlayer) Start(turn bool) error {
+  p.playing = true;
+  p.turn = turn;
+  _, err := p.send(PlayerParam{ Command: "start", Turn: turn });
+  return err;
+}
+
+func (p *ProcessPlayer) Go(param PlayerParam) (PlayerRet, error) {
+  return p.send(p.goParam(param));
+}
+
+func (p *ProcessPlayer) End(result uint8, reason string) error {
+  _, err := p.send(p.endParam(result, reason));
+  p.playing = false;
+  return err;
+}
+
+func (p *ProcessPlayer) Undo(count int, moves []uint8) error {
+  if (!p.supports(protocol.CAP_UNDO)) { return nil; }
+  _, err := p.send(undoParam(count, moves));
+  return err;
+}
+
+/*
+#Quit
+quitを送信して子プロセスの終了を待ち、猶予を過ぎれば強制終了する
+・いずれの場合も子プロセスを回収してから戻る
+*/
+func (p *ProcessPlayer) Quit() {
+  if msg, err := p.codec().EncodeParam(PlayerParam{ Command: "quit" }); err == nil {
+    p.writer.WriteMessage(msg);
+  }
+  p.output.discard();
+  p.stdin.Close();
+
+  select {
+  case <-p.exited:
+  case <-time.After(PROCESS_QUIT_TIMEOUT):
+    p.kill();
+    <-p.exited;
+  }
+
+  p.closeLog();
+}
+
+/*
+#cancel
+思考中の子プロセスをプロセスグループごと強制終了し、以後は起動し直さない
+・goは切断として戻る
+*/
+func (p *ProcessPlayer) cancel() {
+  p.mu.Lock();
+  defer p.mu.Unlock();
+  p.cancelled = true;
+  killProcessGroup(p.cmd);
+}
+
+/*
+#isCancelled
+思考を打ち切ったか判定する
+
+*返り値
+bool: 打ち切ったか
+*/
+func (p *ProcessPlayer) isCancelled() bool {
+  p.mu.Lock();
+  defer p.mu.Unlock();
+  return p.cancelled;
+}
+
+/*
+#kill
+現在の子プロセスをプロセスグループごと強制終了する
+*/
+func (p *ProcessPlayer) kill() {
+  p.mu.Lock();
+  defer p.mu.Unlock();
+  killProcessGroup(p.cmd);
+}
+
+func (p *ProcessPlayer) Identity() string {
+  if (p.id == "") { return "process:" + strings.Join(p.args, " "); }
+  return "process:" + p.id;
+}
+
+/*
+#closeLog
+ログファイルを閉じる
+*/
+func (p *ProcessPlayer) closeLog() {
+  if (p.log != nil) {
+    p.log.Close();
+    p.log = nil;
+  }
+}
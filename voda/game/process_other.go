@@ -0,0 +1,26 @@
+//go:build !unix
+
+package game
+
+import "os/exec"
+
+/*
+#setProcessGroup
+プロセスグループを扱えない環境では何もしない
+
+*引数
+cmd *exec.Cmd: 起動前の子プロセス
+*/
+func setProcessGroup(cmd *exec.Cmd) {}
+
+/*
+#killProcessGroup
+子プロセスのみを強制終了
+
+*引数
+cmd *exec.Cmd: 起動済みの子プロセス
+*/
+func killProcessGroup(cmd *exec.Cmd) {
+  if (cmd.Process == nil) { return; }
+  cmd.Process.Kill();
+}
@@ -0,0 +1,133 @@
+//go:build unix
+
+package game
+
+import "os"
+import "time"
+import "strings"
+import "testing"
+import "path/filepath"
+
+import "voda/record"
+
+/*
+#process_test
+子プロセスのプレイヤーの検証
+・シェルスクリプトをプレイヤーとして起動する
+*/
+
+// nameとstartにのみ応答し、goには応答しないプレイヤー
+const SILENT_PLAYER string = `
+while read command rest; do
+  case "$command" in
+    name) echo "setname silent";;
+    start) echo "ready";;
+    go) sleep 60;;
+    quit) exit 0;;
+  esac
+done
+`;
+
+// 最初のgoで異常終了し、起動し直した後は応答するプレイヤー(受け取ったコマンドを記録する)
+const CRASHING_PLAYER string = `
+dir=$(dirname "$0")
+while read command rest; do
+  echo "$command" >> "$dir/commands.log"
+  case "$command" in
+    name) echo "setname crashing";;
+    start) echo "ready";;
+    go) if [ -e "$dir/crashed" ]; then echo "move 3"; else touch "$dir/crashed"; exit 1; fi;;
+    end) echo "bye";;
+    quit) exit 0;;
+  esac
+done
+`;
+
+/*
+#newScriptPlayer
+シェルスクリプトを子プロセスのプレイヤーとして起動する
+
+*引数
+t *testing.T         : テスト
+script string        : スクリプト
+grace time.Duration  : 起動し直す猶予時間
+timeout time.Duration: 各コマンドの制限時間
+
+*返り値
+*ProcessPlayer: プレイヤー
+string        : スクリプトを置いたディレクトリ
+*/
+func newScriptPlayer(t *testing.T, script string, grace time.Duration, timeout time.Duration) (*ProcessPlayer, string) {
+  t.Helper();
+  var dir string = t.TempDir();
+  var path string = filepath.Join(dir, "player.sh");
+  if err := os.WriteFile(path, []byte(script), 0644); err != nil { t.Fatalf("WriteFile: %v", err); }
+  p, err := NewProcessPlayer("sh " + path, "", grace, timeout);
+  if (err != nil) { t.Fatalf("NewProcessPlayer: %v", err); }
+  return p, dir;
+}
+
+/*
+#TestProcessTimeout
+制限時間内にgoへ応答しない子プロセスは強制終了され、切断となること
+*/
+func TestProcessTimeout(t *testing.T) {
+  p, _ := newScriptPlayer(t, SILENT_PLAYER, 0, 200 * time.Millisecond);
+  if _, err := p.Name(); err != nil { t.Fatalf("Name: %v", err); }
+  if err := p.Start(true); err != nil { t.Fatalf("Start: %v", err); }
+
+  var start time.Time = time.Now();
+  _, err := p.Go(PlayerParam{ ValidMoves: []uint8{ 0 } });
+  if (terminationOf(err) != record.TERMINATION_DISCONNECT) { t.Errorf("Go: got %v, want disconnect", err); }
+  if (time.Since(start) > 5 * time.Second) { t.Errorf("Go returned after %s", time.Since(start)); }
+
+  select {
+  case <-p.exited:
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("process was not reaped after the timeout");
+  }
+  p.Quit();
+}
+
+/*
+#TestProcessExit
+応答の前に終了した子プロセスは切断となり、標準出力を読み終えてから回収されること
+*/
+func TestProcessExit(t *testing.T) {
+  p, _ := newScriptPlayer(t, `read command rest; echo "setname short"; echo "extra"`, 0, 0);
+  if _, err := p.Name(); err != nil { t.Fatalf("Name: %v", err); }
+
+  // 残りの行は次の応答として読まれ、その後に終了が分かる
+  if err := p.Start(true); err == nil { t.Errorf("Start: got nil, want error"); }
+  if err := p.Start(true); terminationOf(err) != record.TERMINATION_DISCONNECT {
+    t.Errorf("Start after exit: got %v, want disconnect", err);
+  }
+  select {
+  case <-p.exited:
+  case <-time.After(TEST_TIMEOUT):
+    t.Fatalf("process was not reaped after EOF");
+  }
+  p.Quit();
+}
+
+/*
+#TestProcessRelaunch
+goの途中で終了した子プロセスは起動し直され、name、startの後にgoを送り直されること
+*/
+func TestProcessRelaunch(t *testing.T) {
+  p, dir := newScriptPlayer(t, CRASHING_PLAYER, TEST_TIMEOUT, 0);
+  if _, err := p.Name(); err != nil { t.Fatalf("Name: %v", err); }
+  if err := p.Start(false); err != nil { t.Fatalf("Start: %v", err); }
+
+  ret, err := p.Go(PlayerParam{ Moves: []uint8{ 3 }, ValidMoves: []uint8{ 3 } });
+  if (err != nil || ret.Move != 3) { t.Fatalf("Go after restart: got %v, %v", ret, err); }
+  if err := p.End(2, record.TERMINATION_ABORT); err != nil { t.Errorf("End: %v", err); }
+  p.Quit();
+
+  commands, err := os.ReadFile(filepath.Join(dir, "commands.log"));
+  if (err != nil) { t.Fatalf("ReadFile: %v", err); }
+  var want string = "name start go name start go end quit";
+  if got := strings.Join(strings.Fields(string(commands)), " "); got != want {
+    t.Errorf("commands: got %q, want %q", got, want);
+  }
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package game
+
+import "syscall"
+import "os/exec"
+
+/*
+#setProcessGroup
+子プロセスを新しいプロセスグループで起動するよう設定
+
+*引数
+cmd *exec.Cmd: 起動前の子プロセス
+*/
+func setProcessGroup(cmd *exec.Cmd) {
+  cmd.SysProcAttr = &syscall.SysProcAttr{ Setpgid: true };
+}
+
+/*
+#killProcessGroup
+子プロセスをプロセスグループごと強制終了
+
+*引数
+cmd *exec.Cmd: 起動済みの子プロセス
+*/
+func killProcessGroup(cmd *exec.Cmd) {
+  if (cmd.Process == nil) { return; }
+  // 負のpidでプロセスグループ全体に送る
+  syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL);
+}
@@ -0,0 +1,422 @@
+package game
+
+import "fmt"
+import "net"
+import "sort"
+import "sync"
+import "time"
+import "strings"
+import "errors"
+import "strconv"
+import "net/http"
+import "crypto/rand"
+import "crypto/subtle"
+import "path/filepath"
+
+import "voda/engine"
+
+/*
+#Server
+複数の対局を同時に管理する
+・対局はIDで区別し、それぞれが自身のプレイヤー、盤面、goroutineを持つ
+・対局の状態はその対局を所有するgoroutineのみが操作する
+・HTTPのAPIは対局のIDごとに振り分ける(game_browser.go、api.go)
+*/
+type Server struct {
+  Lobby *Lobby     // プレイヤーを取り出すロビー(nilの場合は人間のみ)
+  LobbyGame string // ロビーから取り出すプレイヤーの対局名
+
+  ProcessEngines []ProcessEngine // 対局ごとに子プロセスとして起動するエンジン
+  EngineLogDir string            // 子プロセスの標準エラー出力のログのディレクトリ
+  Grace time.Duration            // 子プロセスを起動し直す猶予時間
+  Timeout time.Duration          // 子プロセスが各コマンドに応答するまでの制限時間(0は制限しない)
+
+  RecordPath string  // 棋譜を追記するファイル(空の場合は保存しない)
+  ArchivePath string // 対局を追記する保管庫(空の場合は保存しない)
+
+  CreateToken string // 対局の生成に要するトークン(空の場合はローカルホストからのみ生成できる)
+  MaxGames int       // 同時に保持する対局の上限(0は制限しない)
+
+  Host string     // ブラウザからの接続を待ち受けるアドレス(空の場合はすべて)
+  ThemeDir string // 静的ファイルを置き換えるテーマのディレクトリ(空の場合は埋め込んだもののみ)
+  ln net.Listener // ブラウザからの接続の待ち受け(ListenBrowser)
+
+  mu sync.Mutex
+  games map[string]*Game // 対局(ID毎)
+  next_id uint           // 次に割り当てるID
+  pending int            // 生成中の対局の数(上限の判定に含める)
+}
+
+// 対局の情報(一覧用)
+type GameInfo struct {
+  ID string
+  BlackName string // 先手の名称
+  WhiteName string // 後手の名称
+  Counter uint8    // 手数
+}
+
+// 生成した対局(生成したブラウザ、起動時の表示用)
+type CreatedGame struct {
+  ID string
+  Token string // 操作用のトークン
+  Code string  // 参加用のコード
+}
+
+// 参加用のコードに用いる文字(読み違えやすい文字を除く)
+const JOIN_CODE_CHARS string = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789";
+
+// 参加用のコードの長さ
+const JOIN_CODE_LENGTH int = 6;
+
+// 子プロセスとして起動するエンジン
+type ProcessEngine struct {
+  ID string      // 指定名
+  Command string // 起動するコマンド
+}
+
+// 対局の生成時に選べるプレイヤー
+type PlayerChoice struct {
+  Spec string // プレイヤーの指定(newPlayerに渡す)
+  Name string // 表示名
+}
+
+// プレイヤーの指定
+const (
+  PLAYER_HUMAN string = "human" // ブラウザ上の人間
+  PLAYER_LOBBY string = "lobby" // ロビーで待機中のプレイヤー
+  PLAYER_ENGINE string = "engine:"   // プロセス内のエンジン(engine:ID)
+  PLAYER_PROCESS string = "process:" // 子プロセスのエンジン(process:ID)
+)
+
+/*
+#NewServer
+対局を持たないサーバを生成
+
+*返り値
+*Server: サーバ
+*/
+func NewServer() *Server {
+  return &Server{ games: map[string]*Game{}, next_id: 1 };
+}
+
+/*
+#CreateGame
+プレイヤーを指定して対局を生成し、プレイヤーとの接続を開始する
+・対局を操作できるのは操作用のトークンを示したブラウザのみとする(それ以外は観戦のみ)
+・参加用のコードを示したブラウザは、人間の側の席を確保して着手できる
+
+*引数
+black Player: 先手のプレイヤー
+white Player: 後手のプレイヤー
+
+*返り値
+CreatedGame: 対局のID、操作用のトークン、参加用のコード
+error      : トークンを生成できない場合のエラー
+*/
+func (s *Server) CreateGame(black Player, white Player) (CreatedGame, error) {
+  if err := s.reserveGame(); err != nil { return CreatedGame{}, err; }
+  defer s.releaseGame();
+  return s.createGame(black, white);
+}
+
+/*
+#createGame
+枠を確保済みの対局を生成する(CreateGameを参照)
+*/
+func (s *Server) createGame(black Player, white Player) (CreatedGame, error) {
+  token, err := newSessionToken();
+  if (err != nil) { return CreatedGame{}, err; }
+  code, err := newJoinCode();
+  if (err != nil) { return CreatedGame{}, err; }
+
+  var g *Game = &Game{ RecordPath: s.RecordPath, ArchivePath: s.ArchivePath, control: token, join: code };
+  // プレイヤーとの接続、以降の操作は対局ごとのgoroutineが行う
+  g.startOwner(black, white);
+
+  s.mu.Lock();
+  var id string = strconv.FormatUint(uint64(s.next_id), 10);
+  s.next_id++;
+  s.games[id] = g;
+  s.mu.Unlock();
+  go s.forgetGame(id, g);
+
+  return CreatedGame{ ID: id, Token: token, Code: code }, nil;
+}
+
+/*
+#forgetGame
+quitで終了した対局を一覧から除く
+・対局を所有するgoroutineの終了を待つ(RemoveGameで除かれた場合は何もしない)
+・除いた対局は一覧、上限の判定(reserveGame)に含まれない
+
+*引数
+id string: 対局のID
+g *Game  : 対局
+*/
+func (s *Server) forgetGame(id string, g *Game) {
+  <-g.done;
+  s.mu.Lock();
+  if (s.games[id] == g) { delete(s.games, id); }
+  s.mu.Unlock();
+}
+
+/*
+#CreateGameBySpec
+プレイヤーの指定(human, lobby, engine:ID, process:ID)から対局を生成する
+・ロビーのプレイヤーは待機中の者のみを用い、いなければエラーとする
+
+*引数
+black string: 先手の指定
+white string: 後手の指定
+
+*返り値
+CreatedGame: 対局のID、操作用のトークン、参加用のコード
+error      : プレイヤーを用意できない場合、対局が上限に達している場合(errTooManyGames)のエラー
+*/
+func (s *Server) CreateGameBySpec(black string, white string) (CreatedGame, error) {
+  // 子プロセスの起動、ロビーからの取り出しの前に枠を確保する
+  if err := s.reserveGame(); err != nil { return CreatedGame{}, err; }
+  defer s.releaseGame();
+
+  black_player, err := s.newPlayer(black);
+  if (err != nil) { return CreatedGame{}, err; }
+  white_player, err := s.newPlayer(white);
+  if (err != nil) {
+    // 取り出したプレイヤーはロビーに戻す
+    black_player.Quit();
+    return CreatedGame{}, err;
+  }
+  created, err := s.createGame(black_player, white_player);
+  if (err != nil) {
+    black_player.Quit();
+    white_player.Quit();
+  }
+  return created, err;
+}
+
+// 対局が上限に達している場合のエラー
+var errTooManyGames error = errors.New("too many games");
+
+/*
+#reserveGame
+生成する対局の枠を確保する
+・保持している対局と生成中の対局の合計が上限に達している場合はエラーとする
+・確保した枠はreleaseGameで返す(生成した対局はgamesに含まれる)
+
+*返り値
+error: 上限に達している場合のerrTooManyGames
+*/
+func (s *Server) reserveGame() error {
+  s.mu.Lock();
+  defer s.mu.Unlock();
+  if (s.MaxGames > 0 && len(s.games) + s.pending >= s.MaxGames) {
+    return fmt.Errorf("%w: at most %d games can be held, remove finished games first", errTooManyGames, s.MaxGames);
+  }
+  s.pending++;
+  return nil;
+}
+
+/*
+#releaseGame
+reserveGameで確保した枠を返す
+*/
+func (s *Server) releaseGame() {
+  s.mu.Lock();
+  s.pending--;
+  s.mu.Unlock();
+}
+
+/*
+#canCreate
+リクエストが対局を生成できるかを返す
+・CreateTokenが設定されている場合は、クエリのcreateが一致すること
+・設定されていない場合は、ローカルホストからのリクエストであること
+
+*引数
+r *http.Request: リクエスト
+
+*返り値
+bool: 生成できるか
+*/
+func (s *Server) canCreate(r *http.Request) bool {
+  if (s.CreateToken != "") {
+    return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("create")), []byte(s.CreateToken)) == 1;
+  }
+  host, _, err := net.SplitHostPort(r.RemoteAddr);
+  if (err != nil) { return false; }
+  var ip net.IP = net.ParseIP(host);
+  return ip != nil && ip.IsLoopback();
+}
+
+/*
+#createStatus
+対局の生成に失敗した場合のHTTPのステータスを返す
+
+*引数
+err error: CreateGameBySpecのエラー
+
+*返り値
+int: 上限に達している場合は503、それ以外は400
+*/
+func createStatus(err error) int {
+  if (errors.Is(err, errTooManyGames)) { return http.StatusServiceUnavailable; }
+  return http.StatusBadRequest;
+}
+
+/*
+#newPlayer
+指定からプレイヤーを用意する
+
+*引数
+spec string: プレイヤーの指定(human, lobby, engine:ID, process:ID)
+
+*返り値
+Player: プレイヤー
+error : 用意できない場合のエラー
+*/
+func (s *Server) newPlayer(spec string) (Player, error) {
+  switch {
+  case spec == PLAYER_HUMAN:
+    return NewHumanPlayer(), nil;
+  case spec == PLAYER_LOBBY:
+    if (s.Lobby == nil) { return nil, fmt.Errorf("lobby is not enabled"); }
+    p, ok := s.Lobby.Take(s.LobbyGame);
+    if (!ok) { return nil, fmt.Errorf("no player is waiting in the lobby"); }
+    return p, nil;
+  case strings.HasPrefix(spec, PLAYER_ENGINE):
+    return NewEnginePlayer(strings.TrimPrefix(spec, PLAYER_ENGINE));
+  case strings.HasPrefix(spec, PLAYER_PROCESS):
+    var id string = strings.TrimPrefix(spec, PLAYER_PROCESS);
+    for _, e := range s.ProcessEngines {
+      if (e.ID != id) { continue; }
+      p, err := NewProcessPlayer(e.Command, filepath.Join(s.EngineLogDir, id + ".log"), s.Grace, s.Timeout);
+      if (err != nil) { return nil, err; }
+      p.id = id;
+      return p, nil;
+    }
+  }
+  return nil, fmt.Errorf("unknown player `%s`", spec);
+}
+
+/*
+#PlayerChoices
+対局の生成時に選べるプレイヤーの一覧を返す
+・人間、プロセス内のエンジン、子プロセスのエンジン、ロビー(有効な場合)の順
+
+*返り値
+[]PlayerChoice: プレイヤーの指定と表示名
+*/
+func (s *Server) PlayerChoices() []PlayerChoice {
+  var choices []PlayerChoice = []PlayerChoice{ { Spec: PLAYER_HUMAN, Name: "Human" } };
+  for _, b := range engine.Builtins() {
+    choices = append(choices, PlayerChoice{ Spec: PLAYER_ENGINE + b.ID, Name: b.Name });
+  }
+  for _, e := range s.ProcessEngines {
+    choices = append(choices, PlayerChoice{ Spec: PLAYER_PROCESS + e.ID, Name: e.ID });
+  }
+  if (s.Lobby != nil) {
+    choices = append(choices, PlayerChoice{ Spec: PLAYER_LOBBY, Name: "Lobby" });
+  }
+  return choices;
+}
+
+/*
+#newJoinCode
+口頭でも伝えられる参加用のコードを生成
+
+*返り値
+string: 参加用のコード
+error : 乱数生成のエラー
+*/
+func newJoinCode() (string, error) {
+  var buf []byte = make([]byte, JOIN_CODE_LENGTH);
+  if _, err := rand.Read(buf); err != nil { return "", err; }
+  for i, b := range buf {
+    buf[i] = JOIN_CODE_CHARS[int(b) % len(JOIN_CODE_CHARS)];
+  }
+  return string(buf), nil;
+}
+
+/*
+#RemoveGame
+対局を取り除き、プレイヤーを終了させる(ロビーのプレイヤーはロビーに戻る)
+・プレイヤーの終了は待たない(処理中の操作の後に対局を所有するgoroutineが行う)
+・思考中のエンジンは打ち切る
+
+*引数
+id string: 対局のID
+
+*返り値
+error: 対局がない場合のエラー
+*/
+func (s *Server) RemoveGame(id string) error {
+  s.mu.Lock();
+  g, ok := s.games[id];
+  delete(s.games, id);
+  s.mu.Unlock();
+
+  if (!ok) { return fmt.Errorf("game `%s` not found", id); }
+  close(g.removed);
+  return nil;
+}
+
+/*
+#Game
+IDから対局を取得
+
+*引数
+id string: 対局のID
+
+*返り値
+*Game: 対局
+bool : 対局があるか
+*/
+func (s *Server) Game(id string) (*Game, bool) {
+  s.mu.Lock();
+  defer s.mu.Unlock();
+  g, ok := s.games[id];
+  return g, ok;
+}
+
+/*
+#Games
+対局の一覧をID順に取得
+
+*返り値
+[]GameInfo: 対局の情報
+*/
+func (s *Server) Games() []GameInfo {
+  var infos []GameInfo = []GameInfo{};
+  for _, id := range s.gameIDs() {
+    g, ok := s.Game(id);
+    if (!ok) { continue; }
+    // 対局の状態は所有するgoroutineが公開したものを読む
+    var info GameInfo = g.published.Load().info;
+    info.ID = id;
+    infos = append(infos, info);
+  }
+  return infos;
+}
+
+/*
+#gameIDs
+対局のIDの一覧をID順に取得
+
+*返り値
+[]string: 対局のID
+*/
+func (s *Server) gameIDs() []string {
+  s.mu.Lock();
+  defer s.mu.Unlock();
+
+  var ids []string = []string{};
+  for id := range s.games {
+    ids = append(ids, id);
+  }
+  sort.Slice(ids, func(i int, j int) bool {
+    a, _ := strconv.ParseUint(ids[i], 10, 64);
+    b, _ := strconv.ParseUint(ids[j], 10, 64);
+    return a < b;
+  });
+  return ids;
+}
@@ -0,0 +1,172 @@
+package game
+
+import "fmt"
+import "math"
+
+/*
+#sprt
+逐次確率比検定(SPRT)
+・「新エンジンは旧エンジンより少なくともelo1強い」(H1)と
+  「新エンジンの強さの差はelo0以下」(H0)を比較する
+・先後を入れ替えた2局を1ペアとし、ペアの得点(0, 0.5, 1, 1.5, 2)の分布(ペンタノミアル)から判定する
+・対数尤度比(LLR)は正規近似(GSPRT)により求める
+*/
+
+// SPRTの判定
+const (
+  SPRT_CONTINUE uint8 = 0 // 判定保留
+  SPRT_ACCEPT_H1 uint8 = 1 // H1採択(elo1以上強い)
+  SPRT_ACCEPT_H0 uint8 = 2 // H0採択(elo0以下)
+)
+
+// SPRTの設定と統計
+type SPRT struct {
+  Elo0 float64  // 帰無仮説のElo差
+  Elo1 float64  // 対立仮説のElo差
+  Alpha float64 // 第1種の過誤の確率
+  Beta float64  // 第2種の過誤の確率
+
+  Pentanomial [5]uint // ペアの得点ごとの回数(添字はペアの得点*2)
+}
+
+/*
+#Validate
+設定を検証する
+・Alpha、Betaは0より大きく1より小さいこと(判定境界の対数が有限となる)
+・Elo1はElo0より大きいこと(等しい場合LLRは常に0となり判定が出ない)
+
+*返り値
+error: 不正な設定のエラー
+*/
+func (s SPRT) Validate() error {
+  if (!(s.Alpha > 0 && s.Alpha < 1)) { return fmt.Errorf("SPRT alpha must be in (0, 1), got %g", s.Alpha); }
+  if (!(s.Beta > 0 && s.Beta < 1)) { return fmt.Errorf("SPRT beta must be in (0, 1), got %g", s.Beta); }
+  if (!(s.Elo1 > s.Elo0)) { return fmt.Errorf("SPRT elo1 must be greater than elo0, got %g <= %g", s.Elo1, s.Elo0); }
+  return nil;
+}
+
+/*
+#AddPair
+ペアの結果を加える
+
+*引数
+score uint8: ペアの得点を2倍した値(0~4)
+*/
+func (s *SPRT) AddPair(score uint8) {
+  if (score > 4) { return; }
+  (*s).Pentanomial[score]++;
+}
+
+/*
+#Pairs
+ペア数を返す
+
+*返り値
+uint: ペア数
+*/
+func (s SPRT) Pairs() uint {
+  var n uint = 0;
+  for _, c := range s.Pentanomial { n += c; }
+  return n;
+}
+
+/*
+#Bounds
+判定に用いるLLRの下限、上限を返す
+
+*返り値
+float64: 下限(これを下回るとH0採択)
+float64: 上限(これを上回るとH1採択)
+*/
+func (s SPRT) Bounds() (float64, float64) {
+  var lower float64 = math.Log(s.Beta / (1 - s.Alpha));
+  var upper float64 = math.Log((1 - s.Beta) / s.Alpha);
+  return lower, upper;
+}
+
+/*
+#LLR
+現在の統計における対数尤度比を求める
+
+*返り値
+float64: 対数尤度比
+
+mean float64    : ペアあたりの得点率の平均
+variance float64: ペアあたりの得点率の分散
+*/
+func (s SPRT) LLR() float64 {
+  if (s.Pairs() == 0) { return 0; }
+
+  // 全て同じ結果でも分散が0にならないよう、各得点に僅かな回数を加える
+  const EPSILON float64 = 1e-3;
+  var counts [5]float64;
+  var n float64 = 0;
+  for i, c := range s.Pentanomial {
+    counts[i] = float64(c) + EPSILON;
+    n += counts[i];
+  }
+
+  // ペアの得点率(0, 0.25, 0.5, 0.75, 1)の平均、分散
+  var mean float64 = 0;
+  for i, c := range counts {
+    mean += float64(i) / 4 * c;
+  }
+  mean /= n;
+
+  var variance float64 = 0;
+  for i, c := range counts {
+    variance += math.Pow(float64(i)/4 - mean, 2) * c;
+  }
+  variance /= n;
+
+  // 各仮説における期待得点率
+  var score0 float64 = eloToScore(s.Elo0);
+  var score1 float64 = eloToScore(s.Elo1);
+
+  return n * (score1 - score0) * (2*mean - score0 - score1) / (2 * variance);
+}
+
+/*
+#Status
+現在の判定を返す
+
+*返り値
+uint8: 判定(SPRT_CONTINUE, SPRT_ACCEPT_H1, SPRT_ACCEPT_H0)
+*/
+func (s SPRT) Status() uint8 {
+  var llr float64 = s.LLR();
+  lower, upper := s.Bounds();
+
+  if (llr >= upper) { return SPRT_ACCEPT_H1; }
+  if (llr <= lower) { return SPRT_ACCEPT_H0; }
+  return SPRT_CONTINUE;
+}
+
+/*
+#String
+統計を1行の文字列にする
+
+*返り値
+string: LLR、判定境界、ペア数、ペンタノミアル
+*/
+func (s SPRT) String() string {
+  lower, upper := s.Bounds();
+  return fmt.Sprintf(
+    "LLR: %.2f (%.2f, %.2f) [%.1f, %.1f] pairs: %d penta: %v",
+    s.LLR(), lower, upper, s.Elo0, s.Elo1, s.Pairs(), s.Pentanomial,
+  );
+}
+
+/*
+#eloToScore
+Elo差を期待得点率に変換する
+
+*引数
+elo float64: Elo差
+
+*返り値
+float64: 期待得点率(0~1)
+*/
+func eloToScore(elo float64) float64 {
+  return 1 / (1 + math.Pow(10, -elo/400));
+}
@@ -1,8 +1,15 @@
 package main
 
+import "os"
+import "fmt"
 import "flag"
+import "time"
+import "strings"
+import "path/filepath"
 
 import "voda/game"
+import "voda/board"
+import "voda/record"
 
 /*
 #Voda - Вода(Water)
@@ -11,30 +18,306 @@ import "voda/game"
 board --- 盤面
   board.go --- 盤面の操作
 
+record --- 棋譜
+  record.go --- 棋譜の書式、保存と読み込み
+
+archive --- 対局の保管庫
+  archive.go --- JSON Linesへの追記と検索
+
+protocol --- プレイヤーとの通信プロトコル
+  spec.go    --- プロトコルの仕様(バージョン)
+  frame.go   --- 改行区切りのメッセージの読み書き
+  message.go --- メッセージとParam、Retの相互変換
+  json.go    --- json機能のメッセージ、形式の切り替え
+
+archive_cmd.go --- 保管庫の検索コマンド(voda archive)
+
 game --- ゲーム
   game.go      --- ゲームの管理
-  connector.go --- ソケット通信の管理
+  player.go    --- プレイヤーのインターフェース、関数・人間のプレイヤー
+  connector.go --- ソケット通信によるプレイヤー
+  lobby.go     --- 1つのポートで接続を受け付けるロビー
+  process.go   --- 子プロセスとして起動するプレイヤー
+  errors.go    --- プレイヤーのエラーと不戦敗
   game_data.go --- ゲーム管理のための構造体等
   game_browser.go --- ブラウザ上でのゲーム実行
+  server.go    --- 複数の対局の管理
+  events.go    --- ブラウザへのイベントの配信
+  api.go       --- 対局ごとの資源を扱うJSONのAPI
+  assets.go    --- 埋め込んだ静的ファイル、テーマによる置き換え
+  match.go     --- エンジン同士の連続対局
+  sprt.go      --- 逐次確率比検定
+  opening.go   --- 開局集の読み込み
+
+engine --- vodaのプロセス内で動くエンジン
+  engine.go --- エンジンの一覧、ランダム
+  g0F.go    --- 乱択アルゴリズム
+  solver.go --- アルファベータ法による探索
+  analysis.go --- 局面の解析(ブラウザの解析モード)
 */
 
 func main() {
+  // 保管庫の検索
+  if (len(os.Args) > 1 && os.Args[1] == "archive") {
+    archiveCommand(os.Args[2:]);
+    return;
+  }
+
   // 実行時引数の取得
   var port *int = flag.Int("port", 8080, "port number");
+  var host *string = flag.String("host", "", "address to listen on for the browser (empty: all addresses)");
+  // --create-tokenで対局の生成に要するトークンを設定(空の場合はローカルホストからのみ生成できる)
+  var create_token *string = flag.String("create-token", "", "token required to create games from the browser or the API (empty: only from localhost)");
+  // --max-gamesで同時に保持する対局の上限を設定(0は制限しない)
+  var max_games *int = flag.Int("max-games", 16, "maximum number of games held at the same time (0: no limit)");
+  var theme_dir *string = flag.String("theme", "", "directory whose files override the embedded web assets");
   var black_port *int = flag.Int("port1", 8000, "port number for black");
   var white_port *int = flag.Int("port2", 8001, "port number for white");
 
+  // --lobbyで1つのポートで複数のプレイヤーを受け付け、組み合わせて対局を繰り返す(0は使わない)
+  var lobby_port *uint = flag.Uint("lobby", 0, "port number for the lobby (0: use --port1, --port2)");
+  // --gameでロビーの対局名を設定(プレイヤーはこの名前かanyを希望する)
+  var game_name *string = flag.String("game", "default", "game name that lobby players can ask for");
+
+  // 子プロセスとして起動するプレイヤー(指定した場合はポートより優先)
+  var black_command *string = flag.String("black", "", "command to launch the black (player1) engine");
+  var white_command *string = flag.String("white", "", "command to launch the white (player2) engine");
+  var log_dir *string = flag.String("engine-log", "log", "directory for engine stderr logs");
+  // --graceで切断されたプレイヤーの再接続(子プロセスは再起動)を待つ時間を設定(0は待たない)
+  var grace *time.Duration = flag.Duration("grace", 0, "grace period to wait for a disconnected player to reconnect or restart");
+  // --timeoutで子プロセスのプレイヤーが各コマンドに応答するまでの制限時間を設定(超えると切断として扱う、0は制限しない)
+  var timeout *time.Duration = flag.Duration("timeout", time.Minute, "time limit for an engine process to answer each command (0: no limit)");
+  // --engineでブラウザから選べる子プロセスのエンジンを追加(id=commandの形、繰り返し指定できる)
+  var engines engineFlags;
+  flag.Var(&engines, "engine", "engine that the browser can choose, as id=command (repeatable)");
+
   var show_board *bool = flag.Bool("board", true, "output the board or not");
   var show_result *bool = flag.Bool("result", true, "output the result or not")
 
   var cli *bool = flag.Bool("cli", false, "cli");
+
+  // 連続対局
+  var match *uint = flag.Uint("match", 0, "number of games in match mode");
+  var sprt *bool = flag.Bool("sprt", false, "stop the match by SPRT");
+  var elo0 *float64 = flag.Float64("elo0", 0, "SPRT elo0");
+  var elo1 *float64 = flag.Float64("elo1", 5, "SPRT elo1");
+  var alpha *float64 = flag.Float64("alpha", 0.05, "SPRT alpha");
+  var beta *float64 = flag.Float64("beta", 0.05, "SPRT beta");
+  var openings_path *string = flag.String("openings", "", "opening file for match mode");
+
+  // 棋譜
+  var record_path *string = flag.String("record", "", "file to append game records");
+  var load_path *string = flag.String("load", "", "print game records in the file");
+  var archive_path *string = flag.String("archive", "", "JSON lines file to archive finished games");
   flag.Parse();
 
-  var g game.Game;
+  // SPRTの設定の検証(ペアの揃わない最後の1局は判定に使えないため、対局数は偶数とする)
+  var sprt_config game.SPRT = game.SPRT{ Elo0: *elo0, Elo1: *elo1, Alpha: *alpha, Beta: *beta };
+  if (*sprt) {
+    if err := sprt_config.Validate(); err != nil {
+      fmt.Println(err);
+      return;
+    }
+    if (*match % 2 != 0) {
+      fmt.Println(fmt.Sprintf("--match must be even with --sprt (games are played in pairs), got %d", *match));
+      return;
+    }
+  }
+
+  // 棋譜の表示のみ行う
+  if (*load_path != "") {
+    printRecords(*load_path);
+    return;
+  }
+
+  // 開局集の読み込み(連続対局でのみ用いる)
+  var openings [][]uint8;
+  if (*openings_path != "" && *match == 0 && !*sprt) {
+    fmt.Println("--openings is only used in match mode (--match or --sprt)");
+    return;
+  }
+  if (*openings_path != "") {
+    var err error;
+    openings, err = game.LoadOpenings(*openings_path);
+    if (err != nil) {
+      fmt.Println(err);
+      return;
+    }
+  }
+
+  // 対局の実行
+  var play func(black game.Player, white game.Player) = func(black game.Player, white game.Player) {
+    var g game.Game;
+    g.RecordPath = *record_path;
+    g.ArchivePath = *archive_path;
+
+    if (*match != 0 || *sprt) {
+      g.StartMatch(black, white, game.MatchConfig {
+        Games: *match,
+        UseSPRT: *sprt,
+        SPRT: sprt_config,
+        ShowBoard: *show_board,
+        ShowResult: *show_result,
+        Openings: openings,
+      });
+    } else if (*cli) {
+      g.StartCLI(black, white, *show_board, *show_result);
+    }
+  };
+  var is_browser bool = *match == 0 && !*sprt && !*cli;
+
+  // ブラウザでの対局は複数の対局を管理するサーバで行う
+  var server *game.Server = game.NewServer();
+  server.RecordPath = *record_path;
+  server.ArchivePath = *archive_path;
+  server.ProcessEngines = engines;
+  server.EngineLogDir = *log_dir;
+  server.Grace = *grace;
+  server.Timeout = *timeout;
+  server.Host = *host;
+  server.CreateToken = *create_token;
+  server.MaxGames = *max_games;
+  server.ThemeDir = *theme_dir;
+
+  // ロビーで組み合わせたプレイヤー同士の対局を繰り返す
+  // ブラウザの場合は対局の生成時にロビーからプレイヤーを取り出す
+  if (*lobby_port != 0) {
+    lobby, err := game.NewLobby(uint(*lobby_port), *grace);
+    if (err != nil) {
+      fmt.Println(err);
+      return;
+    }
+    defer lobby.Close();
+
+    if (is_browser) {
+      server.Lobby = lobby;
+      server.LobbyGame = *game_name;
+      if err := server.StartBrowser(uint(*port)); err != nil {
+        fmt.Println(err);
+      }
+      return;
+    }
+
+    for {
+      black, white, err := lobby.Pair(*game_name);
+      if (err != nil) {
+        fmt.Println(err);
+        return;
+      }
+      play(black, white);
+    }
+  }
+
+  // プレイヤーの生成
+  black, err := newPlayer(uint(*black_port), *black_command, filepath.Join(*log_dir, "black.log"), *grace, *timeout);
+  if (err != nil) {
+    fmt.Println(err);
+    return;
+  }
+  white, err := newPlayer(uint(*white_port), *white_command, filepath.Join(*log_dir, "white.log"), *grace, *timeout);
+  if (err != nil) {
+    fmt.Println(err);
+    // 起動済みの子プロセスは終了させる(ソケットは接続を待っているため触れない)
+    if p, ok := black.(*game.ProcessPlayer); ok { p.Quit(); }
+    return;
+  }
+
+  if (is_browser) {
+    // 待ち受けを開始してから、実際のアドレスでURLを表示する
+    url, err := server.ListenBrowser(uint(*port));
+    if (err != nil) {
+      fmt.Println(err);
+      return;
+    }
+    created, err := server.CreateGame(black, white);
+    if (err != nil) {
+      fmt.Println(err);
+      return;
+    }
+    fmt.Println(server.CreateURL(url));
+    // 操作用のトークンを含むURL(トークンのないURLでは観戦のみ)、人間の席に参加するURL
+    fmt.Println(fmt.Sprintf("game %s: %s/?game=%s&token=%s&join=%s", created.ID, url, created.ID, created.Token, created.Code));
+    fmt.Println(fmt.Sprintf("join %s: %s/?game=%s&join=%s", created.ID, url, created.ID, created.Code));
+    if err := server.ServeBrowser(); err != nil {
+      fmt.Println(err);
+    }
+    return;
+  }
+  play(black, white);
+}
+
+/*
+#engineFlags
+--engineで指定された子プロセスのエンジン
+*/
+type engineFlags []game.ProcessEngine
+
+func (f *engineFlags) String() string {
+  var specs []string;
+  for _, e := range *f {
+    specs = append(specs, e.ID + "=" + e.Command);
+  }
+  return strings.Join(specs, ", ");
+}
+
+func (f *engineFlags) Set(value string) error {
+  id, command, ok := strings.Cut(value, "=");
+  if (!ok || id == "" || strings.TrimSpace(command) == "") {
+    return fmt.Errorf("expected id=command, got `%s`", value);
+  }
+  *f = append(*f, game.ProcessEngine{ ID: id, Command: command });
+  return nil;
+}
+
+/*
+#newPlayer
+実行時引数からプレイヤーを生成
+・コマンドが指定された場合は子プロセスとして起動する
+・ポート番号0はブラウザ上の人間とする
+
+*引数
+port uint      : ポート番号
+command string : 起動するコマンド
+log_path string: 子プロセスの標準エラー出力のログファイル
+grace time.Duration: 切断されたプレイヤーの再接続、再起動を待つ時間
+timeout time.Duration: 子プロセスのプレイヤーが各コマンドに応答するまでの制限時間
+
+*返り値
+game.Player: プレイヤー
+error      : 子プロセスの起動、接続の待ち受けのエラー
+*/
+func newPlayer(port uint, command string, log_path string, grace time.Duration, timeout time.Duration) (game.Player, error) {
+  if (command != "") {
+    return game.NewProcessPlayer(command, log_path, grace, timeout);
+  }
+  if (port == 0) {
+    return game.NewHumanPlayer(), nil;
+  }
+  return game.NewSocketPlayer(port, grace);
+}
+
+/*
+#printRecords
+棋譜ファイルを読み込み、各対局の棋譜と最終局面を出力
+
+*引数
+path string: 棋譜ファイル
+*/
+func printRecords(path string) {
+  records, err := record.Load(path);
+  if (err != nil) {
+    fmt.Println(err);
+    return;
+  }
+
+  for _, r := range records {
+    fmt.Print(r.String());
 
-  if (*cli) {
-    g.StartCLI(uint(*black_port), uint(*white_port), *show_board, *show_result);
-  }else {
-    g.StartBrowser(uint(*port), uint(*black_port), uint(*white_port), *show_board, *show_result);
+    black_stones, white_stones, err := r.Position(-1);
+    if (err != nil) {
+      fmt.Println(err);
+    }
+    board.PrintBoard(black_stones, white_stones);
+    fmt.Println();
   }
 }
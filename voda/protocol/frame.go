@@ -0,0 +1,185 @@
+package protocol
+
+import "io"
+import "fmt"
+import "bufio"
+import "errors"
+import "strings"
+import "unicode/utf8"
+
+/*
+#frame
+メッセージの区切り(フレーミング)
+・1メッセージは改行(\n)で終端する1行(\r\nも受け付ける)
+・1行の長さはMAX_LINE_LENGTHまで、超えた行は読み捨ててエラーとする
+・ゲーム、プレイヤーの双方が用いる
+*/
+
+// 1行の最大長(改行を除く)
+const MAX_LINE_LENGTH int = 4096;
+
+// 1行が長すぎる
+var ErrLineTooLong error = errors.New("protocol: line too long");
+
+/*
+#ParseError
+メッセージの解釈のエラー
+
+Message string: 解釈しようとしたメッセージ
+Reason string : 理由
+*/
+type ParseError struct {
+  Message string
+  Reason string
+}
+
+func (e *ParseError) Error() string {
+  return fmt.Sprintf("protocol: %s: `%s`", e.Reason, e.Message);
+}
+
+/*
+#Reader
+改行で区切られたメッセージを読む
+*/
+type Reader struct {
+  reader *bufio.Reader
+  max int // 1行の最大長
+}
+
+/*
+#NewReader
+Readerを生成
+
+*引数
+reader io.Reader: 入力
+
+*返り値
+*Reader: 生成したReader
+*/
+func NewReader(reader io.Reader) *Reader {
+  return &Reader{ reader: bufio.NewReaderSize(reader, MAX_LINE_LENGTH + 2), max: MAX_LINE_LENGTH };
+}
+
+/*
+#ReadMessage
+メッセージを1つ読む
+
+*返り値
+string: 改行を除いたメッセージ
+error : 読み込みのエラー
+  io.EOF         : 入力が終わった
+  ErrLineTooLong : 行が長すぎる(次の行から読み直せる)
+  *ParseError    : UTF-8として不正、又は制御文字を含む
+*/
+func (r *Reader) ReadMessage() (string, error) {
+  var line []byte;
+  var too_long bool = false;
+
+  for {
+    chunk, err := r.reader.ReadSlice('\n');
+    if (!too_long) {
+      line = append(line, chunk...);
+      if (len(line) > r.max + 2) {
+        // 改行までを読み捨てる
+        too_long = true;
+        line = nil;
+      }
+    }
+
+    if (err == bufio.ErrBufferFull) { continue; }
+    if (err != nil) {
+      // 改行なしで終わった最後の行は捨てる
+      if (err == io.EOF && len(line) != 0 && !too_long) {
+        return "", io.ErrUnexpectedEOF;
+      }
+      return "", err;
+    }
+    break;
+  }
+
+  if (too_long) { return "", ErrLineTooLong; }
+
+  var msg string = strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r");
+  if (len(msg) > r.max) { return "", ErrLineTooLong; }
+
+  if err := validateMessage(msg); err != nil { return "", err; }
+
+  return msg, nil;
+}
+
+/*
+#WaitInput
+入力が届くまで待つ
+・届いた入力は消費せず、次のReadMessageで読む
+・待っている間の切断を検知するために用いる
+
+*返り値
+error: 読み込みのエラー(io.EOFなど、nilは入力が届いた)
+*/
+func (r *Reader) WaitInput() error {
+  _, err := r.reader.Peek(1);
+  return err;
+}
+
+/*
+#Writer
+メッセージを改行で区切って書く
+*/
+type Writer struct {
+  writer io.Writer
+}
+
+/*
+#NewWriter
+Writerを生成
+
+*引数
+writer io.Writer: 出力
+
+*返り値
+*Writer: 生成したWriter
+*/
+func NewWriter(writer io.Writer) *Writer {
+  return &Writer{ writer: writer };
+}
+
+/*
+#WriteMessage
+メッセージを1つ書く
+・改行を付けて1回の書き込みで送る
+
+*引数
+msg string: 改行を含まないメッセージ
+
+*返り値
+error: 書き込みのエラー、又はメッセージが不正な場合の*ParseError、ErrLineTooLong
+*/
+func (w *Writer) WriteMessage(msg string) error {
+  if (len(msg) > MAX_LINE_LENGTH) { return ErrLineTooLong; }
+  if err := validateMessage(msg); err != nil { return err; }
+
+  _, err := io.WriteString(w.writer, msg + "\n");
+  return err;
+}
+
+/*
+#validateMessage
+メッセージが1行として正当か検証する
+
+*引数
+msg string: メッセージ
+
+*返り値
+error: 不正な場合の*ParseError
+*/
+func validateMessage(msg string) error {
+  if (!utf8.ValidString(msg)) {
+    return &ParseError{ Message: msg, Reason: "invalid utf-8" };
+  }
+  for _, c := range msg {
+    if (c < 0x20 && c != '\t') {
+      return &ParseError{ Message: msg, Reason: "control character" };
+    }
+  }
+  return nil;
+}
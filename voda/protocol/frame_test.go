@@ -0,0 +1,83 @@
+package protocol
+
+import "io"
+import "errors"
+import "strings"
+import "testing"
+
+/*
+#frame_test
+メッセージの区切りの検証
+・不正な行が*ParseError、長すぎる行がErrLineTooLongとなること
+*/
+
+/*
+#TestReadMessage
+改行で区切られたメッセージを読めること(\r\nも受け付ける)
+不正な行は*ParseError、改行のない最後の行はio.ErrUnexpectedEOFとなること
+*/
+func TestReadMessage(t *testing.T) {
+  var reader *Reader = NewReader(strings.NewReader("ready\r\nmove 3\nbad\x01line\nbad\xffutf8\nmove 4\npartial"));
+
+  for _, want := range []string{ "ready", "move 3" } {
+    msg, err := reader.ReadMessage();
+    if (err != nil || msg != want) { t.Errorf("ReadMessage: got %q, %v, want %q", msg, err, want); }
+  }
+  for i := 0; i < 2; i++ {
+    if _, err := reader.ReadMessage(); !errors.As(err, new(*ParseError)) {
+      t.Errorf("ReadMessage of an invalid line: got %v, want *ParseError", err);
+    }
+  }
+  if msg, err := reader.ReadMessage(); (err != nil || msg != "move 4") {
+    t.Errorf("ReadMessage after invalid lines: got %q, %v", msg, err);
+  }
+  if _, err := reader.ReadMessage(); (err != io.ErrUnexpectedEOF) {
+    t.Errorf("ReadMessage of a partial line: got %v, want io.ErrUnexpectedEOF", err);
+  }
+  if _, err := reader.ReadMessage(); (err != io.EOF) {
+    t.Errorf("ReadMessage at the end: got %v, want io.EOF", err);
+  }
+
+  var sb strings.Builder;
+  if err := NewWriter(&sb).WriteMessage("bad\nline"); !errors.As(err, new(*ParseError)) {
+    t.Errorf("WriteMessage with a newline: got %v, want *ParseError", err);
+  }
+}
+
+/*
+#TestLineTooLong
+MAX_LINE_LENGTHまでの行は読み書きでき、超える行はErrLineTooLongとなること
+・長すぎる行は読み捨て、次の行から読み直せること
+*/
+func TestLineTooLong(t *testing.T) {
+  var max string = strings.Repeat("a", MAX_LINE_LENGTH);
+  var over string = max + "a";
+  var long string = strings.Repeat("b", MAX_LINE_LENGTH * 3);
+
+  var reader *Reader = NewReader(strings.NewReader(max + "\n" + over + "\n" + max + "\r\n" + long + "\nready\n"));
+  if msg, err := reader.ReadMessage(); (err != nil || msg != max) {
+    t.Errorf("ReadMessage of MAX_LINE_LENGTH: got %d bytes, %v", len(msg), err);
+  }
+  if _, err := reader.ReadMessage(); (err != ErrLineTooLong) {
+    t.Errorf("ReadMessage of MAX_LINE_LENGTH+1: got %v, want ErrLineTooLong", err);
+  }
+  if msg, err := reader.ReadMessage(); (err != nil || msg != max) {
+    t.Errorf("ReadMessage of MAX_LINE_LENGTH with \\r\\n: got %d bytes, %v", len(msg), err);
+  }
+  if _, err := reader.ReadMessage(); (err != ErrLineTooLong) {
+    t.Errorf("ReadMessage of a line over the buffer: got %v, want ErrLineTooLong", err);
+  }
+  if msg, err := reader.ReadMessage(); (err != nil || msg != "ready") {
+    t.Errorf("ReadMessage after a long line: got %q, %v", msg, err);
+  }
+
+  var sb strings.Builder;
+  var writer *Writer = NewWriter(&sb);
+  if err := writer.WriteMessage(max); (err != nil) {
+    t.Errorf("WriteMessage of MAX_LINE_LENGTH: %v", err);
+  }
+  if err := writer.WriteMessage(over); (err != ErrLineTooLong) {
+    t.Errorf("WriteMessage of MAX_LINE_LENGTH+1: got %v, want ErrLineTooLong", err);
+  }
+  if (sb.String() != max + "\n") { t.Errorf("WriteMessage wrote %d bytes", sb.Len()); }
+}
@@ -0,0 +1,430 @@
+package protocol
+
+import "fmt"
+import "time"
+import "encoding/json"
+
+/*
+#json
+json機能で用いるJSON形式のメッセージとParam、Retの相互変換
+・1メッセージは1行のJSONオブジェクト
+・commandにコマンド名を入れ、残りはコマンドごとのフィールド
+・受信側は未知のフィールドを無視する
+*/
+
+// ゲーム -> プレイヤーのJSONメッセージ
+type jsonParam struct {
+  Command string `json:"command"`
+
+  // start
+  Turn string `json:"turn,omitempty"` // black|white
+
+  // go
+  Stones *uint64 `json:"stones,omitempty"`
+  OppStones *uint64 `json:"opp_stones,omitempty"`
+  Moves []int `json:"moves,omitempty"`
+  ValidMoves []int `json:"valid_moves,omitempty"`
+  Clock int64 `json:"clock_ms,omitempty"`         // 残り時間(ミリ秒、省略時は無制限)
+  OppClock int64 `json:"opp_clock_ms,omitempty"` // 相手の残り時間(ミリ秒、省略時は無制限)
+  Rules string `json:"rules,omitempty"`
+
+  // end
+  Result string `json:"result,omitempty"` // win|lose|draw
+  Reason string `json:"reason,omitempty"`
+
+  // undo(movesはgoと共用)
+  Count int `json:"count,omitempty"` // 取り消した手数
+}
+
+// undoのJSONメッセージ(送信用、空の履歴も出力する)
+type jsonUndo struct {
+  Command string `json:"command"`
+  Count int `json:"count"`
+  Moves []int `json:"moves"`
+}
+
+// goのJSONメッセージ(送信用、空の一覧も出力する)
+type jsonGo struct {
+  Command string `json:"command"`
+  Stones uint64 `json:"stones"`
+  OppStones uint64 `json:"opp_stones"`
+  Moves []int `json:"moves"`
+  ValidMoves []int `json:"valid_moves"`
+  Clock int64 `json:"clock_ms,omitempty"`
+  OppClock int64 `json:"opp_clock_ms,omitempty"`
+  Rules string `json:"rules,omitempty"`
+}
+
+// プレイヤー -> ゲームのJSONメッセージ
+type jsonRet struct {
+  Command string `json:"command"`
+
+  // move(score, pvはinfoと共用)
+  Move *uint8 `json:"move,omitempty"`
+  Score *int `json:"score,omitempty"`
+  PV []int `json:"pv,omitempty"`
+  Comment string `json:"comment,omitempty"`
+
+  // info
+  Depth int `json:"depth,omitempty"`
+  Nodes int64 `json:"nodes,omitempty"`
+  Rates []*float64 `json:"rates,omitempty"` // 列ごとの勝率(未評価、置けない列はnull)
+}
+
+/*
+#EncodeParamJSON
+ParamをJSONメッセージに変換する
+・nameはバージョンと機能の確認に用いるため、常にテキスト形式(EncodeParam)とする
+
+*引数
+param Param: 変換するParam
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func EncodeParamJSON(param Param) (string, error) {
+  var msg jsonParam = jsonParam{ Command: param.Command };
+
+  switch param.Command {
+  case CMD_START:
+    msg.Turn = "white";
+    if (param.Turn) { msg.Turn = "black"; }
+  case CMD_GO:
+    // 一覧を空でも省略しないよう、jsonGoで出力する
+  case CMD_END:
+    switch param.Result {
+    case RESULT_WIN: msg.Result = "win";
+    case RESULT_LOSE: msg.Result = "lose";
+    case RESULT_DRAW: msg.Result = "draw";
+    default:
+      return "", &ParseError{ Message: fmt.Sprint(param.Result), Reason: "invalid result" };
+    }
+    msg.Reason = param.Reason;
+  case CMD_UNDO:
+    // 履歴を空でも省略しないよう、jsonUndoで出力する
+  case CMD_QUIT:
+  default:
+    return "", &ParseError{ Message: param.Command, Reason: "unknown command" };
+  }
+
+  var data []byte;
+  var err error;
+  if (param.Command == CMD_GO) {
+    data, err = json.Marshal(jsonGo{
+      Command: CMD_GO,
+      Stones: param.Stones,
+      OppStones: param.OppStones,
+      Moves: colsToInts(param.Moves),
+      ValidMoves: colsToInts(param.ValidMoves),
+      Clock: param.Clock.Milliseconds(),
+      OppClock: param.OppClock.Milliseconds(),
+      Rules: param.Rules,
+    });
+  } else if (param.Command == CMD_UNDO) {
+    data, err = json.Marshal(jsonUndo{
+      Command: CMD_UNDO,
+      Count: param.Undone,
+      Moves: colsToInts(param.Moves),
+    });
+  } else {
+    data, err = json.Marshal(msg);
+  }
+  if (err != nil) { return "", &ParseError{ Message: param.Command, Reason: err.Error() }; }
+  return string(data), nil;
+}
+
+/*
+#DecodeParamJSON
+JSONメッセージをParamに変換する
+
+*引数
+msg string: ゲームから受け取ったメッセージ
+
+*返り値
+Param: 変換したParam
+error: 解釈できない場合の*ParseError
+*/
+func DecodeParamJSON(msg string) (Param, error) {
+  var data jsonParam;
+  if err := json.Unmarshal([]byte(msg), &data); err != nil {
+    return Param{}, &ParseError{ Message: msg, Reason: "invalid json" };
+  }
+  var param Param = Param{ Command: data.Command };
+
+  switch param.Command {
+  case CMD_QUIT:
+    return param, nil;
+  case CMD_START:
+    switch data.Turn {
+    case "black": param.Turn = true;
+    case "white": param.Turn = false;
+    default: return param, &ParseError{ Message: msg, Reason: "invalid turn" };
+    }
+    return param, nil;
+  case CMD_GO:
+    if (data.Stones == nil || data.OppStones == nil) {
+      return param, &ParseError{ Message: msg, Reason: "missing stones" };
+    }
+    param.Stones = *data.Stones;
+    param.OppStones = *data.OppStones;
+    var err error;
+    if param.Moves, err = intsToCols(data.Moves); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid moves" };
+    }
+    if param.ValidMoves, err = intsToCols(data.ValidMoves); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid valid moves" };
+    }
+    param.Clock = time.Duration(data.Clock) * time.Millisecond;
+    param.OppClock = time.Duration(data.OppClock) * time.Millisecond;
+    param.Rules = data.Rules;
+    return param, nil;
+  case CMD_END:
+    switch data.Result {
+    case "win": param.Result = RESULT_WIN;
+    case "lose": param.Result = RESULT_LOSE;
+    case "draw": param.Result = RESULT_DRAW;
+    default: return param, &ParseError{ Message: msg, Reason: "invalid result" };
+    }
+    param.Reason = data.Reason;
+    return param, nil;
+  case CMD_UNDO:
+    if (data.Count < 1) { return param, &ParseError{ Message: msg, Reason: "invalid count" }; }
+    param.Undone = data.Count;
+    var err error;
+    if param.Moves, err = intsToCols(data.Moves); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid moves" };
+    }
+    return param, nil;
+  }
+
+  return param, &ParseError{ Message: msg, Reason: "unknown command" };
+}
+
+/*
+#EncodeRetJSON
+RetをJSONメッセージに変換する
+・setnameはバージョンと機能の確認に用いるため、常にテキスト形式(EncodeRet)とする
+
+*引数
+ret Ret: 変換するRet
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func EncodeRetJSON(ret Ret) (string, error) {
+  var msg jsonRet = jsonRet{ Command: ret.Command };
+
+  switch ret.Command {
+  case CMD_READY, CMD_BYE:
+  case CMD_MOVE:
+    msg.Move = &ret.Move;
+    msg.Score = ret.Score;
+    msg.PV = colsToInts(ret.PV);
+    msg.Comment = ret.Comment;
+  case CMD_INFO:
+    msg.Depth = ret.Depth;
+    msg.Score = ret.Score;
+    msg.Nodes = ret.Nodes;
+    msg.PV = colsToInts(ret.PV);
+    msg.Rates = ratesToJSON(ret.Rates);
+  default:
+    return "", &ParseError{ Message: ret.Command, Reason: "unknown command" };
+  }
+
+  data, err := json.Marshal(msg);
+  if (err != nil) { return "", &ParseError{ Message: ret.Command, Reason: err.Error() }; }
+  return string(data), nil;
+}
+
+/*
+#DecodeRetJSON
+JSONメッセージをRetに変換する
+
+*引数
+msg string: プレイヤーから受け取ったメッセージ
+
+*返り値
+Ret  : 変換したRet
+error: 解釈できない場合の*ParseError
+*/
+func DecodeRetJSON(msg string) (Ret, error) {
+  var data jsonRet;
+  if err := json.Unmarshal([]byte(msg), &data); err != nil {
+    return Ret{}, &ParseError{ Message: msg, Reason: "invalid json" };
+  }
+  var ret Ret = Ret{ Command: data.Command };
+
+  switch ret.Command {
+  case CMD_READY:
+    ret.Ready = true;
+    return ret, nil;
+  case CMD_MOVE:
+    if (data.Move == nil) { return ret, &ParseError{ Message: msg, Reason: "missing move" }; }
+    ret.Move = *data.Move;
+    ret.Score = data.Score;
+    var err error;
+    if ret.PV, err = intsToCols(data.PV); err != nil {
+      return ret, &ParseError{ Message: msg, Reason: "invalid pv" };
+    }
+    ret.Comment = data.Comment;
+    return ret, nil;
+  case CMD_BYE:
+    return ret, nil;
+  case CMD_INFO:
+    ret.Depth = data.Depth;
+    ret.Score = data.Score;
+    ret.Nodes = data.Nodes;
+    var err error;
+    if ret.PV, err = intsToCols(data.PV); err != nil {
+      return ret, &ParseError{ Message: msg, Reason: "invalid pv" };
+    }
+    if ret.Rates, err = ratesFromJSON(data.Rates); err != nil {
+      return ret, &ParseError{ Message: msg, Reason: "invalid rates" };
+    }
+    return ret, nil;
+  }
+
+  return ret, &ParseError{ Message: msg, Reason: "unknown command" };
+}
+
+/*
+#colsToInts
+列の一覧をJSONの数値配列にする
+・[]uint8はJSONで文字列(base64)となるため、[]intに変換する
+
+*引数
+cols []uint8: 列の一覧
+
+*返り値
+[]int: 数値の一覧
+*/
+func colsToInts(cols []uint8) []int {
+  var ints []int = make([]int, len(cols));
+  for i, col := range cols { ints[i] = int(col); }
+  return ints;
+}
+
+/*
+#intsToCols
+JSONの数値配列を列の一覧にする
+
+*引数
+ints []int: 数値の一覧
+
+*返り値
+[]uint8: 列の一覧
+error  : 列として扱えない値を含む場合のエラー
+*/
+func intsToCols(ints []int) ([]uint8, error) {
+  var cols []uint8 = make([]uint8, len(ints));
+  for i, v := range ints {
+    if (v < 0 || v > 255) { return nil, fmt.Errorf("invalid column `%d`", v); }
+    cols[i] = uint8(v);
+  }
+  return cols, nil;
+}
+
+/*
+#ratesToJSON
+列ごとの勝率をJSONの配列にする
+・未評価、置けない列(負の値)はnullとする
+
+*引数
+rates []float64: 列ごとの勝率
+
+*返り値
+[]*float64: JSONの配列(空の場合はnilで省略される)
+*/
+func ratesToJSON(rates []float64) []*float64 {
+  if (len(rates) == 0) { return nil; }
+  var values []*float64 = make([]*float64, len(rates));
+  for i := range rates {
+    if (rates[i] >= 0) { values[i] = &rates[i]; }
+  }
+  return values;
+}
+
+/*
+#ratesFromJSON
+JSONの配列を列ごとの勝率にする
+
+*引数
+values []*float64: JSONの配列(nullは未評価、置けない列)
+
+*返り値
+[]float64: 列ごとの勝率(nullは-1)
+error    : 0~1の範囲外の値を含む場合のエラー
+*/
+func ratesFromJSON(values []*float64) ([]float64, error) {
+  var rates []float64 = make([]float64, len(values));
+  for i, v := range values {
+    rates[i] = -1;
+    if (v == nil) { continue; }
+    if (*v < 0 || *v > 1) { return nil, fmt.Errorf("invalid rate `%g`", *v); }
+    rates[i] = *v;
+  }
+  return rates, nil;
+}
+
+/*
+#Codec
+双方が対応する機能に応じてメッセージの形式を選ぶ
+・json機能に対応する場合はname/setname以外をJSON形式とする
+・Legacyの場合はテキスト形式の空の一覧を空の単語とする
+*/
+type Codec struct {
+  JSON bool   // JSON形式を用いるか
+  Legacy bool // 以前の形式で空の一覧を送るか
+}
+
+/*
+#NewCodec
+確認した機能からCodecを生成する
+
+*引数
+caps []string: 双方が対応する機能
+
+*返り値
+Codec: メッセージの形式
+*/
+func NewCodec(caps []string) Codec {
+  return Codec{ JSON: HasCapability(caps, CAP_JSON) };
+}
+
+func (c Codec) EncodeParam(param Param) (string, error) {
+  if (c.JSON && param.Command != CMD_NAME) { return EncodeParamJSON(param); }
+  if (c.Legacy) { return EncodeLegacyParam(param); }
+  return EncodeParam(param);
+}
+
+func (c Codec) DecodeParam(msg string) (Param, error) {
+  if (c.JSON && !isTextCommand(msg, CMD_NAME)) { return DecodeParamJSON(msg); }
+  return DecodeParam(msg);
+}
+
+func (c Codec) EncodeRet(ret Ret) (string, error) {
+  if (c.JSON && ret.Command != CMD_SETNAME) { return EncodeRetJSON(ret); }
+  return EncodeRet(ret);
+}
+
+func (c Codec) DecodeRet(msg string) (Ret, error) {
+  if (c.JSON && !isTextCommand(msg, CMD_SETNAME)) { return DecodeRetJSON(msg); }
+  return DecodeRet(msg);
+}
+
+/*
+#isTextCommand
+テキスト形式の指定のコマンドか判定する
+
+*引数
+msg string: メッセージ
+cmd string: コマンド
+
+*返り値
+bool: 指定のコマンドか
+*/
+func isTextCommand(msg string, cmd string) bool {
+  return msg == cmd || (len(msg) > len(cmd) && msg[:len(cmd)+1] == cmd + " ");
+}
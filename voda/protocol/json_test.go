@@ -0,0 +1,123 @@
+package protocol
+
+import "time"
+import "errors"
+import "reflect"
+import "strings"
+import "testing"
+
+/*
+#json_test
+JSON形式のメッセージの変換の検証
+・すべてのメッセージが往復で一致すること(name/setnameはテキスト形式)
+・不正なメッセージが*ParseErrorとなること
+*/
+
+// 往復で一致すべきParam(JSON形式、nameはテキスト形式となる)
+var json_params []Param = []Param{
+  { Command: CMD_START, Turn: true },
+  { Command: CMD_START, Turn: false },
+  {
+    Command: CMD_GO, Stones: 1 << 21, OppStones: 3,
+    ValidMoves: []uint8{ 0, 1, 2, 3, 4, 5, 6 }, Moves: []uint8{ 3, 3, 4, 4 },
+    Clock: 90 * time.Second, OppClock: 1500 * time.Millisecond, Rules: "7x6",
+  },
+  { Command: CMD_GO, ValidMoves: []uint8{ 0, 1, 2, 3, 4, 5, 6 }, Moves: []uint8{} },
+  { Command: CMD_END, Result: RESULT_WIN, Reason: "alignment" },
+  { Command: CMD_END, Result: RESULT_LOSE },
+  { Command: CMD_END, Result: RESULT_DRAW, Reason: "full" },
+  { Command: CMD_UNDO, Undone: 2, Moves: []uint8{ 3, 2 } },
+  { Command: CMD_UNDO, Undone: 1, Moves: []uint8{} },
+  { Command: CMD_QUIT },
+};
+
+// 往復で一致すべきRet(JSON形式、setnameはテキスト形式となる)
+var json_rets []Ret = []Ret{
+  { Command: CMD_READY, Ready: true },
+  { Command: CMD_MOVE, Move: 6, PV: []uint8{} },
+  { Command: CMD_MOVE, Move: 0, Score: &test_score, PV: []uint8{ 0, 1 }, Comment: "playout 300/500 {a}" },
+  { Command: CMD_BYE },
+  { Command: CMD_INFO, Depth: 8, Score: &test_score, Nodes: 3500, PV: []uint8{ 3, 3, 4 }, Rates: []float64{ 0.5, -1, 0.25, 1, -1, -1, -1 } },
+  { Command: CMD_INFO, PV: []uint8{}, Rates: []float64{} },
+};
+
+/*
+#TestJSONParamRoundTrip
+JSON形式のParamが往復で一致すること(Codecを介し、nameはテキスト形式)
+*/
+func TestJSONParamRoundTrip(t *testing.T) {
+  var codec Codec = NewCodec([]string{ CAP_JSON });
+  var params []Param = append([]Param{ text_params[0], text_params[1] }, json_params...);
+  for _, param := range params {
+    msg, err := codec.EncodeParam(param);
+    if (err != nil) {
+      t.Errorf("EncodeParam(%+v): %v", param, err);
+      continue;
+    }
+    if (param.Command != CMD_NAME && !strings.HasPrefix(msg, "{")) {
+      t.Errorf("EncodeParam(%+v) = %q, want JSON", param, msg);
+    }
+    got, err := codec.DecodeParam(msg);
+    if (err != nil) {
+      t.Errorf("DecodeParam(%q): %v", msg, err);
+      continue;
+    }
+    if (!reflect.DeepEqual(got, param)) {
+      t.Errorf("round trip of %q: got %+v, want %+v", msg, got, param);
+    }
+  }
+}
+
+/*
+#TestJSONRetRoundTrip
+JSON形式のRetが往復で一致すること(Codecを介し、setnameはテキスト形式)
+*/
+func TestJSONRetRoundTrip(t *testing.T) {
+  var codec Codec = NewCodec([]string{ CAP_JSON });
+  var rets []Ret = append([]Ret{ text_rets[0], text_rets[1], text_rets[2] }, json_rets...);
+  for _, ret := range rets {
+    msg, err := codec.EncodeRet(ret);
+    if (err != nil) {
+      t.Errorf("EncodeRet(%+v): %v", ret, err);
+      continue;
+    }
+    if (ret.Command != CMD_SETNAME && !strings.HasPrefix(msg, "{")) {
+      t.Errorf("EncodeRet(%+v) = %q, want JSON", ret, msg);
+    }
+    got, err := codec.DecodeRet(msg);
+    if (err != nil) {
+      t.Errorf("DecodeRet(%q): %v", msg, err);
+      continue;
+    }
+    if (!reflect.DeepEqual(got, ret)) {
+      t.Errorf("round trip of %q: got %+v, want %+v", msg, got, ret);
+    }
+  }
+}
+
+/*
+#TestJSONParseError
+不正なJSONメッセージが*ParseErrorとなること
+*/
+func TestJSONParseError(t *testing.T) {
+  var params []string = []string{
+    "{", "[]", `{"command":"hello"}`, `{"command":"start","turn":"red"}`, `{"command":"go"}`,
+    `{"command":"go","stones":0,"opp_stones":0,"moves":[-1]}`, `{"command":"end","result":"x"}`,
+    `{"command":"undo","count":0}`,
+  };
+  for _, msg := range params {
+    if _, err := DecodeParamJSON(msg); !errors.As(err, new(*ParseError)) {
+      t.Errorf("DecodeParamJSON(%q): got %v, want *ParseError", msg, err);
+    }
+  }
+
+  var rets []string = []string{
+    "{", `{"command":"hello"}`, `{"command":"move"}`, `{"command":"move","move":300}`,
+    `{"command":"move","move":1,"pv":[-1]}`, `{"command":"info","rates":[1.5]}`,
+  };
+  for _, msg := range rets {
+    if _, err := DecodeRetJSON(msg); !errors.As(err, new(*ParseError)) {
+      t.Errorf("DecodeRetJSON(%q): got %v, want *ParseError", msg, err);
+    }
+  }
+}
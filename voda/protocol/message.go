@@ -0,0 +1,541 @@
+package protocol
+
+import "fmt"
+import "time"
+import "strconv"
+import "strings"
+
+/*
+#message
+メッセージとParam、Retの相互変換
+・Param: ゲームからプレイヤーへのメッセージ
+・Ret  : プレイヤーからゲームへのメッセージ
+*/
+
+// プレイヤーに与える引数
+type Param struct {
+  Command string
+
+  Turn bool // 先後
+
+  Stones uint64      // 石の配置
+  OppStones uint64   // 相手方の石の配置
+  Moves []uint8      // 操作履歴
+  ValidMoves []uint8 // 合法手リスト
+  Clock time.Duration    // 残り時間(0は無制限、json機能)
+  OppClock time.Duration // 相手の残り時間(0は無制限、json機能)
+  Rules string           // ルール(json機能)
+
+  Result uint8// 結果(0:win, 1:lose, 2:draw)
+  Reason string // 終局理由(reason機能)
+
+  Undone int // 取り消した手数(undo、undo機能)
+
+  Version int           // ゲームのプロトコルバージョン(name)
+  Capabilities []string // ゲームが対応する機能(name)
+  Session string        // 再接続に用いるセッショントークン(name、session機能)
+
+  // go中のinfoの受け渡し先(info機能、メッセージには含めない)
+  // ゲームではプレイヤーから受け取ったinfoを渡し、プレイヤーではゲームへ送るinfoを渡す
+  Info func(Ret)
+}
+
+// プレイヤーからの返り値
+type Ret struct {
+  Command string
+
+  Name string // プレイヤー名
+
+  Ready bool //開始の確認
+
+  Move uint8 // 操作
+  Score *int     // 評価値(省略可、json機能)
+  PV []uint8     // 読み筋(省略可、json機能)
+  Comment string // コメント(省略可、json機能)
+
+  Depth int       // 探索の深さ(info、省略時は0)
+  Nodes int64     // 探索した局面数(info、省略時は0)
+  Rates []float64 // 列ごとの勝率(info、0~1、負の値は未評価・置けない列)
+
+  Version int           // プレイヤーのプロトコルバージョン(setname)
+  Capabilities []string // プレイヤーが対応する機能(setname)
+  Session string        // 再接続時に提示するセッショントークン(setname、session機能)
+  Game string           // 希望する対局(setname、ロビーに接続する場合のみ、空の場合はany)
+}
+
+/*
+#EncodeParam
+Paramをメッセージに変換する
+
+*引数
+param Param: 変換するParam
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func EncodeParam(param Param) (string, error) {
+  return encodeParam(param, "-");
+}
+
+/*
+#EncodeLegacyParam
+Paramを、空の一覧を - で表さない以前の形式のメッセージに変換する
+・VERSION_EMPTY_COLSより前のプレイヤー(バージョンを返さないプレイヤーを含む)に送る
+・空の一覧は空の単語となる(ex. 先手の初手は "go 0 0 0123456 ")
+
+*引数
+param Param: 変換するParam
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func EncodeLegacyParam(param Param) (string, error) {
+  return encodeParam(param, "");
+}
+
+/*
+#encodeParam
+Paramをメッセージに変換する
+
+*引数
+param Param : 変換するParam
+empty string: 空の一覧の表記
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func encodeParam(param Param, empty string) (string, error) {
+  switch param.Command {
+  case CMD_NAME:
+    if (param.Version == 0) { return CMD_NAME, nil; }
+    var msg string = fmt.Sprintf("%s %d %s", CMD_NAME, param.Version, encodeCapabilities(param.Capabilities));
+    if (param.Session != "") { msg += " " + param.Session; }
+    return msg, nil;
+  case CMD_START:
+    if (param.Turn) { return CMD_START + " black", nil; }
+    return CMD_START + " white", nil;
+  case CMD_GO:
+    valid_moves, err := encodeCols(param.ValidMoves, empty);
+    if (err != nil) { return "", err; }
+    moves, err := encodeCols(param.Moves, empty);
+    if (err != nil) { return "", err; }
+    return fmt.Sprintf("%s %d %d %s %s", CMD_GO, param.Stones, param.OppStones, valid_moves, moves), nil;
+  case CMD_END:
+    var result string;
+    switch param.Result {
+    case RESULT_WIN: result = "win";
+    case RESULT_LOSE: result = "lose";
+    case RESULT_DRAW: result = "draw";
+    default:
+      return "", &ParseError{ Message: fmt.Sprint(param.Result), Reason: "invalid result" };
+    }
+    if (param.Reason != "") { return CMD_END + " " + result + " " + param.Reason, nil; }
+    return CMD_END + " " + result, nil;
+  case CMD_QUIT:
+    return CMD_QUIT, nil;
+  case CMD_UNDO:
+    moves, err := encodeCols(param.Moves, empty);
+    if (err != nil) { return "", err; }
+    return fmt.Sprintf("%s %d %s", CMD_UNDO, param.Undone, moves), nil;
+  }
+  return "", &ParseError{ Message: param.Command, Reason: "unknown command" };
+}
+
+/*
+#DecodeParam
+メッセージをParamに変換する
+
+*引数
+msg string: ゲームから受け取ったメッセージ
+
+*返り値
+Param: 変換したParam
+error: 解釈できない場合の*ParseError
+*/
+func DecodeParam(msg string) (Param, error) {
+  var words []string = strings.Split(msg, " ");
+  var param Param = Param{ Command: words[0] };
+  var args []string = words[1:];
+
+  switch param.Command {
+  case CMD_NAME:
+    // バージョン、機能が省略された場合はバージョン1とみなす
+    var err error;
+    param.Version, param.Capabilities, err = decodeVersion(args);
+    if (err != nil) { return param, &ParseError{ Message: msg, Reason: err.Error() }; }
+    if (len(args) >= 3) { param.Session = args[2]; }
+    return param, nil;
+  case CMD_QUIT:
+    return param, nil;
+  case CMD_START:
+    if (len(args) < 1) { return param, &ParseError{ Message: msg, Reason: "missing turn" }; }
+    switch args[0] {
+    case "black": param.Turn = true;
+    case "white": param.Turn = false;
+    default: return param, &ParseError{ Message: msg, Reason: "invalid turn" };
+    }
+    return param, nil;
+  case CMD_GO:
+    // 操作履歴が空で省略された場合を許容する
+    if (len(args) == 3) { args = append(args, ""); }
+    if (len(args) < 4) { return param, &ParseError{ Message: msg, Reason: "missing arguments" }; }
+
+    var err error;
+    if param.Stones, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid stones" };
+    }
+    if param.OppStones, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid opponent stones" };
+    }
+    if param.ValidMoves, err = decodeCols(args[2]); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid valid moves" };
+    }
+    if param.Moves, err = decodeCols(args[3]); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid moves" };
+    }
+    return param, nil;
+  case CMD_END:
+    if (len(args) < 1) { return param, &ParseError{ Message: msg, Reason: "missing result" }; }
+    switch args[0] {
+    case "win": param.Result = RESULT_WIN;
+    case "lose": param.Result = RESULT_LOSE;
+    case "draw": param.Result = RESULT_DRAW;
+    default: return param, &ParseError{ Message: msg, Reason: "invalid result" };
+    }
+    if (len(args) >= 2) { param.Reason = args[1]; }
+    return param, nil;
+  case CMD_UNDO:
+    // 操作履歴が空で省略された場合を許容する
+    if (len(args) == 1) { args = append(args, ""); }
+    if (len(args) < 2) { return param, &ParseError{ Message: msg, Reason: "missing arguments" }; }
+
+    var err error;
+    if param.Undone, err = strconv.Atoi(args[0]); err != nil || param.Undone < 1 {
+      return param, &ParseError{ Message: msg, Reason: "invalid count" };
+    }
+    if param.Moves, err = decodeCols(args[1]); err != nil {
+      return param, &ParseError{ Message: msg, Reason: "invalid moves" };
+    }
+    return param, nil;
+  }
+
+  return param, &ParseError{ Message: msg, Reason: "unknown command" };
+}
+
+/*
+#EncodeRet
+Retをメッセージに変換する
+
+*引数
+ret Ret: 変換するRet
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func EncodeRet(ret Ret) (string, error) {
+  switch ret.Command {
+  case CMD_SETNAME:
+    if (ret.Name == "" || strings.ContainsAny(ret.Name, " \t")) {
+      return "", &ParseError{ Message: ret.Name, Reason: "invalid name" };
+    }
+    if (ret.Version == 0) { return CMD_SETNAME + " " + ret.Name, nil; }
+    var msg string = fmt.Sprintf("%s %s %d %s", CMD_SETNAME, ret.Name, ret.Version, encodeCapabilities(ret.Capabilities));
+    if (ret.Game != "") {
+      if (strings.ContainsAny(ret.Game, " \t")) {
+        return "", &ParseError{ Message: ret.Game, Reason: "invalid game" };
+      }
+      // 対局を指定する場合、トークンがなければ - とする
+      var session string = ret.Session;
+      if (session == "") { session = "-"; }
+      return msg + " " + session + " " + ret.Game, nil;
+    }
+    if (ret.Session != "") { msg += " " + ret.Session; }
+    return msg, nil;
+  case CMD_READY:
+    return CMD_READY, nil;
+  case CMD_MOVE:
+    return fmt.Sprintf("%s %d", CMD_MOVE, ret.Move), nil;
+  case CMD_BYE:
+    return CMD_BYE, nil;
+  case CMD_INFO:
+    return encodeInfo(ret);
+  }
+  return "", &ParseError{ Message: ret.Command, Reason: "unknown command" };
+}
+
+/*
+#DecodeRet
+メッセージをRetに変換する
+
+*引数
+msg string: プレイヤーから受け取ったメッセージ
+
+*返り値
+Ret  : 変換したRet
+error: 解釈できない場合の*ParseError
+*/
+func DecodeRet(msg string) (Ret, error) {
+  var words []string = strings.Split(msg, " ");
+  var ret Ret = Ret{ Command: words[0] };
+  var args []string = words[1:];
+
+  switch ret.Command {
+  case CMD_SETNAME:
+    if (len(args) < 1 || args[0] == "") { return ret, &ParseError{ Message: msg, Reason: "missing name" }; }
+    ret.Name = args[0];
+    // バージョン、機能が省略された場合はバージョン1とみなす
+    var err error;
+    ret.Version, ret.Capabilities, err = decodeVersion(args[1:]);
+    if (err != nil) { return ret, &ParseError{ Message: msg, Reason: err.Error() }; }
+    if (len(args) >= 4 && args[3] != "-") { ret.Session = args[3]; }
+    if (len(args) >= 5) { ret.Game = args[4]; }
+    return ret, nil;
+  case CMD_READY:
+    ret.Ready = true;
+    return ret, nil;
+  case CMD_MOVE:
+    if (len(args) < 1) { return ret, &ParseError{ Message: msg, Reason: "missing move" }; }
+    move, err := strconv.ParseUint(args[0], 10, 8);
+    if (err != nil) { return ret, &ParseError{ Message: msg, Reason: "invalid move" }; }
+    ret.Move = uint8(move);
+    return ret, nil;
+  case CMD_BYE:
+    return ret, nil;
+  case CMD_INFO:
+    return decodeInfo(msg, args);
+  }
+
+  return ret, &ParseError{ Message: msg, Reason: "unknown command" };
+}
+
+/*
+#encodeInfo
+infoのRetをメッセージに変換する
+・値のない項目(0、空)は省略する
+
+*引数
+ret Ret: 変換するRet
+
+*返り値
+string: メッセージ
+error : 変換できない場合の*ParseError
+*/
+func encodeInfo(ret Ret) (string, error) {
+  var words []string = []string{ CMD_INFO };
+  if (ret.Depth > 0) { words = append(words, "depth", strconv.Itoa(ret.Depth)); }
+  if (ret.Score != nil) { words = append(words, "score", strconv.Itoa(*ret.Score)); }
+  if (ret.Nodes > 0) { words = append(words, "nodes", strconv.FormatInt(ret.Nodes, 10)); }
+  if (len(ret.PV) > 0) {
+    pv, err := encodeCols(ret.PV, "-");
+    if (err != nil) { return "", err; }
+    words = append(words, "pv", pv);
+  }
+  if (len(ret.Rates) > 0) {
+    var rates []string;
+    for _, rate := range ret.Rates {
+      if (rate < 0) {
+        rates = append(rates, "-");
+      } else {
+        rates = append(rates, strconv.FormatFloat(rate, 'f', 3, 64));
+      }
+    }
+    words = append(words, "rates", strings.Join(rates, ","));
+  }
+  return strings.Join(words, " "), nil;
+}
+
+/*
+#decodeInfo
+infoの項目(名前と値の組)を解釈する
+・未知の項目は値とともに読み飛ばす
+
+*引数
+msg string   : 受け取ったメッセージ
+args []string: コマンドより後の単語
+
+*返り値
+Ret  : 変換したRet
+error: 解釈できない場合の*ParseError
+*/
+func decodeInfo(msg string, args []string) (Ret, error) {
+  var ret Ret = Ret{ Command: CMD_INFO };
+  // 項目のない場合(末尾の空白を含む)
+  if (len(args) == 1 && args[0] == "") { return ret, nil; }
+  if (len(args)%2 != 0) { return ret, &ParseError{ Message: msg, Reason: "missing value" }; }
+
+  for i := 0; i < len(args); i += 2 {
+    var value string = args[i+1];
+    var err error;
+    switch args[i] {
+    case "depth":
+      ret.Depth, err = strconv.Atoi(value);
+    case "score":
+      var score int;
+      score, err = strconv.Atoi(value);
+      ret.Score = &score;
+    case "nodes":
+      ret.Nodes, err = strconv.ParseInt(value, 10, 64);
+    case "pv":
+      ret.PV, err = decodeCols(value);
+    case "rates":
+      ret.Rates, err = decodeRates(value);
+    }
+    if (err != nil) { return ret, &ParseError{ Message: msg, Reason: "invalid " + args[i] }; }
+  }
+  return ret, nil;
+}
+
+/*
+#decodeRates
+列ごとの勝率の一覧を解釈する
+
+*引数
+str string: カンマ区切りの勝率(未評価、置けない列は -)
+
+*返り値
+[]float64: 列ごとの勝率(- は-1)
+error    : 解釈のエラー
+*/
+func decodeRates(str string) ([]float64, error) {
+  var rates []float64 = []float64{};
+  for _, word := range strings.Split(str, ",") {
+    if (word == "-") {
+      rates = append(rates, -1);
+      continue;
+    }
+    rate, err := strconv.ParseFloat(word, 64);
+    if (err != nil || rate < 0 || rate > 1) { return nil, fmt.Errorf("invalid rate `%s`", word); }
+    rates = append(rates, rate);
+  }
+  return rates, nil;
+}
+
+/*
+#encodeCols
+列の一覧を文字列にする
+
+*引数
+cols []uint8: 列の一覧
+empty string: 空の場合の表記(通常は -)
+
+*返り値
+string: 列を連結した文字列
+error : 1文字で表せない列がある場合の*ParseError
+*/
+func encodeCols(cols []uint8, empty string) (string, error) {
+  if (len(cols) == 0) { return empty, nil; }
+
+  var sb strings.Builder;
+  for _, col := range cols {
+    if (col > 9) {
+      return "", &ParseError{ Message: fmt.Sprint(cols), Reason: "column out of range" };
+    }
+    sb.WriteByte('0' + col);
+  }
+  return sb.String(), nil;
+}
+
+/*
+#decodeCols
+文字列を列の一覧にする
+
+*引数
+str string: 列を連結した文字列(空文字列、- は空の一覧)
+
+*返り値
+[]uint8: 列の一覧
+error  : 数字以外を含む場合のエラー
+*/
+func decodeCols(str string) ([]uint8, error) {
+  var cols []uint8 = []uint8{};
+  if (str == "-") { return cols, nil; }
+
+  for _, c := range str {
+    if (c < '0' || c > '9') {
+      return nil, fmt.Errorf("invalid column `%c`", c);
+    }
+    cols = append(cols, uint8(c - '0'));
+  }
+  return cols, nil;
+}
+
+/*
+#encodeCapabilities
+機能の一覧を文字列にする
+
+*引数
+caps []string: 機能の一覧
+
+*返り値
+string: カンマ区切りの文字列(空の場合は -)
+*/
+func encodeCapabilities(caps []string) string {
+  if (len(caps) == 0) { return "-"; }
+  return strings.Join(caps, ",");
+}
+
+/*
+#decodeVersion
+バージョンと機能の一覧を解釈する
+
+*引数
+args []string: バージョン、機能一覧の順の単語(省略可)
+
+*返り値
+int     : バージョン(省略時は1)
+[]string: 機能の一覧
+error   : 解釈のエラー
+*/
+func decodeVersion(args []string) (int, []string, error) {
+  var caps []string = []string{};
+  if (len(args) == 0 || args[0] == "") { return 1, caps, nil; }
+
+  version, err := strconv.Atoi(args[0]);
+  if (err != nil || version < 1) { return 0, nil, fmt.Errorf("invalid version"); }
+
+  if (len(args) >= 2 && args[1] != "-" && args[1] != "") {
+    caps = strings.Split(args[1], ",");
+  }
+  return version, caps, nil;
+}
+
+/*
+#Negotiate
+双方が対応する機能を求める
+
+*引数
+mine []string  : 自身が対応する機能
+theirs []string: 相手が対応する機能
+
+*返り値
+[]string: 双方が対応する機能
+*/
+func Negotiate(mine []string, theirs []string) []string {
+  var caps []string = []string{};
+  for _, cap := range mine {
+    if (HasCapability(theirs, cap)) { caps = append(caps, cap); }
+  }
+  return caps;
+}
+
+/*
+#HasCapability
+機能の一覧に指定の機能が含まれるか判定する
+
+*引数
+caps []string: 機能の一覧
+cap string   : 機能
+
+*返り値
+bool: 含まれるか
+*/
+func HasCapability(caps []string, cap string) bool {
+  for _, c := range caps {
+    if (c == cap) { return true; }
+  }
+  return false;
+}
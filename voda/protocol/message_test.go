@@ -0,0 +1,193 @@
+package protocol
+
+import "errors"
+import "reflect"
+import "strings"
+import "testing"
+
+/*
+#message_test
+テキスト形式のメッセージの変換の検証
+・すべてのメッセージが往復で一致すること
+・不正なメッセージが*ParseErrorとなること
+*/
+
+// 往復の検証に用いる評価値
+var test_score int = -3;
+
+// 往復で一致すべきParam(テキスト形式で表せるもの)
+var text_params []Param = []Param{
+  { Command: CMD_NAME, Version: VERSION, Capabilities: []string{ CAP_REASON, CAP_JSON } },
+  { Command: CMD_NAME, Version: VERSION, Capabilities: []string{}, Session: "0123abcd" },
+  { Command: CMD_START, Turn: true },
+  { Command: CMD_START, Turn: false },
+  { Command: CMD_GO, Stones: 1 << 21, OppStones: 3, ValidMoves: []uint8{ 0, 1, 2, 3, 4, 5, 6 }, Moves: []uint8{ 3, 3, 4, 4 } },
+  { Command: CMD_GO, Stones: 0, OppStones: 0, ValidMoves: []uint8{ 0, 1, 2, 3, 4, 5, 6 }, Moves: []uint8{} },
+  { Command: CMD_END, Result: RESULT_WIN, Reason: "alignment" },
+  { Command: CMD_END, Result: RESULT_LOSE },
+  { Command: CMD_END, Result: RESULT_DRAW, Reason: "full" },
+  { Command: CMD_UNDO, Undone: 2, Moves: []uint8{ 3, 2 } },
+  { Command: CMD_UNDO, Undone: 1, Moves: []uint8{} },
+  { Command: CMD_QUIT },
+};
+
+// 往復で一致すべきRet(テキスト形式で表せるもの)
+var text_rets []Ret = []Ret{
+  { Command: CMD_SETNAME, Name: "g0F", Version: VERSION, Capabilities: []string{ CAP_JSON, CAP_INFO } },
+  { Command: CMD_SETNAME, Name: "g0F", Version: VERSION, Capabilities: []string{}, Session: "0123abcd" },
+  { Command: CMD_SETNAME, Name: "g0F", Version: VERSION, Capabilities: []string{}, Game: "room" },
+  { Command: CMD_READY, Ready: true },
+  { Command: CMD_MOVE, Move: 6 },
+  { Command: CMD_BYE },
+  { Command: CMD_INFO, Depth: 8, Score: &test_score, Nodes: 3500, PV: []uint8{ 3, 3, 4 }, Rates: []float64{ 0.5, -1, 0.25, 1, -1, -1, -1 } },
+  { Command: CMD_INFO },
+};
+
+/*
+#TestTextParamRoundTrip
+テキスト形式のParamが往復で一致すること
+*/
+func TestTextParamRoundTrip(t *testing.T) {
+  for _, param := range text_params {
+    msg, err := EncodeParam(param);
+    if (err != nil) {
+      t.Errorf("EncodeParam(%+v): %v", param, err);
+      continue;
+    }
+    got, err := DecodeParam(msg);
+    if (err != nil) {
+      t.Errorf("DecodeParam(%q): %v", msg, err);
+      continue;
+    }
+    if (!reflect.DeepEqual(got, param)) {
+      t.Errorf("round trip of %q: got %+v, want %+v", msg, got, param);
+    }
+  }
+}
+
+/*
+#TestTextRetRoundTrip
+テキスト形式のRetが往復で一致すること
+*/
+func TestTextRetRoundTrip(t *testing.T) {
+  for _, ret := range text_rets {
+    msg, err := EncodeRet(ret);
+    if (err != nil) {
+      t.Errorf("EncodeRet(%+v): %v", ret, err);
+      continue;
+    }
+    got, err := DecodeRet(msg);
+    if (err != nil) {
+      t.Errorf("DecodeRet(%q): %v", msg, err);
+      continue;
+    }
+    if (!reflect.DeepEqual(got, ret)) {
+      t.Errorf("round trip of %q: got %+v, want %+v", msg, got, ret);
+    }
+  }
+}
+
+/*
+#TestTextMoves
+区切りなしで連結した列の一覧(操作履歴、合法手、読み筋)を解釈できること
+*/
+func TestTextMoves(t *testing.T) {
+  param, err := DecodeParam("go 3 5 0123456 33443");
+  if (err != nil) { t.Fatalf("DecodeParam: %v", err); }
+  if (!reflect.DeepEqual(param.ValidMoves, []uint8{ 0, 1, 2, 3, 4, 5, 6 })) {
+    t.Errorf("valid moves: got %v", param.ValidMoves);
+  }
+  if (!reflect.DeepEqual(param.Moves, []uint8{ 3, 3, 4, 4, 3 })) {
+    t.Errorf("moves: got %v", param.Moves);
+  }
+
+  // 空の履歴は - 、又は省略
+  for _, msg := range []string{ "go 0 0 0123456 -", "go 0 0 0123456", "go 0 0 0123456 " } {
+    param, err := DecodeParam(msg);
+    if (err != nil) {
+      t.Errorf("DecodeParam(%q): %v", msg, err);
+      continue;
+    }
+    if (len(param.Moves) != 0) { t.Errorf("DecodeParam(%q): moves %v, want empty", msg, param.Moves); }
+  }
+
+  msg, err := EncodeParam(Param{ Command: CMD_GO, ValidMoves: []uint8{ 2, 4 }, Moves: []uint8{ 6, 0, 6 } });
+  if (err != nil) { t.Fatalf("EncodeParam: %v", err); }
+  if (msg != "go 0 0 24 606") { t.Errorf("EncodeParam: got %q", msg); }
+
+  ret, err := DecodeRet("info pv 3021");
+  if (err != nil) { t.Fatalf("DecodeRet: %v", err); }
+  if (!reflect.DeepEqual(ret.PV, []uint8{ 3, 0, 2, 1 })) { t.Errorf("pv: got %v", ret.PV); }
+
+  // 1文字で表せない列は送れない
+  _, err = EncodeParam(Param{ Command: CMD_GO, ValidMoves: []uint8{ 10 } });
+  if (!errors.As(err, new(*ParseError))) { t.Errorf("EncodeParam with column 10: got %v, want *ParseError", err); }
+}
+
+/*
+#TestLegacyEmptyCols
+バージョンを確認していないプレイヤーへの空の一覧が以前の形式で解釈できること
+・以前のプレイヤー(player_py)は単語を空白で分割し、履歴の単語がなければ空、あれば各文字を列とする
+*/
+func TestLegacyEmptyCols(t *testing.T) {
+  var param Param = Param{ Command: CMD_GO, ValidMoves: []uint8{ 0, 1, 2, 3, 4, 5, 6 }, Moves: []uint8{} };
+  var codec Codec = Codec{ Legacy: 1 < VERSION_EMPTY_COLS };
+  msg, err := codec.EncodeParam(param);
+  if (err != nil) { t.Fatalf("EncodeParam: %v", err); }
+  if (strings.Contains(msg, "-")) { t.Errorf("legacy go: got %q, want no -", msg); }
+
+  // 以前の規則で解釈
+  var params []string = strings.Fields(msg)[1:];
+  if (len(params) != 3 && len(params) != 4) { t.Fatalf("legacy go %q: %d params", msg, len(params)); }
+  var moves []uint8 = []uint8{};
+  if (len(params) == 4) {
+    for _, c := range params[3] {
+      if (c < '0' || c > '9') { t.Fatalf("legacy go %q: invalid move %q", msg, c); }
+      moves = append(moves, uint8(c - '0'));
+    }
+  }
+  if (len(moves) != 0) { t.Errorf("legacy go %q: moves %v, want empty", msg, moves); }
+
+  // 新しい受信側でも解釈できる
+  got, err := DecodeParam(msg);
+  if (err != nil) { t.Fatalf("DecodeParam(%q): %v", msg, err); }
+  if (!reflect.DeepEqual(got, param)) { t.Errorf("round trip of %q: got %+v, want %+v", msg, got, param); }
+
+  // バージョンを確認したプレイヤーには -
+  msg, err = Codec{}.EncodeParam(param);
+  if (err != nil) { t.Fatalf("EncodeParam: %v", err); }
+  if (msg != "go 0 0 0123456 -") { t.Errorf("go: got %q", msg); }
+}
+
+/*
+#TestParseError
+不正なメッセージが*ParseErrorとなること
+*/
+func TestParseError(t *testing.T) {
+  var params []string = []string{
+    "", "hello", "start", "start red", "go", "go 1 2", "go x 0 0123456 -", "go 0 0 01a -",
+    "end", "end maybe", "undo", "undo 0 -", "undo 1 3x", "name x",
+  };
+  for _, msg := range params {
+    if _, err := DecodeParam(msg); !errors.As(err, new(*ParseError)) {
+      t.Errorf("DecodeParam(%q): got %v, want *ParseError", msg, err);
+    }
+  }
+
+  var rets []string = []string{
+    "", "hello", "setname", "setname g0F x", "move", "move x", "move 256",
+    "info depth", "info depth x", "info rates 0.5,2", "info pv 3a",
+  };
+  for _, msg := range rets {
+    if _, err := DecodeRet(msg); !errors.As(err, new(*ParseError)) {
+      t.Errorf("DecodeRet(%q): got %v, want *ParseError", msg, err);
+    }
+  }
+
+  if _, err := EncodeParam(Param{ Command: CMD_END, Result: 9 }); !errors.As(err, new(*ParseError)) {
+    t.Errorf("EncodeParam with result 9: got %v, want *ParseError", err);
+  }
+  if _, err := EncodeRet(Ret{ Command: CMD_SETNAME, Name: "a b" }); !errors.As(err, new(*ParseError)) {
+    t.Errorf("EncodeRet with name `a b`: got %v, want *ParseError", err);
+  }
+}
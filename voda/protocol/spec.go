@@ -0,0 +1,150 @@
+package protocol
+
+/*
+#プロトコル仕様 (バージョン7)
+ゲーム(voda)とプレイヤーの間のメッセージの仕様
+・ゲームとプレイヤー(player_go)の双方がこのパッケージを用いる
+・仕様を変更する場合はVERSIONを上げる
+
+#フレーミング
+・1メッセージは改行(\n)で終端する1行(frame.go)
+・1行はMAX_LINE_LENGTH文字まで
+・単語は空白1文字で区切り、最初の単語をコマンドとする
+
+#値の表記
+石の配置: 盤面のビット列(uint64)を10進表記
+列      : 0~6
+列の一覧: 列を区切りなしで連結(ex. 0123456)、空の場合は -
+          (VERSION_EMPTY_COLSより前のプレイヤーへは空の単語、#互換性)
+          列は0~6の1文字であるため区切りを必要としない
+機能一覧: 機能名をカンマ区切りで連結(ex. reason)、空の場合は -
+
+#バージョンと機能の確認
+・ゲームはnameで自身のバージョンと対応する機能を通知する
+・プレイヤーはsetnameで自身のバージョンと対応する機能を返す
+・以後、ゲームは双方が対応する機能のみを用いる
+・バージョン、機能を返さないプレイヤーはバージョン1、機能なしとみなす
+
+#ロビー
+・ゲームは1つのポートで複数のプレイヤーの接続を受け付けることができる(lobby)
+・接続したプレイヤーはname/setnameで名前、トークン、希望する対局を伝え、対局の組み合わせを待つ
+・対局が終わってもquitは送られず、接続したまま次の対局を待つ
+・トークンを付けたsetnameは、対局中に切断されたプレイヤーの再接続として扱う
+
+#機能
+reason: endに終局理由を付ける
+json  : setnameの後のメッセージをJSON形式とする(json.go)
+session: 切断後にセッショントークンを提示して再接続する
+undo   : 対局中の手の取り消し(待った)をundoで通知する
+info   : goへの応答(move)の前に、思考の状況をinfoで送る
+
+#再接続 (session機能)
+・ゲームは最初の接続のnameにセッショントークンを付ける
+・切断されたプレイヤーは同じポートに接続し直し、nameへの応答のsetnameに受け取ったトークンを付ける
+・ゲームは再接続を待つ間のnameにはトークンを付けず、トークンが一致しない接続は切断する
+・再接続後、ゲームは切断時に応答を得られなかったコマンドを送り直す
+  対局中であれば現在の局面のgoが送られるため、プレイヤーは局面を保持していなくてよい
+・猶予時間内に再接続されない場合、プレイヤーは負けとなる(終局理由disconnect)
+
+#JSON形式 (json機能)
+・name/setnameの後、双方のメッセージを1行1オブジェクトのJSONとする
+・commandにコマンド名、その他の値はフィールドで表す
+・受信側は未知のフィールドを無視する(フィールドの追加は互換性を損なわない)
+・列、列の一覧は数値、数値の配列で表す
+
+{"command":"start","turn":"black"}
+{"command":"go","stones":0,"opp_stones":1,"moves":[0],"valid_moves":[0,1,2,3,4,5,6],
+ "clock_ms":60000,"opp_clock_ms":60000,"rules":"7x6"}
+  clock_ms, opp_clock_ms は残り時間(ミリ秒)、持ち時間が無制限の場合は省略
+{"command":"end","result":"win","reason":"alignment"}
+{"command":"undo","count":2,"moves":[3,3]}
+{"command":"quit"}
+
+{"command":"ready"}
+{"command":"move","move":3,"score":2,"pv":[3,3,4],"comment":"..."}
+  score(評価値、手番側から見た値), pv(読み筋), comment は省略可
+{"command":"bye"}
+{"command":"info","depth":8,"score":2,"nodes":3500,"pv":[3,3,4],"rates":[0.41,null,0.52,0.6,0.5,0.38,0.3]}
+  いずれの項目も省略可、ratesは列ごとの勝率(0~1、未評価、置けない列はnull)
+
+#ゲーム -> プレイヤー
+name [(バージョン) (機能一覧) [(セッショントークン)]]
+  プレイヤー名、バージョン、機能を要求
+start (black|white)
+  対局開始、自分の手番を通知
+go (石の配置) (相手の石の配置) (合法手の一覧) (操作履歴)
+  次の手を要求
+end (win|lose|draw) [(終局理由)]
+  対局終了、結果を通知
+  終局理由(alignment, full, illegal, disconnect, protocol, abort)はreason機能に対応する場合のみ付ける
+undo (取り消した手数) (操作履歴)
+  手の取り消しを通知(undo機能に対応する場合のみ)
+  操作履歴は取り消した後のもの、以後のgoはこの局面から続く
+quit
+  プレイヤー終了(応答不要)
+
+#プレイヤー -> ゲーム
+setname (プレイヤー名) [(バージョン) (機能一覧) [(セッショントークン|-) [(対局)]]]
+  nameへの応答、名前は空白を含まない
+  トークンは再接続の場合のみ付ける(対局を指定し、トークンがない場合は -)
+  対局はロビーに接続する場合に希望する対局名(省略時はany、いずれの対局でもよい)
+ready
+  start, undoへの応答
+move (列)
+  goへの応答
+bye
+  endへの応答
+info [depth (深さ)] [score (評価値)] [nodes (探索した局面数)] [pv (読み筋)] [rates (列ごとの勝率)]
+  goを受けてからmoveを返すまでの思考の状況(info機能に対応する場合のみ、0回以上)
+  項目は名前と値の組で、いずれも省略可、順不同(未知の項目は値とともに読み飛ばす)
+  列ごとの勝率は0~1の値を7列分カンマ区切りで連結し、未評価、置けない列は - (ex. 0.41,-,0.52,0.6,0.5,0.38,0.3)
+
+#互換性
+・受信側は、空の一覧が - の代わりに省略(末尾の空の単語)されていても受け付ける
+・VERSION_EMPTY_COLSより前のプレイヤー(バージョンを返さないプレイヤーを含む)には、空の一覧を空の単語として送る
+・未知のコマンドは*ParseErrorとして扱う
+*/
+
+// プロトコルのバージョン
+const VERSION int = 7;
+
+// 空の一覧を - として送るバージョン
+const VERSION_EMPTY_COLS int = 7;
+
+// 機能
+const (
+  CAP_REASON string = "reason" // endに終局理由を付ける
+  CAP_JSON string = "json"     // setnameの後のメッセージをJSON形式とする
+  CAP_SESSION string = "session" // 切断後にセッショントークンを提示して再接続する
+  CAP_UNDO string = "undo"       // 手の取り消しをundoで通知する
+  CAP_INFO string = "info"       // moveの前に思考の状況をinfoで送る
+)
+
+// このパッケージが対応する機能
+var CAPABILITIES []string = []string{ CAP_REASON, CAP_JSON, CAP_SESSION, CAP_UNDO, CAP_INFO };
+
+// ゲーム -> プレイヤーのコマンド
+const (
+  CMD_NAME string = "name"
+  CMD_START string = "start"
+  CMD_GO string = "go"
+  CMD_END string = "end"
+  CMD_QUIT string = "quit"
+  CMD_UNDO string = "undo"
+)
+
+// プレイヤー -> ゲームのコマンド
+const (
+  CMD_SETNAME string = "setname"
+  CMD_READY string = "ready"
+  CMD_MOVE string = "move"
+  CMD_BYE string = "bye"
+  CMD_INFO string = "info"
+)
+
+// endで通知する結果
+const (
+  RESULT_WIN uint8 = 0
+  RESULT_LOSE uint8 = 1
+  RESULT_DRAW uint8 = 2
+)
@@ -0,0 +1,509 @@
+package record
+
+import "io"
+import "os"
+import "fmt"
+import "time"
+import "bufio"
+import "strings"
+import "strconv"
+
+import "voda/board"
+
+/*
+#record
+対局の棋譜(PGN形式に準ずる)
+・タグ(ヘッダ)と指し手のリストからなる
+・1つのファイルに複数の対局を空行区切りで記録できる
+・盤面の操作にvoda/boardを利用
+
+#書式
+[Date "2026.10.19"]
+[Black "g0F-Go"]
+[White "RandomPlayer-Go"]
+[Rules "7x6"]
+[TimeControl "-"]
+[Result "1-0"]
+[Termination "alignment"]
+
+1. 3 {[%emt 0.512] 中央} 3 2. 4 4 ... 1-0
+
+・指し手は列番号(0~6)
+・{}内はコメント、[%clk 残り時間(秒)]、[%emt 消費時間(秒)]を含められる
+・結果は 1-0(先手勝), 0-1(後手勝), 1/2-1/2(引き分け), *(不明・中断)
+・補助(ヒント、脅威の表示)を有効にした対局は[Assisted "true"]を付ける(それ以外は省略)
+*/
+
+// 結果
+const (
+  RESULT_BLACK uint8 = 0 // 先手勝
+  RESULT_WHITE uint8 = 1 // 後手勝
+  RESULT_DRAW uint8 = 2 // 引き分け
+  RESULT_UNKNOWN uint8 = 255 // 不明・中断
+)
+
+// 終局理由
+const (
+  TERMINATION_ALIGNMENT string = "alignment" // 4つ揃った
+  TERMINATION_FULL string = "full" // 盤面が埋まった
+  TERMINATION_ILLEGAL string = "illegal" // 非合法手
+  TERMINATION_DISCONNECT string = "disconnect" // プレイヤーとの通信の失敗
+  TERMINATION_PROTOCOL string = "protocol" // プレイヤーのプロトコル違反
+  TERMINATION_ABORT string = "abort" // 中断
+)
+
+// 日付の書式
+const DATE_FORMAT string = "2006.01.02";
+
+// 既定のルール(縦6、横7)
+const DEFAULT_RULES string = "7x6";
+
+// 1局分の棋譜
+type Record struct {
+  Date string        // 対局日
+  Black string       // 先手プレイヤー名
+  White string       // 後手プレイヤー名
+  Rules string       // ルール
+  TimeControl string // 持ち時間("-"は無制限)
+  Result uint8       // 結果
+  Termination string // 終局理由
+  Assisted bool      // 補助(ヒント、脅威の表示)を有効にした対局か
+
+  Moves []Move // 指し手
+}
+
+// 1手分の記録
+type Move struct {
+  Col uint8 // 列
+  Comment string // コメント
+  Clock time.Duration   // 着手後の残り時間(0は記録なし)
+  Elapsed time.Duration // 消費時間(0は記録なし)
+}
+
+/*
+#New
+対局開始時の棋譜を生成する
+
+*引数
+black string: 先手プレイヤー名
+white string: 後手プレイヤー名
+date time.Time: 対局日
+
+*返り値
+Record: 指し手のない棋譜
+*/
+func New(black string, white string, date time.Time) Record {
+  return Record {
+    Date: date.Format(DATE_FORMAT),
+    Black: black,
+    White: white,
+    Rules: DEFAULT_RULES,
+    TimeControl: "-",
+    Result: RESULT_UNKNOWN,
+    Termination: "",
+    Moves: []Move{},
+  };
+}
+
+/*
+#ResultStr
+結果を棋譜の表記に変換する
+
+*引数
+result uint8: 結果
+
+*返り値
+string: 棋譜での表記
+*/
+func ResultStr(result uint8) string {
+  switch result {
+  case RESULT_BLACK: return "1-0";
+  case RESULT_WHITE: return "0-1";
+  case RESULT_DRAW: return "1/2-1/2";
+  default: return "*";
+  }
+}
+
+/*
+#parseResult
+棋譜での表記を結果に変換する
+
+*引数
+str string: 棋譜での表記
+
+*返り値
+uint8: 結果
+bool : 結果の表記であったか
+*/
+func parseResult(str string) (uint8, bool) {
+  switch str {
+  case "1-0": return RESULT_BLACK, true;
+  case "0-1": return RESULT_WHITE, true;
+  case "1/2-1/2": return RESULT_DRAW, true;
+  case "*": return RESULT_UNKNOWN, true;
+  default: return RESULT_UNKNOWN, false;
+  }
+}
+
+/*
+#String
+棋譜を文字列に変換する
+
+*返り値
+string: 棋譜の文字列
+*/
+func (r Record) String() string {
+  var sb strings.Builder;
+
+  // タグ
+  var tags [][2]string = [][2]string {
+    { "Date", r.Date },
+    { "Black", r.Black },
+    { "White", r.White },
+    { "Rules", r.Rules },
+    { "TimeControl", r.TimeControl },
+    { "Result", ResultStr(r.Result) },
+    { "Termination", r.Termination },
+  };
+  if (r.Assisted) { tags = append(tags, [2]string{ "Assisted", "true" }); }
+  for _, tag := range tags {
+    sb.WriteString(fmt.Sprintf("[%s %s]\n", tag[0], strconv.Quote(tag[1])));
+  }
+  sb.WriteString("\n");
+
+  // 指し手
+  var words []string;
+  for i, m := range r.Moves {
+    if (i%2 == 0) { words = append(words, fmt.Sprintf("%d.", i/2+1)); }
+    words = append(words, fmt.Sprintf("%d", m.Col));
+
+    var comment string = m.commentStr();
+    if (comment != "") { words = append(words, comment); }
+  }
+  words = append(words, ResultStr(r.Result));
+
+  // 1行が80文字を超えないよう折り返す
+  var line_len int = 0;
+  for i, word := range words {
+    if (i != 0 && line_len + 1 + len(word) > 80) {
+      sb.WriteString("\n");
+      line_len = 0;
+    } else if (i != 0) {
+      sb.WriteString(" ");
+      line_len++;
+    }
+    sb.WriteString(word);
+    line_len += len(word);
+  }
+  sb.WriteString("\n");
+
+  return sb.String();
+}
+
+// コメントに書けない文字の置き換え
+// {}はコメントの区切りとなるため除き、改行は棋譜の行を分けるため空白とする
+var commentReplacer *strings.Replacer = strings.NewReplacer("{", "", "}", "", "\r\n", " ", "\r", " ", "\n", " ");
+
+/*
+#commentStr
+指し手のコメント部分を生成する
+
+*返り値
+string: {}で囲んだコメント(記録がなければ空文字列)
+*/
+func (m Move) commentStr() string {
+  var parts []string;
+  if (m.Clock != 0) {
+    parts = append(parts, fmt.Sprintf("[%%clk %.3f]", m.Clock.Seconds()));
+  }
+  if (m.Elapsed != 0) {
+    parts = append(parts, fmt.Sprintf("[%%emt %.3f]", m.Elapsed.Seconds()));
+  }
+  if (m.Comment != "") {
+    var comment string = strings.TrimSpace(commentReplacer.Replace(m.Comment));
+    if (comment != "") { parts = append(parts, comment); }
+  }
+
+  if (len(parts) == 0) { return ""; }
+  return "{" + strings.Join(parts, " ") + "}";
+}
+
+/*
+#Save
+棋譜をファイルに追記する
+
+*引数
+path string: 棋譜ファイル
+
+*返り値
+error: 書き込みのエラー
+*/
+func (r Record) Save(path string) error {
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644);
+  if (err != nil) { return err; }
+  defer file.Close();
+
+  _, err = file.WriteString(r.String() + "\n");
+  return err;
+}
+
+/*
+#Load
+ファイルから棋譜を読み込む
+
+*引数
+path string: 棋譜ファイル
+
+*返り値
+[]Record: 棋譜のリスト
+error   : 読み込み、解釈のエラー
+*/
+func Load(path string) ([]Record, error) {
+  file, err := os.Open(path);
+  if (err != nil) { return nil, err; }
+  defer file.Close();
+
+  return Parse(file);
+}
+
+/*
+#Parse
+棋譜を解釈する
+・タグの行に続く指し手の部分を結果の表記まで読む
+
+*引数
+reader io.Reader: 棋譜の入力
+
+*返り値
+[]Record: 棋譜のリスト
+error   : 解釈のエラー
+*/
+func Parse(reader io.Reader) ([]Record, error) {
+  var records []Record;
+  var scanner *bufio.Scanner = bufio.NewScanner(reader);
+
+  var r Record;
+  var in_game bool = false; // 読み込み中の対局があるか
+  var movetext strings.Builder; // 指し手の部分
+  var line_no int = 0;
+
+  for scanner.Scan() {
+    line_no++;
+    var line string = strings.TrimSpace(scanner.Text());
+    if (line == "") { continue; }
+
+    // タグ
+    if (strings.HasPrefix(line, "[") && movetext.Len() == 0) {
+      if (!in_game) {
+        r = Record{ Rules: DEFAULT_RULES, TimeControl: "-", Result: RESULT_UNKNOWN, Moves: []Move{} };
+        in_game = true;
+      }
+      if err := r.parseTag(line); err != nil {
+        return nil, fmt.Errorf("line %d: %w", line_no, err);
+      }
+      continue;
+    }
+
+    if (!in_game) {
+      return nil, fmt.Errorf("line %d: movetext without tags", line_no);
+    }
+
+    movetext.WriteString(line);
+    movetext.WriteString(" ");
+
+    // 結果の表記で指し手の部分が終わる
+    var fields []string = strings.Fields(line);
+    if _, ok := parseResult(fields[len(fields)-1]); !ok { continue; }
+
+    if err := r.parseMovetext(movetext.String()); err != nil {
+      return nil, fmt.Errorf("line %d: %w", line_no, err);
+    }
+    records = append(records, r);
+    in_game = false;
+    movetext.Reset();
+  }
+  if err := scanner.Err(); err != nil { return nil, err; }
+
+  if (in_game) {
+    return nil, fmt.Errorf("unterminated game record");
+  }
+
+  return records, nil;
+}
+
+/*
+#parseTag
+タグの行を解釈し、棋譜に設定する
+・不明なタグは無視する
+
+*引数
+line string: タグの行(ex. [Black "g0F-Go"])
+
+*返り値
+error: 解釈のエラー
+*/
+func (r *Record) parseTag(line string) error {
+  if (!strings.HasSuffix(line, "]")) {
+    return fmt.Errorf("invalid tag `%s`", line);
+  }
+  var inner string = strings.TrimSpace(line[1:len(line)-1]);
+
+  var sep int = strings.Index(inner, " ");
+  if (sep < 0) {
+    return fmt.Errorf("invalid tag `%s`", line);
+  }
+  var key string = inner[:sep];
+  value, err := strconv.Unquote(strings.TrimSpace(inner[sep+1:]));
+  if (err != nil) {
+    return fmt.Errorf("invalid tag value `%s`", line);
+  }
+
+  switch key {
+  case "Date": (*r).Date = value;
+  case "Black": (*r).Black = value;
+  case "White": (*r).White = value;
+  case "Rules": (*r).Rules = value;
+  case "TimeControl": (*r).TimeControl = value;
+  case "Termination": (*r).Termination = value;
+  case "Assisted": (*r).Assisted = value == "true";
+  case "Result":
+    result, ok := parseResult(value);
+    if (!ok) { return fmt.Errorf("invalid result `%s`", value); }
+    (*r).Result = result;
+  }
+
+  return nil;
+}
+
+/*
+#parseMovetext
+指し手の部分を解釈し、棋譜に設定する
+・手番号(ex. 1.)は読み飛ばす
+・コメントは直前の指し手に付ける
+
+*引数
+text string: 指し手の部分
+
+*返り値
+error: 解釈のエラー
+*/
+func (r *Record) parseMovetext(text string) error {
+  var i int = 0;
+  for i < len(text) {
+    var c byte = text[i];
+
+    // 空白
+    if (c == ' ' || c == '\t') {
+      i++;
+      continue;
+    }
+
+    // コメント
+    if (c == '{') {
+      var end int = strings.Index(text[i:], "}");
+      if (end < 0) { return fmt.Errorf("unterminated comment"); }
+      if (len(r.Moves) == 0) { return fmt.Errorf("comment before first move"); }
+      (*r).Moves[len(r.Moves)-1].parseComment(text[i+1:i+end]);
+      i += end + 1;
+      continue;
+    }
+
+    // 空白またはコメントまでを1語とする
+    var j int = i;
+    for j < len(text) && text[j] != ' ' && text[j] != '\t' && text[j] != '{' { j++; }
+    var word string = text[i:j];
+    i = j;
+
+    // 手番号
+    if (strings.HasSuffix(word, ".")) { continue; }
+
+    // 結果
+    if _, ok := parseResult(word); ok { return nil; }
+
+    // 非合法手で終局した場合に備え、範囲外の列も読み込む
+    col, err := strconv.ParseUint(word, 10, 8);
+    if (err != nil) {
+      return fmt.Errorf("invalid move `%s`", word);
+    }
+    (*r).Moves = append(r.Moves, Move{ Col: uint8(col) });
+  }
+
+  return nil;
+}
+
+/*
+#parseComment
+コメントから時間の記録を取り出す
+
+*引数
+comment string: {}の内側
+*/
+func (m *Move) parseComment(comment string) {
+  var text string = strings.Join(strings.Fields(comment), " ");
+
+  // [%clk 秒], [%emt 秒]を取り出す
+  for _, cmd := range []string{ "clk", "emt" } {
+    var prefix string = "[%" + cmd + " ";
+    var start int = strings.Index(text, prefix);
+    if (start < 0) { continue; }
+    var end int = strings.Index(text[start:], "]");
+    if (end < 0) { continue; }
+
+    seconds, err := strconv.ParseFloat(text[start+len(prefix):start+end], 64);
+    if (err == nil) {
+      var d time.Duration = time.Duration(seconds * float64(time.Second));
+      if (cmd == "clk") { (*m).Clock = d; } else { (*m).Elapsed = d; }
+    }
+    text = strings.TrimSpace(text[:start] + text[start+end+1:]);
+  }
+
+  (*m).Comment = text;
+}
+
+/*
+#Position
+指定手数までの指し手を適用した盤面を生成する
+・指し手の正当性を検証する
+
+*引数
+ply int: 手数(0で初期盤面、負の値で最終局面)
+  非合法手で終局した棋譜の最終局面はエラーとなる
+
+*返り値
+uint64: 先手の石
+uint64: 後手の石
+error : 非合法手が含まれる場合のエラー
+*/
+func (r Record) Position(ply int) (uint64, uint64, error) {
+  if (ply < 0 || ply > len(r.Moves)) { ply = len(r.Moves); }
+
+  var black_stones uint64 = 0;
+  var white_stones uint64 = 0;
+  for i:=0; i<ply; i++ {
+    var col uint8 = r.Moves[i].Col;
+    if (col > 6 || !board.CanMove(black_stones, white_stones, col)) {
+      return black_stones, white_stones, fmt.Errorf("illegal move %d at ply %d", col, i+1);
+    }
+
+    if (i%2 == 0) {
+      black_stones = board.MakeMove(black_stones, white_stones, col);
+    } else {
+      white_stones = board.MakeMove(white_stones, black_stones, col);
+    }
+  }
+
+  return black_stones, white_stones, nil;
+}
+
+/*
+#Cols
+指し手の列のみを取り出す
+
+*返り値
+[]uint8: 列のリスト
+*/
+func (r Record) Cols() []uint8 {
+  var cols []uint8 = []uint8{};
+  for _, m := range r.Moves { cols = append(cols, m.Col); }
+  return cols;
+}
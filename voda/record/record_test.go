@@ -0,0 +1,62 @@
+package record
+
+import "time"
+import "strings"
+import "testing"
+import "path/filepath"
+
+/*
+#record_test
+棋譜の書き込みと読み込みの検証
+・書き込んだ棋譜を読み込むと同じ内容となること
+*/
+
+/*
+#TestRoundTrip
+書き込んだ棋譜を読み込み直すと、タグ、指し手、時間、コメントが一致すること
+コメント中の{}は除かれ、改行は空白となること
+*/
+func TestRoundTrip(t *testing.T) {
+  var r Record = New("g0F \"Go\"", "Random{}", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC));
+  r.Result = RESULT_BLACK;
+  r.Termination = TERMINATION_ALIGNMENT;
+  r.Assisted = true;
+  for i, col := range []uint8{ 3, 3, 2, 4, 1, 5, 0 } {
+    var m Move = Move{ Col: col, Elapsed: time.Duration(i+1) * 250 * time.Millisecond };
+    if (i%2 == 0) { m.Clock = time.Duration(60 - i) * time.Second; }
+    r.Moves = append(r.Moves, m);
+  }
+  // 長いコメントで折り返しも確かめる
+  r.Moves[0].Comment = "center {best} move";
+  r.Moves[1].Comment = "line1\r\nline2\nline3\rline4 " + strings.Repeat("long ", 20);
+  r.Moves[2].Comment = "}{";
+  r.Moves[6].Comment = "1-0";
+
+  var want Record = r;
+  want.Moves = append([]Move{}, r.Moves...);
+  want.Moves[0].Comment = "center best move";
+  want.Moves[1].Comment = "line1 line2 line3 line4 " + strings.TrimSpace(strings.Repeat("long ", 20));
+  want.Moves[2].Comment = "";
+
+  var path string = filepath.Join(t.TempDir(), "records.pgn");
+  for i := 0; i < 2; i++ {
+    if err := r.Save(path); err != nil { t.Fatalf("Save: %v", err); }
+  }
+  records, err := Load(path);
+  if (err != nil) { t.Fatalf("Load: %v\n%s", err, r.String()); }
+  if (len(records) != 2) { t.Fatalf("Load: got %d records, want 2", len(records)); }
+
+  for _, got := range records {
+    if (got.Date != want.Date || got.Black != want.Black || got.White != want.White || got.Rules != want.Rules ||
+        got.TimeControl != want.TimeControl || got.Result != want.Result ||
+        got.Termination != want.Termination || got.Assisted != want.Assisted) {
+      t.Errorf("tags: got %+v, want %+v", got, want);
+    }
+    if (len(got.Moves) != len(want.Moves)) {
+      t.Fatalf("moves: got %v, want %v\n%s", got.Moves, want.Moves, r.String());
+    }
+    for i := range want.Moves {
+      if (got.Moves[i] != want.Moves[i]) { t.Errorf("move %d: got %+v, want %+v", i, got.Moves[i], want.Moves[i]); }
+    }
+  }
+}